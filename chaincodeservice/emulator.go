@@ -0,0 +1,723 @@
+package chaincodeservice
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"service-client/keys"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// Emulator is an in-process stand-in for the Fabric network. It implements the
+// ds_query and ds_service (ERC-721) chaincodes well enough to run the
+// publish/apply/approve/fetch flow without the fabric-samples test network.
+// Every enrolled identity gets a certificate from a per-MSP CA, so account IDs
+// look like the ones returned by ClientAccountID on a real network. Failures
+// the contracts act on are returned as the sentinel errors of errors.go, not
+// in the words of the deployed chaincodes.
+type Emulator struct {
+	mu         sync.Mutex
+	cas        map[string]*emulatedCA
+	chaincodes map[string]*emulatedChaincode
+	blockNum   uint64
+	txNum      uint64
+	events     []*client.ChaincodeEvent
+	committed  chan struct{}
+}
+
+type emulatedCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// EmulatorClient is a Ledger bound to one enrolled identity of an Emulator.
+type EmulatorClient struct {
+	emulator    *Emulator
+	MSPID       string
+	AccountID   string
+	Certificate *x509.Certificate
+}
+
+var _ Ledger = (*EmulatorClient)(nil)
+var _ EventSource = (*Emulator)(nil)
+
+type emulatedToken struct {
+	TokenID  string `json:"tokenId"`
+	Owner    string `json:"owner"`
+	TokenURI string `json:"tokenURI"`
+	Approved string `json:"approved"`
+}
+
+type emulatedChaincode struct {
+	queries map[string]Query
+
+	initialized bool
+	name        string
+	symbol      string
+	ownerMSPID  string
+	tokens      map[string]*emulatedToken
+	// operators[owner][operator] is set while owner approves operator
+	operators map[string]map[string]bool
+}
+
+type emulatedTx struct {
+	cc       *emulatedChaincode
+	mspID    string
+	clientID string
+	event    *client.ChaincodeEvent
+}
+
+type emulatedFunction func(tx *emulatedTx, args []string) (string, error)
+
+var emulatedFunctions = map[string]struct {
+	nArgs int
+	fn    emulatedFunction
+}{
+	// ds_query
+	"CreateQuery":  {11, emulatedCreateQuery},
+	"ReadQuery":    {1, emulatedReadQuery},
+	"QueryExists":  {1, emulatedQueryExists},
+	"GetAllQuerys": {0, emulatedGetAllQuerys},
+	// ds_service
+	"Initialize":           {3, emulatedInitialize},
+	"Name":                 {0, func(tx *emulatedTx, _ []string) (string, error) { return tx.cc.name, tx.cc.checkInitialized() }},
+	"Symbol":               {0, func(tx *emulatedTx, _ []string) (string, error) { return tx.cc.symbol, tx.cc.checkInitialized() }},
+	"OwnerMSPID":           {0, func(tx *emulatedTx, _ []string) (string, error) { return tx.cc.ownerMSPID, tx.cc.checkInitialized() }},
+	"ClientAccountID":      {0, func(tx *emulatedTx, _ []string) (string, error) { return tx.clientID, nil }},
+	"MintWithTokenURI":     {2, emulatedMintWithTokenURI},
+	"Burn":                 {1, emulatedBurn},
+	"TransferFrom":         {3, emulatedTransferFrom},
+	"OwnerOf":              {1, emulatedOwnerOf},
+	"TokenURI":             {1, emulatedTokenURI},
+	"TotalSupply":          {0, emulatedTotalSupply},
+	"BalanceOf":            {1, emulatedBalanceOf},
+	"BalanceOfByURI":       {2, emulatedBalanceOfByURI},
+	"BalanceOfByURIPrefix": {2, emulatedBalanceOfByURIPrefix},
+	"Approve":              {2, emulatedApprove},
+	"GetApproved":          {1, emulatedGetApproved},
+	"SetApprovalForAll":    {2, emulatedSetApprovalForAll},
+	"IsApprovedForAll":     {2, emulatedIsApprovedForAll},
+}
+
+func NewEmulator() *Emulator {
+	return &Emulator{
+		cas:        make(map[string]*emulatedCA),
+		chaincodes: make(map[string]*emulatedChaincode),
+		committed:  make(chan struct{}),
+	}
+}
+
+// Enroll issues a new identity for mspID and returns an OrgSetup carrying its
+// keys together with the Ledger that transacts as that identity.
+func (e *Emulator) Enroll(orgName, mspID, commonName string) (*OrgSetup, *EmulatorClient, error) {
+	return e.EnrollAlgorithm(orgName, mspID, commonName, keys.P256)
+}
+
+// EnrollAlgorithm is Enroll with a key of one of the algorithms of package
+// keys.
+func (e *Emulator) EnrollAlgorithm(orgName, mspID, commonName, algorithm string) (*OrgSetup, *EmulatorClient, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ca, err := e.getCA(orgName, mspID)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateKey, err := generateKey(algorithm)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key for %s: %w", commonName, err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: e.nextSerial(),
+		Subject: pkix.Name{
+			CommonName:         commonName,
+			OrganizationalUnit: []string{"client"},
+			Locality:           []string{"San Francisco"},
+			Province:           []string{"California"},
+			Country:            []string{"US"},
+		},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, privateKey.Public(), ca.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to issue certificate for %s: %w", commonName, err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ledger := &EmulatorClient{
+		emulator:    e,
+		MSPID:       mspID,
+		AccountID:   AccountIDFromCertificate(certificate),
+		Certificate: certificate,
+	}
+	setup := &OrgSetup{
+		OrgName:          orgName,
+		MSPID:            mspID,
+		Certificate:      certificate,
+		PublicKey:        privateKey.Public(),
+		PrivateKeySigner: privateKey,
+		PrivateKey:       privateKey,
+		Identity:         ledger.AccountID,
+	}
+	return setup, ledger, nil
+}
+
+func generateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case keys.P256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case keys.P384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case keys.Ed25519:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm %q", algorithm)
+	}
+}
+
+func (e *Emulator) getCA(orgName, mspID string) (*emulatedCA, error) {
+	if ca, ok := e.cas[mspID]; ok {
+		return ca, nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key for %s: %w", mspID, err)
+	}
+	domain := strings.ToLower(orgName) + ".example.com"
+	template := &x509.Certificate{
+		SerialNumber: e.nextSerial(),
+		Subject: pkix.Name{
+			CommonName:   "ca." + domain,
+			Organization: []string{domain},
+			Locality:     []string{"Durham"},
+			Province:     []string{"North Carolina"},
+			Country:      []string{"US"},
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA for %s: %w", mspID, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	ca := &emulatedCA{cert: cert, key: key}
+	e.cas[mspID] = ca
+	return ca, nil
+}
+
+// CACertificate returns the root certificate of an MSP known to the emulator.
+func (e *Emulator) CACertificate(mspID string) (*x509.Certificate, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ca, ok := e.cas[mspID]
+	if !ok {
+		return nil, false
+	}
+	return ca.cert, true
+}
+
+func (e *Emulator) nextSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	return serial
+}
+
+func (e *Emulator) chaincode(chainCodeName, channelID string) *emulatedChaincode {
+	key := channelID + "/" + chainCodeName
+	cc, ok := e.chaincodes[key]
+	if !ok {
+		cc = &emulatedChaincode{
+			queries:   make(map[string]Query),
+			tokens:    make(map[string]*emulatedToken),
+			operators: make(map[string]map[string]bool),
+		}
+		e.chaincodes[key] = cc
+	}
+	return cc
+}
+
+// execute runs function as client. Unless commit is set, the transaction runs
+// against a copy of the world state, like an evaluation on a peer.
+func (e *Emulator) execute(c *EmulatorClient, chainCodeName, channelID, function string, args []string, commit bool) (string, string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	f, ok := emulatedFunctions[function]
+	if !ok {
+		return "", "", fmt.Errorf("Function %s not found in contract SmartContract", function)
+	}
+	if len(args) != f.nArgs {
+		return "", "", fmt.Errorf("incorrect number of params for %s. Expected %d, received %d", function, f.nArgs, len(args))
+	}
+
+	cc := e.chaincode(chainCodeName, channelID)
+	if !commit {
+		cc = cc.clone()
+	}
+	tx := &emulatedTx{cc: cc, mspID: c.MSPID, clientID: c.AccountID}
+	txID := e.newTxID(c)
+	result, err := f.fn(tx, args)
+	if err != nil || !commit {
+		return result, txID, err
+	}
+
+	e.blockNum++
+	if tx.event != nil {
+		tx.event.BlockNumber = e.blockNum
+		tx.event.TransactionID = txID
+		tx.event.ChaincodeName = chainCodeName
+		e.events = append(e.events, tx.event)
+	}
+	close(e.committed)
+	e.committed = make(chan struct{})
+	return result, txID, nil
+}
+
+func (e *Emulator) newTxID(c *EmulatorClient) string {
+	e.txNum++
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d", c.AccountID, e.txNum, time.Now().UnixNano())))
+	return hex.EncodeToString(hash[:])
+}
+
+// ChaincodeEvents streams the events of a chaincode with the same checkpoint
+// semantics as the gateway. Every emulated transaction is its own block.
+func (e *Emulator) ChaincodeEvents(ctx context.Context, chainCodeName, channelID string, checkpoint client.Checkpoint) (<-chan *client.ChaincodeEvent, error) {
+	e.mu.Lock()
+	startBlock, afterTxID := e.blockNum+1, ""
+	if start, ok := checkpoint.(StartBlock); ok {
+		startBlock = uint64(start)
+	} else if checkpoint != nil && (checkpoint.BlockNumber() != 0 || checkpoint.TransactionID() != "") {
+		startBlock, afterTxID = checkpoint.BlockNumber(), checkpoint.TransactionID()
+	}
+	e.mu.Unlock()
+
+	results := make(chan *client.ChaincodeEvent)
+	go func() {
+		defer close(results)
+		next := 0
+		for {
+			e.mu.Lock()
+			var pending []*client.ChaincodeEvent
+			for ; next < len(e.events); next++ {
+				event := e.events[next]
+				if event.ChaincodeName != chainCodeName || event.BlockNumber < startBlock {
+					continue
+				}
+				if event.BlockNumber == startBlock && afterTxID != "" {
+					if event.TransactionID == afterTxID {
+						afterTxID = ""
+					}
+					continue
+				}
+				pending = append(pending, event)
+			}
+			committed := e.committed
+			e.mu.Unlock()
+
+			for _, event := range pending {
+				select {
+				case results <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-committed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return results, nil
+}
+
+func (c *EmulatorClient) Query(chainCodeName, channelID, function string, args []string) (string, error) {
+	result, _, err := c.emulator.execute(c, chainCodeName, channelID, function, args, false)
+	return result, err
+}
+
+func (c *EmulatorClient) Invoke(chainCodeName, channelID, function string, args []string) (string, error) {
+	result, txID, err := c.emulator.execute(c, chainCodeName, channelID, function, args, true)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Transaction ID : %s Response: %s", txID, result), nil
+}
+
+func (c *EmulatorClient) Submit(chainCodeName, channelID, function string, args []string) error {
+	_, _, err := c.emulator.execute(c, chainCodeName, channelID, function, args, true)
+	if err != nil {
+		return fmt.Errorf("failed to submit transaction: %w", err)
+	}
+	return nil
+}
+
+func (cc *emulatedChaincode) clone() *emulatedChaincode {
+	copied := *cc
+	copied.queries = make(map[string]Query, len(cc.queries))
+	for k, v := range cc.queries {
+		copied.queries[k] = v
+	}
+	copied.tokens = make(map[string]*emulatedToken, len(cc.tokens))
+	for k, v := range cc.tokens {
+		token := *v
+		copied.tokens[k] = &token
+	}
+	copied.operators = make(map[string]map[string]bool, len(cc.operators))
+	for owner, operators := range cc.operators {
+		copied.operators[owner] = make(map[string]bool, len(operators))
+		for operator, approved := range operators {
+			copied.operators[owner][operator] = approved
+		}
+	}
+	return &copied
+}
+
+func (cc *emulatedChaincode) checkInitialized() error {
+	if !cc.initialized {
+		return fmt.Errorf("contract options need to be set before calling any function, call Initialize() to initialize contract")
+	}
+	return nil
+}
+
+func (tx *emulatedTx) setEvent(name string, payload interface{}) {
+	data, _ := json.Marshal(payload)
+	tx.event = &client.ChaincodeEvent{EventName: name, Payload: data}
+}
+
+// ======= ds_query =======
+
+func emulatedCreateQuery(tx *emulatedTx, args []string) (string, error) {
+	queryID := args[8]
+	if _, ok := tx.cc.queries[queryID]; ok {
+		return "", fmt.Errorf("%w: %s", ErrQueryExists, queryID)
+	}
+	dataRows, err := strconv.Atoi(args[2])
+	if err != nil {
+		return "", fmt.Errorf("invalid DataRows %s: %w", args[2], err)
+	}
+	timestamp, err := strconv.Atoi(args[10])
+	if err != nil {
+		return "", fmt.Errorf("invalid Timestamp %s: %w", args[10], err)
+	}
+	query := Query{
+		Certificate:    args[0],
+		DataDigest:     args[1],
+		DataRows:       dataRows,
+		InitiatorID:    args[3],
+		InitiatorMSPID: args[4],
+		Legitimacy:     args[5],
+		QueriedTable:   args[6],
+		QueryDigest:    args[7],
+		QueryID:        queryID,
+		ServiceID:      args[9],
+		Timestamp:      timestamp,
+	}
+	tx.cc.queries[queryID] = query
+	tx.setEvent("CreateQuery", query)
+	return "", nil
+}
+
+func emulatedReadQuery(tx *emulatedTx, args []string) (string, error) {
+	query, ok := tx.cc.queries[args[0]]
+	if !ok {
+		return "", fmt.Errorf("the query %s does not exist", args[0])
+	}
+	data, err := json.Marshal(query)
+	return string(data), err
+}
+
+func emulatedQueryExists(tx *emulatedTx, args []string) (string, error) {
+	_, ok := tx.cc.queries[args[0]]
+	return strconv.FormatBool(ok), nil
+}
+
+func emulatedGetAllQuerys(tx *emulatedTx, _ []string) (string, error) {
+	// range queries on a peer return keys in lexical order
+	keys := make([]string, 0, len(tx.cc.queries))
+	for k := range tx.cc.queries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	queries := make([]Query, 0, len(keys))
+	for _, k := range keys {
+		queries = append(queries, tx.cc.queries[k])
+	}
+	data, err := json.Marshal(queries)
+	return string(data), err
+}
+
+// ======= ds_service =======
+
+// normalizeAccount strips whitespace from an account ID. ds_service compares
+// owners this way because the routers pass recipient IDs without spaces.
+func normalizeAccount(account string) string {
+	return strings.ReplaceAll(account, " ", "")
+}
+
+func emulatedInitialize(tx *emulatedTx, args []string) (string, error) {
+	if tx.cc.initialized {
+		return "", fmt.Errorf("contract options are already set, client is not authorized to change them")
+	}
+	tx.cc.initialized = true
+	tx.cc.name, tx.cc.symbol, tx.cc.ownerMSPID = args[0], args[1], args[2]
+	return "true", nil
+}
+
+func emulatedMintWithTokenURI(tx *emulatedTx, args []string) (string, error) {
+	if err := tx.cc.checkInitialized(); err != nil {
+		return "", err
+	}
+	if tx.mspID != tx.cc.ownerMSPID {
+		return "", fmt.Errorf("client is not authorized to mint new tokens")
+	}
+	tokenID, tokenURI := args[0], args[1]
+	if _, ok := tx.cc.tokens[tokenID]; ok {
+		return "", fmt.Errorf("%w: %s", ErrTokenExists, tokenID)
+	}
+	token := &emulatedToken{TokenID: tokenID, Owner: normalizeAccount(tx.clientID), TokenURI: tokenURI}
+	tx.cc.tokens[tokenID] = token
+	tx.setEvent("Transfer", map[string]string{"from": "0x0", "to": token.Owner, "tokenId": tokenID})
+	data, err := json.Marshal(token)
+	return string(data), err
+}
+
+func emulatedBurn(tx *emulatedTx, args []string) (string, error) {
+	if err := tx.cc.checkInitialized(); err != nil {
+		return "", err
+	}
+	tokenID := args[0]
+	token, ok := tx.cc.tokens[tokenID]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrTokenNotFound, tokenID)
+	}
+	if token.Owner != normalizeAccount(tx.clientID) {
+		return "", fmt.Errorf("non-fungible token %s is not owned by %s", tokenID, tx.clientID)
+	}
+	delete(tx.cc.tokens, tokenID)
+	tx.setEvent("Transfer", map[string]string{"from": token.Owner, "to": "0x0", "tokenId": tokenID})
+	return "true", nil
+}
+
+func emulatedTransferFrom(tx *emulatedTx, args []string) (string, error) {
+	if err := tx.cc.checkInitialized(); err != nil {
+		return "", err
+	}
+	from, to, tokenID := normalizeAccount(args[0]), normalizeAccount(args[1]), args[2]
+	token, ok := tx.cc.tokens[tokenID]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrTokenNotFound, tokenID)
+	}
+	sender := normalizeAccount(tx.clientID)
+	if token.Owner != sender && token.Approved != sender && !tx.cc.operators[token.Owner][sender] {
+		return "", fmt.Errorf("the sender is not the current owner nor an authorized operator")
+	}
+	if token.Owner != from {
+		return "", fmt.Errorf("the from is not the current owner")
+	}
+	token.Approved = ""
+	token.Owner = to
+	tx.setEvent("Transfer", map[string]string{"from": from, "to": to, "tokenId": tokenID})
+	return "true", nil
+}
+
+func emulatedApprove(tx *emulatedTx, args []string) (string, error) {
+	if err := tx.cc.checkInitialized(); err != nil {
+		return "", err
+	}
+	operator, tokenID := normalizeAccount(args[0]), args[1]
+	token, ok := tx.cc.tokens[tokenID]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrTokenNotFound, tokenID)
+	}
+	sender := normalizeAccount(tx.clientID)
+	if token.Owner != sender && !tx.cc.operators[token.Owner][sender] {
+		return "", fmt.Errorf("the sender is not the current owner nor an authorized operator")
+	}
+	token.Approved = operator
+	tx.setEvent("Approval", map[string]string{"owner": token.Owner, "approved": operator, "tokenId": tokenID})
+	return "true", nil
+}
+
+func emulatedGetApproved(tx *emulatedTx, args []string) (string, error) {
+	if err := tx.cc.checkInitialized(); err != nil {
+		return "", err
+	}
+	token, ok := tx.cc.tokens[args[0]]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrTokenNotFound, args[0])
+	}
+	return token.Approved, nil
+}
+
+func emulatedSetApprovalForAll(tx *emulatedTx, args []string) (string, error) {
+	if err := tx.cc.checkInitialized(); err != nil {
+		return "", err
+	}
+	operator := normalizeAccount(args[0])
+	approved, err := strconv.ParseBool(args[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid approved %s: %w", args[1], err)
+	}
+	owner := normalizeAccount(tx.clientID)
+	if tx.cc.operators[owner] == nil {
+		tx.cc.operators[owner] = make(map[string]bool)
+	}
+	tx.cc.operators[owner][operator] = approved
+	tx.setEvent("ApprovalForAll", map[string]interface{}{"owner": owner, "operator": operator, "approved": approved})
+	return "true", nil
+}
+
+func emulatedIsApprovedForAll(tx *emulatedTx, args []string) (string, error) {
+	if err := tx.cc.checkInitialized(); err != nil {
+		return "", err
+	}
+	return strconv.FormatBool(tx.cc.operators[normalizeAccount(args[0])][normalizeAccount(args[1])]), nil
+}
+
+func emulatedOwnerOf(tx *emulatedTx, args []string) (string, error) {
+	token, ok := tx.cc.tokens[args[0]]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrTokenNotFound, args[0])
+	}
+	return token.Owner, nil
+}
+
+func emulatedTokenURI(tx *emulatedTx, args []string) (string, error) {
+	if err := tx.cc.checkInitialized(); err != nil {
+		return "", err
+	}
+	token, ok := tx.cc.tokens[args[0]]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrTokenNotFound, args[0])
+	}
+	return token.TokenURI, nil
+}
+
+func emulatedTotalSupply(tx *emulatedTx, _ []string) (string, error) {
+	if err := tx.cc.checkInitialized(); err != nil {
+		return "", err
+	}
+	return strconv.Itoa(len(tx.cc.tokens)), nil
+}
+
+func (cc *emulatedChaincode) countTokens(owner string, match func(uri string) bool) string {
+	owner = normalizeAccount(owner)
+	count := 0
+	for _, token := range cc.tokens {
+		if token.Owner == owner && match(token.TokenURI) {
+			count++
+		}
+	}
+	return strconv.Itoa(count)
+}
+
+func emulatedBalanceOf(tx *emulatedTx, args []string) (string, error) {
+	return tx.cc.countTokens(args[0], func(string) bool { return true }), tx.cc.checkInitialized()
+}
+
+func emulatedBalanceOfByURI(tx *emulatedTx, args []string) (string, error) {
+	return tx.cc.countTokens(args[0], func(uri string) bool { return uri == args[1] }), tx.cc.checkInitialized()
+}
+
+func emulatedBalanceOfByURIPrefix(tx *emulatedTx, args []string) (string, error) {
+	return tx.cc.countTokens(args[0], func(uri string) bool { return strings.HasPrefix(uri, args[1]) }), tx.cc.checkInitialized()
+}
+
+// ======= identities =======
+
+// AccountIDFromCertificate derives the account ID the way ClientAccountID
+// does on chain: "x509::<subject DN>::<issuer DN>".
+func AccountIDFromCertificate(cert *x509.Certificate) string {
+	return fmt.Sprintf("x509::%s::%s", distinguishedName(&cert.Subject), distinguishedName(&cert.Issuer))
+}
+
+var attributeTypeNames = map[string]string{
+	"2.5.4.6":  "C",
+	"2.5.4.10": "O",
+	"2.5.4.11": "OU",
+	"2.5.4.3":  "CN",
+	"2.5.4.5":  "SERIALNUMBER",
+	"2.5.4.7":  "L",
+	"2.5.4.8":  "ST",
+	"2.5.4.9":  "STREET",
+	"2.5.4.17": "POSTALCODE",
+}
+
+// distinguishedName mirrors getDN in the chaincode shim's cid package.
+func distinguishedName(name *pkix.Name) string {
+	r := name.ToRDNSequence()
+	s := ""
+	for i := 0; i < len(r); i++ {
+		rdn := r[len(r)-1-i]
+		if i > 0 {
+			s += ","
+		}
+		for j, tv := range rdn {
+			if j > 0 {
+				s += "+"
+			}
+			typeString := tv.Type.String()
+			typeName, ok := attributeTypeNames[typeString]
+			if !ok {
+				derBytes, err := asn1.Marshal(tv.Value)
+				if err == nil {
+					s += typeString + "=#" + hex.EncodeToString(derBytes)
+					continue
+				}
+				typeName = typeString
+			}
+			valueString := fmt.Sprint(tv.Value)
+			escaped := ""
+			begin := 0
+			for idx, c := range valueString {
+				if (idx == 0 && (c == ' ' || c == '#')) || (idx == len(valueString)-1 && c == ' ') {
+					escaped += valueString[begin:idx]
+					escaped += "\\" + string(c)
+					begin = idx + 1
+					continue
+				}
+				switch c {
+				case ',', '+', '"', '\\', '<', '>', ';':
+					escaped += valueString[begin:idx]
+					escaped += "\\" + string(c)
+					begin = idx + 1
+				}
+			}
+			escaped += valueString[begin:]
+			s += typeName + "=" + escaped
+		}
+	}
+	return s
+}
@@ -0,0 +1,74 @@
+package chaincodeservice
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestServiceContract(t *testing.T) (*Emulator, *ServiceContract) {
+	t.Helper()
+	e := NewEmulator()
+	orgSetup, ledger, err := e.Enroll("Org1", "Org1MSP", "publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := &ServiceContract{OrgSetup: orgSetup, Ledger: ledger, ChaincodeName: "ds_service", ChannelID: "ch"}
+	if err := cc.Initialize("service", "SVC", "Org1MSP"); err != nil {
+		t.Fatal(err)
+	}
+	return e, cc
+}
+
+func TestMintTakenTokenID(t *testing.T) {
+	_, cc := newTestServiceContract(t)
+	if err := cc.MintWithTokenURI("0", "a"); err != nil {
+		t.Fatal(err)
+	}
+	err := cc.MintWithTokenURI("0", "b")
+	if !errors.Is(err, ErrTokenExists) {
+		t.Fatalf("minting a taken ID: got %v, want ErrTokenExists", err)
+	}
+
+	tokenID, err := cc.mintNext(func(string) (string, bool) { return "c", true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenID != "1" {
+		t.Fatalf("mintNext took token %s, want 1", tokenID)
+	}
+}
+
+func TestMissingTokenIsNotFound(t *testing.T) {
+	_, cc := newTestServiceContract(t)
+	if _, err := cc.TokenURI("7"); !isNFTNotFound(err) {
+		t.Fatalf("TokenURI of a missing token: got %v, want not found", err)
+	}
+	if err := cc.Burn("7"); !isNFTNotFound(err) {
+		t.Fatalf("Burn of a missing token: got %v, want not found", err)
+	}
+}
+
+func TestNewServiceSkipsBurnedIDs(t *testing.T) {
+	_, cc := newTestServiceContract(t)
+	first, err := cc.NewService("http://a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.MintWithTokenURI("1", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.MintWithTokenURI("2", "y"); err != nil {
+		t.Fatal(err)
+	}
+	// the supply drops back to 2, which is the ID of a live token
+	if err := cc.Burn("1"); err != nil {
+		t.Fatal(err)
+	}
+	second, err := cc.NewService("http://b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second || second == "Service-2" {
+		t.Fatalf("NewService handed out %s after %s", second, first)
+	}
+}
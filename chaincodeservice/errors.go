@@ -0,0 +1,81 @@
+package chaincodeservice
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+var (
+	// ErrTokenNotFound is returned for a token ID that is not minted.
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrTokenExists is returned when a token ID is minted a second time.
+	ErrTokenExists = errors.New("token already minted")
+	// ErrQueryExists is returned when a QueryID is recorded a second time.
+	ErrQueryExists = errors.New("query already exists")
+	// ErrMVCCConflict is returned when a transaction was endorsed but failed
+	// to commit because a concurrent transaction changed the keys it read.
+	ErrMVCCConflict = errors.New("transaction lost an MVCC read conflict")
+	// ErrUnknownMSP is returned for a certificate that none of the CAs of
+	// the known MSPs issued.
+	ErrUnknownMSP = errors.New("certificate is not issued by a CA of any known MSP")
+	// ErrNotApproved is returned when taking back a token its owner did not
+	// approve me for.
+	ErrNotApproved = errors.New("not approved for the token")
+)
+
+// MintError is returned when no token could be minted for TokenURI. Err is
+// the error of the last attempt and is matched by errors.Is, e.g. against
+// ErrTokenExists or ErrMVCCConflict when the retries ran out.
+type MintError struct {
+	TokenID  string
+	TokenURI string
+	Attempts int
+	Err      error
+}
+
+func (e *MintError) Error() string {
+	return fmt.Sprintf("failed to mint token %s with URI %s after %d attempts: %s", e.TokenID, e.TokenURI, e.Attempts, e.Err)
+}
+
+func (e *MintError) Unwrap() error {
+	return e.Err
+}
+
+// The chaincodes do not return typed errors, so failures that callers act on
+// are told apart by the messages ds_service and ds_query return. These are
+// the exact texts of the deployed chaincodes and must be kept in step with
+// them. The Emulator returns the sentinel errors above instead, which match
+// as well.
+const (
+	// ds_service reports a missing token as a failure to decode its empty state
+	nftNotFoundMessage = "failed to Unmarshal nftBytes"
+	// ds_service MintWithTokenURI on a token ID in use
+	tokenExistsMessage = "already minted"
+	// ds_query CreateQuery on a QueryID in use
+	queryExistsMessage = "already exists"
+)
+
+func isNFTNotFound(err error) bool {
+	return errors.Is(err, ErrTokenNotFound) || chaincodeErrorContains(err, nftNotFoundMessage)
+}
+
+func isTokenExists(err error) bool {
+	return errors.Is(err, ErrTokenExists) || chaincodeErrorContains(err, tokenExistsMessage)
+}
+
+func isQueryExists(err error) bool {
+	return errors.Is(err, ErrQueryExists) || chaincodeErrorContains(err, queryExistsMessage)
+}
+
+// isMVCCConflict reports whether err is a commit failure caused by a
+// concurrent write to the keys the transaction read.
+func isMVCCConflict(err error) bool {
+	var commitErr *client.CommitError
+	if !errors.As(err, &commitErr) {
+		return false
+	}
+	return commitErr.Code == peer.TxValidationCode_MVCC_READ_CONFLICT || commitErr.Code == peer.TxValidationCode_PHANTOM_READ_CONFLICT
+}
@@ -0,0 +1,195 @@
+package chaincodeservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+type EventListener func(*client.ChaincodeEvent)
+
+// EventSource opens a stream of chaincode events. A zero checkpoint starts at
+// the next committed block, otherwise the stream resumes right after the
+// checkpointed transaction. The channel is closed when the stream breaks.
+type EventSource interface {
+	ChaincodeEvents(ctx context.Context, chainCodeName, channelID string, checkpoint client.Checkpoint) (<-chan *client.ChaincodeEvent, error)
+}
+
+var _ EventSource = (*OrgSetup)(nil)
+
+// TransferEvent is the payload of the ERC-721 Transfer event of ds_service.
+// From is "0x0" on mint and To is "0x0" on burn.
+type TransferEvent struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	TokenID string `json:"tokenId"`
+}
+
+// StartBlock is a Checkpoint that starts a stream at a given block. Unlike a
+// zero checkpoint, StartBlock(0) replays the chain from the genesis block.
+type StartBlock uint64
+
+func (b StartBlock) BlockNumber() uint64   { return uint64(b) }
+func (b StartBlock) TransactionID() string { return "" }
+
+// Checkpointer records the position of an EventSubscription.
+type Checkpointer interface {
+	client.Checkpoint
+	CheckpointChaincodeEvent(event *client.ChaincodeEvent) error
+}
+
+type memoryCheckpointer struct {
+	*client.InMemoryCheckpointer
+}
+
+func (c memoryCheckpointer) CheckpointChaincodeEvent(event *client.ChaincodeEvent) error {
+	c.InMemoryCheckpointer.CheckpointChaincodeEvent(event)
+	return nil
+}
+
+// EventSubscription is a long-lived subscription to the events of one
+// chaincode. Events are dispatched to the handlers registered for their name,
+// the position is checkpointed to CheckpointFile (or Checkpointer, if set)
+// after each event and the stream is reopened from the checkpoint with
+// exponential backoff whenever it breaks. Without a checkpoint the stream
+// starts at the next commit, or at the genesis block if FromGenesis is set.
+type EventSubscription struct {
+	Source         EventSource
+	ChaincodeName  string
+	ChannelID      string
+	CheckpointFile string
+	Checkpointer   Checkpointer
+	FromGenesis    bool
+	MinBackoff     time.Duration
+	MaxBackoff     time.Duration
+
+	mu        sync.RWMutex
+	handlers  map[string][]EventListener
+	connected atomic.Bool
+}
+
+func NewEventSubscription(source EventSource, chainCodeName, channelID, checkpointFile string) *EventSubscription {
+	return &EventSubscription{
+		Source:         source,
+		ChaincodeName:  chainCodeName,
+		ChannelID:      channelID,
+		CheckpointFile: checkpointFile,
+		MinBackoff:     time.Second,
+		MaxBackoff:     time.Minute,
+		handlers:       make(map[string][]EventListener),
+	}
+}
+
+// Handle registers listener for events named eventName, or for every event
+// if eventName is empty.
+func (s *EventSubscription) Handle(eventName string, listener EventListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[eventName] = append(s.handlers[eventName], listener)
+}
+
+// HandleEvent registers a handler that receives the JSON payload of events
+// named eventName decoded into T. Events whose payload does not decode are
+// logged and skipped.
+func HandleEvent[T any](s *EventSubscription, eventName string, handler func(*client.ChaincodeEvent, T)) {
+	s.Handle(eventName, func(e *client.ChaincodeEvent) {
+		var payload T
+		if err := json.Unmarshal(e.Payload, &payload); err != nil {
+			log.Printf("failed to decode %s event %s: %s\n", e.EventName, e.TransactionID, err)
+			return
+		}
+		handler(e, payload)
+	})
+}
+
+// Run consumes events until ctx is cancelled.
+func (s *EventSubscription) Run(ctx context.Context) error {
+	checkpointer, err := s.openCheckpointer()
+	if err != nil {
+		return err
+	}
+	if closer, ok := checkpointer.(interface{ Close() error }); ok {
+		defer closer.Close()
+	}
+
+	backoff := s.MinBackoff
+	for {
+		var start client.Checkpoint = checkpointer
+		if s.FromGenesis && checkpointer.BlockNumber() == 0 && checkpointer.TransactionID() == "" {
+			start = StartBlock(0)
+		}
+		events, err := s.Source.ChaincodeEvents(ctx, s.ChaincodeName, s.ChannelID, start)
+		if err != nil {
+			log.Printf("failed to listen for chaincode events of %s: %s\n", s.ChaincodeName, err)
+		} else {
+			fmt.Printf("Listening for chaincode events on channel %s for chaincode %s from block %d\n", s.ChannelID, s.ChaincodeName, start.BlockNumber())
+			s.connected.Store(true)
+			for event := range events {
+				s.dispatch(event)
+				if err := checkpointer.CheckpointChaincodeEvent(event); err != nil {
+					log.Printf("failed to checkpoint event %s: %s\n", event.TransactionID, err)
+				}
+				backoff = s.MinBackoff
+			}
+			s.connected.Store(false)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		log.Printf("Reconnecting to chaincode events of %s\n", s.ChaincodeName)
+		backoff *= 2
+		if backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+	}
+}
+
+// Connected reports whether the event stream is currently open.
+func (s *EventSubscription) Connected() bool {
+	return s.connected.Load()
+}
+
+func (s *EventSubscription) openCheckpointer() (Checkpointer, error) {
+	if s.Checkpointer != nil {
+		return s.Checkpointer, nil
+	}
+	if s.CheckpointFile == "" {
+		return memoryCheckpointer{new(client.InMemoryCheckpointer)}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.CheckpointFile), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+	checkpointer, err := client.NewFileCheckpointer(s.CheckpointFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint %s: %w", s.CheckpointFile, err)
+	}
+	return checkpointer, nil
+}
+
+func (s *EventSubscription) dispatch(event *client.ChaincodeEvent) {
+	s.mu.RLock()
+	listeners := append(append([]EventListener{}, s.handlers[""]...), s.handlers[event.EventName]...)
+	s.mu.RUnlock()
+
+	for _, listener := range listeners {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("event handler for %s panicked: %v\n", event.EventName, r)
+				}
+			}()
+			listener(event)
+		}()
+	}
+}
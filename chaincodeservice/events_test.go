@@ -0,0 +1,179 @@
+package chaincodeservice
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// breakingSource breaks every stream of its Source after one event.
+type breakingSource struct {
+	Source EventSource
+
+	mu    sync.Mutex
+	opens int
+}
+
+func (s *breakingSource) ChaincodeEvents(ctx context.Context, chainCodeName, channelID string, checkpoint client.Checkpoint) (<-chan *client.ChaincodeEvent, error) {
+	s.mu.Lock()
+	s.opens++
+	s.mu.Unlock()
+	ctx, cancel := context.WithCancel(ctx)
+	events, err := s.Source.ChaincodeEvents(ctx, chainCodeName, channelID, checkpoint)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	broken := make(chan *client.ChaincodeEvent)
+	go func() {
+		defer close(broken)
+		defer cancel()
+		if event, ok := <-events; ok {
+			broken <- event
+		}
+	}()
+	return broken, nil
+}
+
+// runSubscription runs sub until the returned stop is called, which returns
+// the error Run stopped with.
+func runSubscription(t *testing.T, sub *EventSubscription) (stop func() error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- sub.Run(ctx) }()
+	for deadline := time.Now().Add(5 * time.Second); !sub.Connected(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription did not connect")
+		}
+	}
+	stopped := false
+	stop = func() error {
+		if stopped {
+			return nil
+		}
+		stopped = true
+		cancel()
+		return <-done
+	}
+	t.Cleanup(func() { stop() })
+	return stop
+}
+
+// transfers collects the token IDs of the Transfer events sub receives.
+func transfers(sub *EventSubscription) <-chan string {
+	tokenIDs := make(chan string, 16)
+	HandleEvent(sub, "Transfer", func(_ *client.ChaincodeEvent, transfer TransferEvent) {
+		tokenIDs <- transfer.TokenID
+	})
+	return tokenIDs
+}
+
+func expectTransfers(t *testing.T, tokenIDs <-chan string, want ...string) {
+	t.Helper()
+	for _, tokenID := range want {
+		select {
+		case got := <-tokenIDs:
+			if got != tokenID {
+				t.Fatalf("got a transfer of token %s, want %s", got, tokenID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no transfer of token %s", tokenID)
+		}
+	}
+	select {
+	case got := <-tokenIDs:
+		t.Fatalf("unexpected transfer of token %s", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func mint(t *testing.T, cc *ServiceContract, tokenIDs ...string) {
+	t.Helper()
+	for _, tokenID := range tokenIDs {
+		if err := cc.MintWithTokenURI(tokenID, "uri-"+tokenID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEventSubscriptionDispatch(t *testing.T) {
+	e, cc := newTestServiceContract(t)
+	sub := NewEventSubscription(e, cc.ChaincodeName, cc.ChannelID, "")
+	var mu sync.Mutex
+	names := []string{}
+	sub.Handle("", func(event *client.ChaincodeEvent) {
+		mu.Lock()
+		names = append(names, event.EventName)
+		mu.Unlock()
+	})
+	// neither a payload that does not decode nor a panic stops dispatching
+	HandleEvent(sub, "Transfer", func(*client.ChaincodeEvent, []int) { t.Error("Transfer payload decoded as []int") })
+	sub.Handle("Transfer", func(*client.ChaincodeEvent) { panic("handler failed") })
+	tokenIDs := transfers(sub)
+
+	// a subscription without checkpoint starts at the next commit
+	mint(t, cc, "0")
+	stop := runSubscription(t, sub)
+	mint(t, cc, "1", "2")
+	expectTransfers(t, tokenIDs, "1", "2")
+	if err := stop(); err != context.Canceled {
+		t.Fatalf("Run stopped with %v, want context.Canceled", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(names) != 2 || names[0] != "Transfer" {
+		t.Fatalf("catch-all handler saw %v", names)
+	}
+}
+
+func TestEventSubscriptionResumes(t *testing.T) {
+	e, cc := newTestServiceContract(t)
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoints", "ds_service.json")
+	sub := NewEventSubscription(e, cc.ChaincodeName, cc.ChannelID, checkpointFile)
+	tokenIDs := transfers(sub)
+	stop := runSubscription(t, sub)
+	mint(t, cc, "0", "1")
+	expectTransfers(t, tokenIDs, "0", "1")
+	stop()
+
+	// events committed while no one listened are delivered on resume, and
+	// those delivered before are not delivered again
+	mint(t, cc, "2")
+	sub = NewEventSubscription(e, cc.ChaincodeName, cc.ChannelID, checkpointFile)
+	tokenIDs = transfers(sub)
+	runSubscription(t, sub)
+	mint(t, cc, "3")
+	expectTransfers(t, tokenIDs, "2", "3")
+
+	// without a checkpoint, FromGenesis replays the whole chain
+	sub = NewEventSubscription(e, cc.ChaincodeName, cc.ChannelID, "")
+	sub.FromGenesis = true
+	tokenIDs = transfers(sub)
+	runSubscription(t, sub)
+	expectTransfers(t, tokenIDs, "0", "1", "2", "3")
+}
+
+func TestEventSubscriptionReconnects(t *testing.T) {
+	e, cc := newTestServiceContract(t)
+	source := &breakingSource{Source: e}
+	sub := NewEventSubscription(source, cc.ChaincodeName, cc.ChannelID, "")
+	sub.FromGenesis = true
+	sub.MinBackoff, sub.MaxBackoff = time.Millisecond, 4*time.Millisecond
+	tokenIDs := transfers(sub)
+	mint(t, cc, "0", "1", "2")
+
+	runSubscription(t, sub)
+	expectTransfers(t, tokenIDs, "0", "1", "2")
+	mint(t, cc, "3")
+	expectTransfers(t, tokenIDs, "3")
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	if source.opens < 4 {
+		t.Fatalf("stream opened %d times for 4 events that each broke it", source.opens)
+	}
+}
@@ -0,0 +1,156 @@
+package chaincodeservice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AccessGrant is an access token of a service. The URI of an access token is
+// the ServiceID, followed by the terms of the grant as "|Key=Value" pairs,
+// e.g. "Service-3|Expires=1767225600|Fetches=10|Period=86400". Tokens minted
+// before grants had terms carry the bare ServiceID and never expire.
+type AccessGrant struct {
+	TokenID   string `json:"TokenID"`
+	Owner     string `json:"Owner"`
+	ServiceID string `json:"ServiceID"`
+	ExpiresAt int64  `json:"ExpiresAt"` // Unix seconds, 0 if the grant never expires
+	// usage quota, a zero limit is no limit. Usage is counted over the last
+	// QuotaPeriod seconds, or over all time if QuotaPeriod is 0.
+	MaxFetches  int   `json:"MaxFetches"`
+	MaxRows     int   `json:"MaxRows"`
+	QuotaPeriod int64 `json:"QuotaPeriod"`
+}
+
+// QuotaUsage is what an initiator consumed of a service within one quota
+// period: the number of successful queries and the rows they delivered.
+type QuotaUsage struct {
+	Fetches int `json:"Fetches"`
+	Rows    int `json:"Rows"`
+}
+
+const (
+	grantExpiresKey = "Expires"
+	grantFetchesKey = "Fetches"
+	grantRowsKey    = "Rows"
+	grantPeriodKey  = "Period"
+)
+
+// TokenURI encodes the grant as the URI of its access token.
+func (g AccessGrant) TokenURI() string {
+	uri := g.ServiceID
+	if g.ExpiresAt != 0 {
+		uri += "|" + grantExpiresKey + "=" + strconv.FormatInt(g.ExpiresAt, 10)
+	}
+	if g.MaxFetches != 0 {
+		uri += "|" + grantFetchesKey + "=" + strconv.Itoa(g.MaxFetches)
+	}
+	if g.MaxRows != 0 {
+		uri += "|" + grantRowsKey + "=" + strconv.Itoa(g.MaxRows)
+	}
+	if g.QuotaPeriod != 0 {
+		uri += "|" + grantPeriodKey + "=" + strconv.FormatInt(g.QuotaPeriod, 10)
+	}
+	return uri
+}
+
+// Limited reports whether the grant carries a usage quota.
+func (g AccessGrant) Limited() bool {
+	return g.MaxFetches > 0 || g.MaxRows > 0
+}
+
+// QuotaSince returns the Unix time from which usage counts against the quota
+// at now, 0 if all of it does.
+func (g AccessGrant) QuotaSince(now time.Time) int64 {
+	if g.QuotaPeriod <= 0 {
+		return 0
+	}
+	return now.Unix() - g.QuotaPeriod
+}
+
+// Remaining returns the fetches and rows left after used, -1 where the grant
+// sets no limit. Neither goes below 0.
+func (g AccessGrant) Remaining(used QuotaUsage) (fetches int, rows int) {
+	remaining := func(limit, used int) int {
+		if limit <= 0 {
+			return -1
+		}
+		if used >= limit {
+			return 0
+		}
+		return limit - used
+	}
+	return remaining(g.MaxFetches, used.Fetches), remaining(g.MaxRows, used.Rows)
+}
+
+// Exhausted reports whether used leaves no fetch or no row.
+func (g AccessGrant) Exhausted(used QuotaUsage) bool {
+	fetches, rows := g.Remaining(used)
+	return fetches == 0 || rows == 0
+}
+
+// Expired reports whether the grant has run out at now.
+func (g AccessGrant) Expired(now time.Time) bool {
+	return g.ExpiresAt != 0 && now.Unix() >= g.ExpiresAt
+}
+
+// LongestGrant returns the grant among grants that lasts the longest at now,
+// ok is false if all of them have expired. A grant that never expires
+// outlasts any other, and of grants lasting as long one without a quota is
+// preferred.
+func LongestGrant(grants []AccessGrant, now time.Time) (grant AccessGrant, ok bool) {
+	for _, g := range grants {
+		if g.Expired(now) {
+			continue
+		}
+		if !ok || g.outlasts(grant) {
+			grant, ok = g, true
+		}
+	}
+	return grant, ok
+}
+
+func (g AccessGrant) outlasts(other AccessGrant) bool {
+	if g.ExpiresAt != other.ExpiresAt {
+		return g.ExpiresAt == 0 || (other.ExpiresAt != 0 && g.ExpiresAt > other.ExpiresAt)
+	}
+	return !g.Limited() && other.Limited()
+}
+
+// ParseAccessGrant decodes the URI of an access token. ok is false for tokens
+// that are not access tokens, such as the registry token of a service or a
+// revocation record.
+func ParseAccessGrant(tokenID string, owner string, tokenURI string) (grant AccessGrant, ok bool, err error) {
+	if strings.HasPrefix(tokenURI, mintPrefix) || strings.HasPrefix(tokenURI, revocationPrefix) {
+		return AccessGrant{}, false, nil
+	}
+	fields := strings.Split(tokenURI, "|")
+	grant = AccessGrant{TokenID: tokenID, Owner: owner, ServiceID: fields[0]}
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			return AccessGrant{}, false, fmt.Errorf("invalid term %q in access token %s", field, tokenID)
+		}
+		switch key {
+		case grantExpiresKey:
+			grant.ExpiresAt, err = strconv.ParseInt(value, 10, 64)
+		case grantFetchesKey:
+			grant.MaxFetches, err = strconv.Atoi(value)
+		case grantRowsKey:
+			grant.MaxRows, err = strconv.Atoi(value)
+		case grantPeriodKey:
+			grant.QuotaPeriod, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil {
+			return AccessGrant{}, false, fmt.Errorf("invalid %s in access token %s: %w", key, tokenID, err)
+		}
+	}
+	return grant, true, nil
+}
+
+// grantURIPrefix matches the URIs of the access tokens of serviceID that
+// carry terms
+func grantURIPrefix(serviceID string) string {
+	return serviceID + "|"
+}
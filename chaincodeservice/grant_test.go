@@ -0,0 +1,138 @@
+package chaincodeservice
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessGrantURI(t *testing.T) {
+	grant := AccessGrant{ServiceID: "Service-3", ExpiresAt: 1767225600, MaxFetches: 10, MaxRows: 500, QuotaPeriod: 86400}
+	parsed, ok, err := ParseAccessGrant("7", "alice", grant.TokenURI())
+	if err != nil || !ok {
+		t.Fatalf("ParseAccessGrant(%q): %v, %v", grant.TokenURI(), ok, err)
+	}
+	grant.TokenID, grant.Owner = "7", "alice"
+	if parsed != grant {
+		t.Fatalf("parsed %+v, want %+v", parsed, grant)
+	}
+	if _, ok, _ := ParseAccessGrant("0", "alice", "Mint|Service-3|http://a"); ok {
+		t.Fatal("registry token parsed as an access grant")
+	}
+	if _, _, err := ParseAccessGrant("1", "alice", "Service-3|Expires"); err == nil {
+		t.Fatal("term without value accepted")
+	}
+}
+
+func TestBurnExpired(t *testing.T) {
+	e, publisher := newTestServiceContract(t)
+	consumer := newTestConsumer(t, e, publisher)
+	orgSetup, ledger, err := e.Enroll("Org3", "Org3MSP", "withdrawn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withdrawn := &ServiceContract{OrgSetup: orgSetup, Ledger: ledger, ChaincodeName: publisher.ChaincodeName, ChannelID: publisher.ChannelID}
+	serviceID, err := publisher.NewService("http://publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	expired, err := publisher.ApproveServiceUntil(serviceID, consumer.OrgSetup.Identity, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := publisher.ApproveServiceUntil(serviceID, consumer.OrgSetup.Identity, now.Add(3*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := publisher.ApproveServiceUntil(serviceID, withdrawn.OrgSetup.Identity, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// withdrawn does not approve its token
+	if err := consumer.Approve(publisher.OrgSetup.Identity, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	burned, err := publisher.BurnExpired(serviceID, now.Add(2*time.Hour))
+	if !errors.Is(err, ErrNotApproved) {
+		t.Fatalf("taking back a token without approval: %v, want ErrNotApproved", err)
+	}
+	if len(burned) != 1 || burned[0].TokenID != expired {
+		t.Fatalf("burned %+v, want token %s only", burned, expired)
+	}
+	grants, err := publisher.AccessGrantsOf(consumer.OrgSetup.Identity, serviceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grant, ok := LongestGrant(grants, now.Add(2*time.Hour)); !ok || grant.ExpiresAt != now.Add(3*time.Hour).Unix() {
+		t.Fatalf("unexpired grant: %+v, %v", grant, ok)
+	}
+	grants, err = publisher.AccessGrantsOf(withdrawn.OrgSetup.Identity, serviceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := LongestGrant(grants, now.Add(2*time.Hour)); ok {
+		t.Fatal("a grant that was not burned counts as access after expiry")
+	}
+}
+
+func TestAccessGrantOfWithTerms(t *testing.T) {
+	e, publisher := newTestServiceContract(t)
+	consumer := newTestConsumer(t, e, publisher)
+	serviceID, err := publisher.NewService("http://publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if _, err := publisher.ApproveGrant(AccessGrant{ServiceID: serviceID, ExpiresAt: now.Add(-time.Minute).Unix()}, consumer.OrgSetup.Identity); err != nil {
+		t.Fatal(err)
+	}
+	// an expired token counts as no access, whether it was burned or not
+	if ok, err := publisher.HasAccess(consumer.OrgSetup.Identity, serviceID); err != nil || ok {
+		t.Fatalf("HasAccess with an expired grant: %v, %v", ok, err)
+	}
+	if ok, err := consumer.HasAccessToService(serviceID); err != nil || ok {
+		t.Fatalf("HasAccessToService with an expired grant: %v, %v", ok, err)
+	}
+
+	limited := AccessGrant{ServiceID: serviceID, ExpiresAt: now.Add(time.Hour).Unix(), MaxFetches: 5, QuotaPeriod: 3600}
+	tokenID, err := publisher.ApproveGrant(limited, consumer.OrgSetup.Identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grant, ok, err := publisher.AccessGrantOf(consumer.OrgSetup.Identity, serviceID, now)
+	limited.TokenID, limited.Owner = tokenID, strings.ReplaceAll(consumer.OrgSetup.Identity, " ", "")
+	if err != nil || !ok || grant != limited {
+		t.Fatalf("AccessGrantOf = %+v, %v, %v, want %+v", grant, ok, err, limited)
+	}
+	if _, ok, err := publisher.AccessGrantOf(consumer.OrgSetup.Identity, serviceID, now.Add(2*time.Hour)); err != nil || ok {
+		t.Fatalf("AccessGrantOf after expiry: %v, %v", ok, err)
+	}
+}
+
+func TestLongestGrant(t *testing.T) {
+	now := time.Unix(1767225600, 0)
+	expired := AccessGrant{TokenID: "1", ExpiresAt: now.Unix() - 1}
+	hour := AccessGrant{TokenID: "2", ExpiresAt: now.Unix() + 3600}
+	day := AccessGrant{TokenID: "3", ExpiresAt: now.Unix() + 86400, MaxFetches: 1}
+	permanent := AccessGrant{TokenID: "4", MaxFetches: 10}
+
+	for _, c := range []struct {
+		grants []AccessGrant
+		want   string
+	}{
+		{nil, ""},
+		{[]AccessGrant{expired}, ""},
+		{[]AccessGrant{expired, hour}, "2"},
+		{[]AccessGrant{day, hour}, "3"},
+		{[]AccessGrant{hour, permanent, day}, "4"},
+		{[]AccessGrant{permanent, day}, "4"},
+		{[]AccessGrant{{TokenID: "5"}, permanent}, "5"},
+		{[]AccessGrant{permanent, {TokenID: "5"}}, "5"},
+	} {
+		grant, ok := LongestGrant(c.grants, now)
+		if ok != (c.want != "") || grant.TokenID != c.want {
+			t.Errorf("LongestGrant(%+v) = %+v, %v, want token %q", c.grants, grant, ok, c.want)
+		}
+	}
+}
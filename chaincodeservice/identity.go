@@ -0,0 +1,73 @@
+package chaincodeservice
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AccountAttributes are the subject attributes of an account ID as returned
+// by ClientAccountID, "x509::<subject DN>::<issuer DN>", keyed by attribute
+// type (CN, OU, O, ...). An attribute may occur more than once, e.g. OU.
+type AccountAttributes map[string][]string
+
+// ParseAccountID reads the subject attributes of an account ID.
+func ParseAccountID(accountID string) (AccountAttributes, error) {
+	rest, ok := strings.CutPrefix(accountID, "x509::")
+	if !ok {
+		return nil, fmt.Errorf("account ID %q is not an x509 identity", accountID)
+	}
+	subject, _, ok := cutUnescaped(rest, "::")
+	if !ok {
+		return nil, fmt.Errorf("account ID %q has no issuer", accountID)
+	}
+
+	attributes := make(AccountAttributes)
+	for subject != "" {
+		var pair string
+		pair, subject = nextRDNValue(subject)
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid attribute %q in account ID", pair)
+		}
+		attributes[key] = append(attributes[key], unescapeDN(value))
+	}
+	return attributes, nil
+}
+
+// nextRDNValue splits off the first "type=value" of a DN, which ends at an
+// unescaped ',' or '+'.
+func nextRDNValue(dn string) (string, string) {
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			i++
+		case ',', '+':
+			return dn[:i], dn[i+1:]
+		}
+	}
+	return dn, ""
+}
+
+func cutUnescaped(s, sep string) (string, string, bool) {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.HasPrefix(s[i:], sep) {
+			return s[:i], s[i+len(sep):], true
+		}
+	}
+	return s, "", false
+}
+
+func unescapeDN(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,15 @@
+package chaincodeservice
+
+// Ledger is the transport used by the contracts to reach the chaincodes.
+// *OrgSetup implements it against a Fabric gateway and *EmulatorClient
+// implements it in-process.
+type Ledger interface {
+	// evaluate a transaction without committing it
+	Query(chainCodeName, channelID, function string, args []string) (string, error)
+	// endorse and submit a transaction, without waiting for the commit
+	Invoke(chainCodeName, channelID, function string, args []string) (string, error)
+	// endorse and submit a transaction, waiting for the commit
+	Submit(chainCodeName, channelID, function string, args []string) error
+}
+
+var _ Ledger = (*OrgSetup)(nil)
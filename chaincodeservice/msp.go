@@ -0,0 +1,137 @@
+package chaincodeservice
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MSPVerifier validates the certificates of identities against the root and
+// intermediate CAs of the MSPs of a channel, as the peers do.
+type MSPVerifier struct {
+	msps map[string]*mspCAs
+}
+
+type mspCAs struct {
+	roots         *x509.CertPool
+	intermediates *x509.CertPool
+}
+
+func NewMSPVerifier() *MSPVerifier {
+	return &MSPVerifier{msps: make(map[string]*mspCAs)}
+}
+
+// AddCAs trusts roots and intermediates as the CAs of mspID.
+func (v *MSPVerifier) AddCAs(mspID string, roots []*x509.Certificate, intermediates []*x509.Certificate) {
+	cas, ok := v.msps[mspID]
+	if !ok {
+		cas = &mspCAs{roots: x509.NewCertPool(), intermediates: x509.NewCertPool()}
+		v.msps[mspID] = cas
+	}
+	for _, cert := range roots {
+		cas.roots.AddCert(cert)
+	}
+	for _, cert := range intermediates {
+		cas.intermediates.AddCert(cert)
+	}
+}
+
+// LoadMSPDir trusts the CAs of an MSP directory as laid out by Fabric, in
+// its cacerts and intermediatecerts subdirectories.
+func (v *MSPVerifier) LoadMSPDir(mspID string, dir string) error {
+	roots, err := loadCertificates(filepath.Join(dir, "cacerts"))
+	if err != nil {
+		return err
+	}
+	if len(roots) == 0 {
+		return fmt.Errorf("no CA certificate for %s in %s", mspID, dir)
+	}
+	intermediates, err := loadCertificates(filepath.Join(dir, "intermediatecerts"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	v.AddCAs(mspID, roots, intermediates)
+	return nil
+}
+
+func loadCertificates(dir string) ([]*x509.Certificate, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := ParseCertificates(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		certs = append(certs, parsed...)
+	}
+	return certs, nil
+}
+
+// ParseCertificates reads the certificates of PEM data.
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificate")
+	}
+	return certs, nil
+}
+
+// Verify validates cert at now against the CAs of every MSP and returns the
+// MSP that issued it.
+func (v *MSPVerifier) Verify(cert *x509.Certificate, now time.Time) (string, error) {
+	for _, mspID := range v.MSPIDs() {
+		cas := v.msps[mspID]
+		_, err := cert.Verify(x509.VerifyOptions{
+			Roots:         cas.roots,
+			Intermediates: cas.intermediates,
+			CurrentTime:   now,
+			// Fabric does not require an extended key usage of identities
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err == nil {
+			return mspID, nil
+		}
+	}
+	return "", fmt.Errorf("%w: certificate of %s issued by %s, known MSPs are %s", ErrUnknownMSP,
+		distinguishedName(&cert.Subject), distinguishedName(&cert.Issuer), strings.Join(v.MSPIDs(), ", "))
+}
+
+// MSPIDs lists the MSPs whose CAs are trusted, in order.
+func (v *MSPVerifier) MSPIDs() []string {
+	mspIDs := make([]string, 0, len(v.msps))
+	for mspID := range v.msps {
+		mspIDs = append(mspIDs, mspID)
+	}
+	sort.Strings(mspIDs)
+	return mspIDs
+}
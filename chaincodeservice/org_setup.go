@@ -3,7 +3,7 @@ package chaincodeservice
 import (
 	"context"
 	"crypto"
-	"crypto/ecdsa"
+	"crypto/x509"
 	"fmt"
 	"log"
 	"time"
@@ -11,6 +11,8 @@ import (
 	"github.com/hyperledger/fabric-gateway/pkg/client"
 )
 
+// OrgSetup connects to the gateway as an org identity. Its keys are ECDSA
+// keys on P-256 or P-384, or Ed25519 keys, see package keys.
 type OrgSetup struct {
 	OrgName          string
 	MSPID            string
@@ -21,18 +23,17 @@ type OrgSetup struct {
 	PeerEndpoint     string
 	GatewayPeer      string
 	Gateway          client.Gateway
-	PublicKey        *ecdsa.PublicKey
+	Certificate      *x509.Certificate
+	PublicKey        crypto.PublicKey
 	PrivateKeySigner crypto.Signer
-	PrivateKey       *ecdsa.PrivateKey
+	PrivateKey       crypto.PrivateKey
 	Identity         string
 }
 
-type EventListener func(*client.ChaincodeEvent)
-
 func Initialize(setup OrgSetup) (*OrgSetup, error) {
 	log.Printf("Initializing connection for %s...\n", setup.OrgName)
 	clientConnection := setup.newGrpcConnection()
-	id, publicKey := setup.newIdentity()
+	id, certificate, publicKey := setup.newIdentity()
 	sign, privateKeySigner, privateKey := setup.newSign()
 
 	// Connect to a Fabric Gateway using a client identity, gRPC connection and signing implementation.
@@ -50,6 +51,7 @@ func Initialize(setup OrgSetup) (*OrgSetup, error) {
 		panic(err)
 	}
 	setup.Gateway = *gateway
+	setup.Certificate = certificate
 	setup.PublicKey = publicKey
 	setup.PrivateKeySigner = privateKeySigner
 	setup.PrivateKey = privateKey
@@ -108,22 +110,16 @@ func (setup *OrgSetup) Submit(chainCodeName, channelID, function string, args []
 	return nil
 }
 
-func (setup *OrgSetup) StartListen(chainCodeName, channelID string, callbacks []EventListener) {
-
+// open a chaincode event stream, resuming from checkpoint
+func (setup *OrgSetup) ChaincodeEvents(ctx context.Context, chainCodeName, channelID string, checkpoint client.Checkpoint) (<-chan *client.ChaincodeEvent, error) {
 	network := setup.Gateway.GetNetwork(channelID)
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	events, err := network.ChaincodeEvents(ctx, chainCodeName)
+	option := client.WithCheckpoint(checkpoint)
+	if start, ok := checkpoint.(StartBlock); ok {
+		option = client.WithStartBlock(uint64(start))
+	}
+	events, err := network.ChaincodeEvents(ctx, chainCodeName, option)
 	if err != nil {
-		panic(fmt.Errorf("failed to start chaincode event listening: %w", err))
+		return nil, fmt.Errorf("failed to start chaincode event listening: %w", err)
 	}
-	fmt.Printf("Listening for chaincode events on channel %s for chaincode %s\n", channelID, chainCodeName)
-
-	go func() {
-		for event := range events {
-			fmt.Printf("Received chaincode event: %s %s %s\n", event.EventName, event.TransactionID, event.Payload)
-		}
-	}()
+	return events, nil
 }
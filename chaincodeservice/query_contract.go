@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/hyperledger/fabric-gateway/pkg/client"
 )
 
@@ -23,46 +24,80 @@ type Query struct {
 	Timestamp      int    `json:"Timestamp"`
 }
 
+// Legitimacy values of query records
+const (
+	LegitimacyGranted     = "true"
+	LegitimacyUnknownUser = "unkown user" // sic, matches existing records
+	LegitimacyNoAccess    = "no access"
+	LegitimacyRevoked     = "revoked"
+	LegitimacyExpired     = "expired"
+	LegitimacyQuota       = "quota exceeded"
+)
+
+// number of fresh QueryIDs tried when CreateQuery reports an existing key
+const maxQueryIDAttempts = 3
+
 type QueryContract struct {
 	OrgSetup      *OrgSetup
+	Ledger        Ledger
 	ChaincodeName string
 	ChannelID     string
 }
 
-func (cc *QueryContract) StartListen(callbacks []EventListener) {
-	callbacks = append(callbacks, func(e *client.ChaincodeEvent) {
-		fmt.Printf("Event received: %v\n", e)
+// Subscribe to the events of the query chaincode. Run must be called on the
+// returned subscription to start receiving them.
+func (cc *QueryContract) Subscribe(source EventSource, checkpointFile string) *EventSubscription {
+	sub := NewEventSubscription(source, cc.ChaincodeName, cc.ChannelID, checkpointFile)
+	sub.Handle("", func(e *client.ChaincodeEvent) {
+		fmt.Printf("Event received: %s %s %s\n", e.EventName, e.TransactionID, e.Payload)
 	})
-	go cc.OrgSetup.StartListen(cc.ChaincodeName, cc.ChannelID, callbacks)
+	return sub
 }
 
 func (cc *QueryContract) CreateQuery(certificate, dataDigest string, dataRows int, initiatorID, initiatorMSPID, legitimacy, queriedTable, queryDigest, serviceID string) (string, error) {
-	queryID, err := cc.getNextQueryID()
-	if err != nil {
-		return "", fmt.Errorf("error getting next QueryID: %s", err)
-	}
 	timestamp := time.Now().Unix()
 
-	args := []string{certificate, dataDigest, strconv.Itoa(dataRows), initiatorID, initiatorMSPID, legitimacy, queriedTable, queryDigest, queryID, serviceID, strconv.FormatInt(timestamp, 10)}
+	var lastErr error
+	for attempt := 0; attempt < maxQueryIDAttempts; attempt++ {
+		queryID := cc.newQueryID()
+		args := []string{certificate, dataDigest, strconv.Itoa(dataRows), initiatorID, initiatorMSPID, legitimacy, queriedTable, queryDigest, queryID, serviceID, strconv.FormatInt(timestamp, 10)}
 
-	_, err = cc.OrgSetup.Invoke(cc.ChaincodeName, cc.ChannelID, "CreateQuery", args)
-	if err != nil {
-		return "", fmt.Errorf("error invoking CreateQuery: %s", err)
+		_, err := cc.Ledger.Invoke(cc.ChaincodeName, cc.ChannelID, "CreateQuery", args)
+		if err == nil {
+			return queryID, nil
+		}
+		if !isQueryExists(err) {
+			return "", fmt.Errorf("error invoking CreateQuery: %s", err)
+		}
+		fmt.Printf("QueryID %s already exists, retrying\n", queryID)
+		lastErr = err
 	}
-
-	return queryID, err
+	return "", fmt.Errorf("error invoking CreateQuery: no free QueryID after %d attempts: %s", maxQueryIDAttempts, lastErr)
 }
 
 func (cc *QueryContract) ReadQuery(queryID string) (string, error) {
-	return cc.OrgSetup.Query(cc.ChaincodeName, cc.ChannelID, "ReadQuery", []string{queryID})
+	return cc.Ledger.Query(cc.ChaincodeName, cc.ChannelID, "ReadQuery", []string{queryID})
+}
+
+// GetQuery reads the record of queryID.
+func (cc *QueryContract) GetQuery(queryID string) (Query, error) {
+	var query Query
+	record, err := cc.ReadQuery(queryID)
+	if err != nil {
+		return query, fmt.Errorf("failed to read query %s: %w", queryID, err)
+	}
+	if err := json.Unmarshal([]byte(record), &query); err != nil {
+		return query, fmt.Errorf("invalid record of query %s: %w", queryID, err)
+	}
+	return query, nil
 }
 
 func (cc *QueryContract) QueryExists(queryID string) (string, error) {
-	return cc.OrgSetup.Query(cc.ChaincodeName, cc.ChannelID, "QueryExists", []string{queryID})
+	return cc.Ledger.Query(cc.ChaincodeName, cc.ChannelID, "QueryExists", []string{queryID})
 }
 
 func (cc *QueryContract) GetAllQuerys() ([]Query, error) {
-	jsonQueries, err := cc.OrgSetup.Query(cc.ChaincodeName, cc.ChannelID, "GetAllQuerys", []string{})
+	jsonQueries, err := cc.Ledger.Query(cc.ChaincodeName, cc.ChannelID, "GetAllQuerys", []string{})
 	if err != nil {
 		return nil, fmt.Errorf("error invoking GetAllQuerys: %s", err)
 	}
@@ -71,10 +106,66 @@ func (cc *QueryContract) GetAllQuerys() ([]Query, error) {
 	return queries, nil
 }
 
-func (cc *QueryContract) getNextQueryID() (string, error) {
+// QueryFilter selects query records. Empty fields and zero times match
+// everything; From and To are inclusive Unix timestamps.
+type QueryFilter struct {
+	ServiceID   string
+	InitiatorID string
+	Legitimacy  string
+	From        int64
+	To          int64
+}
+
+// QueryPage is one page of query records, see Indexer.FindQueries. Pass
+// Bookmark back to get the next page; it is empty once the last page has
+// been read.
+type QueryPage struct {
+	Queries  []Query `json:"queries"`
+	Bookmark string  `json:"bookmark"`
+}
+
+func (f QueryFilter) Match(q Query) bool {
+	return (f.ServiceID == "" || q.ServiceID == f.ServiceID) &&
+		(f.InitiatorID == "" || q.InitiatorID == f.InitiatorID) &&
+		(f.Legitimacy == "" || q.Legitimacy == f.Legitimacy) &&
+		(f.From == 0 || int64(q.Timestamp) >= f.From) &&
+		(f.To == 0 || int64(q.Timestamp) <= f.To)
+}
+
+// FilterQueries returns every query record matching filter. ds_query has no
+// rich queries, so this reads the whole ledger through GetAllQuerys once;
+// callers needing several lookups per request should filter the result
+// themselves or use the indexer. Paging is left to the indexer, since pages
+// cut from separate reads of the ledger would not add up to one listing.
+func (cc *QueryContract) FilterQueries(filter QueryFilter) ([]Query, error) {
 	queries, err := cc.GetAllQuerys()
 	if err != nil {
-		return "", fmt.Errorf("error getting all queries: %s", err)
+		return nil, err
 	}
-	return strconv.Itoa(len(queries) + 1), nil
+	matched := []Query{}
+	for _, query := range queries {
+		if filter.Match(query) {
+			matched = append(matched, query)
+		}
+	}
+	return matched, nil
+}
+
+// QueryIDs are "<MSPID>-<random UUID>", so concurrent requests on any number
+// of nodes never mint the same ID and no ledger scan is needed.
+//
+// Migration: records created before this scheme use decimal IDs ("1", "2",
+// ...) assigned in creation order. Both kinds stay readable through ReadQuery
+// and QueryExists and can never collide, since new IDs always contain the
+// MSPID prefix. Audit tooling that sorted numerically by QueryID should sort
+// by Timestamp instead and use IsLegacyQueryID to tell the two apart.
+func (cc *QueryContract) newQueryID() string {
+	return cc.OrgSetup.MSPID + "-" + uuid.NewString()
+}
+
+// IsLegacyQueryID reports whether queryID was allocated by the old sequential
+// scheme.
+func IsLegacyQueryID(queryID string) bool {
+	_, err := strconv.ParseUint(queryID, 10, 64)
+	return err == nil
 }
@@ -0,0 +1,123 @@
+package chaincodeservice
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newTestQueryContract(t *testing.T) *QueryContract {
+	t.Helper()
+	e := NewEmulator()
+	orgSetup, ledger, err := e.Enroll("Org1", "Org1MSP", "publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &QueryContract{OrgSetup: orgSetup, Ledger: ledger, ChaincodeName: "ds_query", ChannelID: "ch"}
+}
+
+func TestFilterQueries(t *testing.T) {
+	cc := newTestQueryContract(t)
+	for i := 0; i < 5; i++ {
+		if _, err := cc.CreateQuery("", "", i, "alice", "Org2MSP", LegitimacyGranted, "t", "", "Service-0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cc.CreateQuery("", "", 0, "bob", "Org2MSP", LegitimacyGranted, "t", "", "Service-0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cc.CreateQuery("", "", 0, "alice", "Org2MSP", LegitimacyNoAccess, "t", "", "Service-0"); err != nil {
+		t.Fatal(err)
+	}
+
+	filter := QueryFilter{ServiceID: "Service-0", InitiatorID: "alice", Legitimacy: LegitimacyGranted}
+	queries, err := cc.FilterQueries(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range queries {
+		if !filter.Match(query) {
+			t.Fatalf("query %s does not match the filter", query.QueryID)
+		}
+	}
+	if len(queries) != 5 {
+		t.Fatalf("FilterQueries found %d queries, want 5", len(queries))
+	}
+	all, err := cc.FilterQueries(QueryFilter{InitiatorID: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 6 {
+		t.Fatalf("FilterQueries found %d queries of alice, want 6", len(all))
+	}
+	outside, err := cc.FilterQueries(QueryFilter{From: 1, To: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outside) != 0 {
+		t.Fatalf("found %d queries outside the time range", len(outside))
+	}
+}
+
+// collidingLedger takes the QueryID of the next collisions CreateQuery
+// calls before passing them on, as a concurrent node minting the same ID
+// would, or fails them with fail.
+type collidingLedger struct {
+	Ledger
+	collisions int
+	fail       error
+	calls      int
+	taken      []string
+}
+
+func (l *collidingLedger) Invoke(chainCodeName, channelID, function string, args []string) (string, error) {
+	if function != "CreateQuery" {
+		return l.Ledger.Invoke(chainCodeName, channelID, function, args)
+	}
+	l.calls++
+	if l.fail != nil {
+		return "", l.fail
+	}
+	if l.collisions > 0 {
+		l.collisions--
+		if _, err := l.Ledger.Invoke(chainCodeName, channelID, function, args); err != nil {
+			return "", err
+		}
+		l.taken = append(l.taken, args[8])
+	}
+	return l.Ledger.Invoke(chainCodeName, channelID, function, args)
+}
+
+func TestCreateQueryRetriesTakenID(t *testing.T) {
+	cc := newTestQueryContract(t)
+	ledger := &collidingLedger{Ledger: cc.Ledger, collisions: maxQueryIDAttempts - 1}
+	cc.Ledger = ledger
+	queryID, err := cc.CreateQuery("", "", 1, "alice", "Org2MSP", LegitimacyGranted, "t", "", "Service-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(queryID, "Org1MSP-") || IsLegacyQueryID(queryID) {
+		t.Fatalf("QueryID %s", queryID)
+	}
+	for _, taken := range ledger.taken {
+		if taken == queryID {
+			t.Fatalf("CreateQuery returned the taken QueryID %s", queryID)
+		}
+	}
+	if query, err := cc.GetQuery(queryID); err != nil || query.InitiatorID != "alice" {
+		t.Fatalf("record of %s: %+v, %v", queryID, query, err)
+	}
+
+	ledger.collisions = maxQueryIDAttempts
+	if _, err := cc.CreateQuery("", "", 1, "alice", "Org2MSP", LegitimacyGranted, "t", "", "Service-0"); err == nil {
+		t.Fatalf("CreateQuery succeeded after %d collisions", maxQueryIDAttempts)
+	}
+	// other failures are not retried
+	ledger.collisions, ledger.fail, ledger.calls = 0, errors.New("endorsement failed"), 0
+	if _, err := cc.CreateQuery("", "", 1, "alice", "Org2MSP", LegitimacyGranted, "t", "", "Service-0"); err == nil || ledger.calls != 1 {
+		t.Fatalf("CreateQuery made %d calls and returned %v", ledger.calls, err)
+	}
+	if IsLegacyQueryID("Org1MSP-1") || !IsLegacyQueryID("12") {
+		t.Fatal("IsLegacyQueryID")
+	}
+}
@@ -0,0 +1,76 @@
+package chaincodeservice
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var revocationPrefix = "Revoked|"
+
+const (
+	revocationTokensKey = "Tokens"
+	revocationHeldKey   = "Held"
+	revocationReasonKey = "Reason"
+	revocationAtKey     = "At"
+)
+
+// Revocation records that a publisher took back access to a service and why.
+// The publisher mints and keeps it as a token with the URI
+// "Revoked|<ServiceID>|<Owner>|Tokens=<id>,<id>|Held=<id>|At=<Unix seconds>|Reason=<reason>",
+// where the reason is query-escaped and Held is left out if empty.
+type Revocation struct {
+	TokenID   string   `json:"TokenID"`
+	ServiceID string   `json:"ServiceID"`
+	Owner     string   `json:"Owner"`
+	TokenIDs  []string `json:"TokenIDs"` // the access tokens taken back
+	Held      []string `json:"Held"`     // those left with Owner, which count as no access
+	RevokedAt int64    `json:"RevokedAt"`
+	Reason    string   `json:"Reason"`
+}
+
+// TokenURI encodes the revocation as the URI of its record token.
+func (v Revocation) TokenURI() string {
+	uri := revocationPrefix + v.ServiceID + "|" + v.Owner +
+		"|" + revocationTokensKey + "=" + strings.Join(v.TokenIDs, ",")
+	if len(v.Held) > 0 {
+		uri += "|" + revocationHeldKey + "=" + strings.Join(v.Held, ",")
+	}
+	return uri + "|" + revocationAtKey + "=" + strconv.FormatInt(v.RevokedAt, 10) +
+		"|" + revocationReasonKey + "=" + url.QueryEscape(v.Reason)
+}
+
+// ParseRevocation decodes the URI of a revocation record. ok is false for
+// any other token.
+func ParseRevocation(tokenID string, tokenURI string) (revocation Revocation, ok bool, err error) {
+	if !strings.HasPrefix(tokenURI, revocationPrefix) {
+		return Revocation{}, false, nil
+	}
+	fields := strings.Split(tokenURI[len(revocationPrefix):], "|")
+	if len(fields) < 2 {
+		return Revocation{}, false, fmt.Errorf("invalid revocation record %s", tokenID)
+	}
+	revocation = Revocation{TokenID: tokenID, ServiceID: fields[0], Owner: fields[1]}
+	for _, field := range fields[2:] {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case revocationTokensKey:
+			if value != "" {
+				revocation.TokenIDs = strings.Split(value, ",")
+			}
+		case revocationHeldKey:
+			if value != "" {
+				revocation.Held = strings.Split(value, ",")
+			}
+		case revocationAtKey:
+			revocation.RevokedAt, err = strconv.ParseInt(value, 10, 64)
+		case revocationReasonKey:
+			revocation.Reason, err = url.QueryUnescape(value)
+		}
+		if err != nil {
+			return Revocation{}, false, fmt.Errorf("invalid %s in revocation record %s: %w", key, tokenID, err)
+		}
+	}
+	return revocation, true, nil
+}
@@ -0,0 +1,157 @@
+package chaincodeservice
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// newTestConsumer enrolls an identity of another MSP on the ledger of
+// publisher.
+func newTestConsumer(t *testing.T, e *Emulator, publisher *ServiceContract) *ServiceContract {
+	t.Helper()
+	orgSetup, ledger, err := e.Enroll("Org2", "Org2MSP", "consumer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ServiceContract{OrgSetup: orgSetup, Ledger: ledger, ChaincodeName: publisher.ChaincodeName, ChannelID: publisher.ChannelID}
+}
+
+func TestRevokeAccessNeedsApproval(t *testing.T) {
+	e, publisher := newTestServiceContract(t)
+	consumer := newTestConsumer(t, e, publisher)
+	serviceID, err := publisher.NewService("http://publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenID, err := publisher.ApproveServiceFor(serviceID, consumer.OrgSetup.Identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ds_service only lets owners burn
+	if err := publisher.Burn(tokenID); err == nil {
+		t.Fatal("publisher burned an access token it does not own")
+	}
+	burned, held, err := publisher.RevokeAccess(consumer.OrgSetup.Identity, serviceID)
+	if err != nil || len(burned) != 0 || len(held) != 1 || held[0] != tokenID {
+		t.Fatalf("RevokeAccess without approval: burned %v, held %v, %v", burned, held, err)
+	}
+	// until a revocation record names it, a held token is access
+	if ok, err := publisher.HasAccess(consumer.OrgSetup.Identity, serviceID); err != nil || !ok {
+		t.Fatalf("held token lost access without a record: %v, %v", ok, err)
+	}
+
+	if err := consumer.Approve(publisher.OrgSetup.Identity, tokenID); err != nil {
+		t.Fatal(err)
+	}
+	burned, held, err = publisher.RevokeAccess(consumer.OrgSetup.Identity, serviceID)
+	if err != nil || len(burned) != 1 || burned[0] != tokenID || len(held) != 0 {
+		t.Fatalf("RevokeAccess after approval: burned %v, held %v, %v", burned, held, err)
+	}
+	if ok, err := consumer.HasAccessToService(serviceID); err != nil || ok {
+		t.Fatalf("consumer kept access after revocation: %v, %v", ok, err)
+	}
+}
+
+func TestApprovalCoversOneToken(t *testing.T) {
+	e, publisher := newTestServiceContract(t)
+	consumer := newTestConsumer(t, e, publisher)
+	serviceID, err := publisher.NewService("http://publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	approved, err := publisher.ApproveServiceFor(serviceID, consumer.OrgSetup.Identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := publisher.ApproveServiceFor(serviceID, consumer.OrgSetup.Identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publisher.Approve(publisher.OrgSetup.Identity, approved); err == nil {
+		t.Fatal("publisher approved itself for a token it does not own")
+	}
+	if err := consumer.Approve(publisher.OrgSetup.Identity, approved); err != nil {
+		t.Fatal(err)
+	}
+	for tokenID, want := range map[string]bool{approved: true, other: false} {
+		ok, err := publisher.CanReclaim(AccessGrant{TokenID: tokenID, Owner: consumer.OrgSetup.Identity})
+		if err != nil || ok != want {
+			t.Errorf("CanReclaim(%s) = %v, %v, want %v", tokenID, ok, err, want)
+		}
+	}
+	err = publisher.ReclaimGrant(AccessGrant{TokenID: other, Owner: consumer.OrgSetup.Identity})
+	if !errors.Is(err, ErrNotApproved) {
+		t.Fatalf("ReclaimGrant of a token not approved: %v, want ErrNotApproved", err)
+	}
+	if err := publisher.ReclaimGrant(AccessGrant{TokenID: approved, Owner: consumer.OrgSetup.Identity}); err != nil {
+		t.Fatal(err)
+	}
+	if owner, err := publisher.OwnerOf(other); err != nil || owner != strings.ReplaceAll(consumer.OrgSetup.Identity, " ", "") {
+		t.Fatalf("owner of the token not approved: %q, %v", owner, err)
+	}
+}
+
+func TestHeldTokenIsNoAccess(t *testing.T) {
+	e, publisher := newTestServiceContract(t)
+	consumer := newTestConsumer(t, e, publisher)
+	serviceID, err := publisher.NewService("http://publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	held, err := publisher.ApproveServiceFor(serviceID, consumer.OrgSetup.Identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revocation := Revocation{ServiceID: serviceID, Owner: consumer.OrgSetup.Identity, Held: []string{held}, RevokedAt: 1767225600, Reason: "contract ended"}
+	if _, err := publisher.RecordRevocation(revocation); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := publisher.HasAccess(consumer.OrgSetup.Identity, serviceID); err != nil || ok {
+		t.Fatalf("held token counts as access: %v, %v", ok, err)
+	}
+	if ok, err := consumer.HasAccessToService(serviceID); err != nil || ok {
+		t.Fatalf("consumer sees access of a held token: %v, %v", ok, err)
+	}
+
+	// a grant made after the revocation is not affected by it
+	if _, err := publisher.ApproveServiceFor(serviceID, consumer.OrgSetup.Identity); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := publisher.HasAccess(consumer.OrgSetup.Identity, serviceID); err != nil || !ok {
+		t.Fatalf("new grant after revocation: %v, %v", ok, err)
+	}
+}
+
+func TestRevocationRecord(t *testing.T) {
+	e, publisher := newTestServiceContract(t)
+	consumer := newTestConsumer(t, e, publisher)
+	revocation := Revocation{
+		ServiceID: "Service-0",
+		Owner:     consumer.OrgSetup.Identity,
+		TokenIDs:  []string{"1", "4"},
+		Held:      []string{"6"},
+		RevokedAt: 1767225600,
+		Reason:    "contract ended | 100% done",
+	}
+	tokenID, err := publisher.RecordRevocation(revocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri, err := publisher.TokenURI(tokenID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, ok, err := ParseRevocation(tokenID, uri)
+	if err != nil || !ok {
+		t.Fatalf("ParseRevocation(%q): %v, %v", uri, ok, err)
+	}
+	revocation.TokenID = tokenID
+	if parsed.Reason != revocation.Reason || parsed.Owner != revocation.Owner || len(parsed.TokenIDs) != 2 || len(parsed.Held) != 1 || parsed.RevokedAt != revocation.RevokedAt {
+		t.Fatalf("parsed %+v, want %+v", parsed, revocation)
+	}
+	if _, isGrant, _ := ParseAccessGrant(tokenID, "", uri); isGrant {
+		t.Fatal("revocation record parsed as an access grant")
+	}
+}
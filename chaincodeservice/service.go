@@ -0,0 +1,71 @@
+package chaincodeservice
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lifecycle states of a service. The registry token of an active service
+// has the URI "Mint|<ServiceID>|<PublisherURL>", any other state is appended
+// as "|State=<state>".
+const (
+	// ServiceActive services are listed and served
+	ServiceActive = "active"
+	// ServiceDeprecated services are listed and served to the holders of
+	// access tokens, but take no new applications
+	ServiceDeprecated = "deprecated"
+	// ServiceUnpublished services are neither listed nor served
+	ServiceUnpublished = "unpublished"
+	// ServiceDeleted services are gone for good. Their registry token is
+	// kept as a tombstone, so that the service ID, which access tokens
+	// refer to, is never minted again.
+	ServiceDeleted = "deleted"
+)
+
+const serviceStateKey = "State"
+
+// ServiceRecord is a service as registered on chain.
+type ServiceRecord struct {
+	ServiceID    string `json:"ServiceID"`
+	PublisherURL string `json:"PublisherURL"`
+	State        string `json:"State"`
+}
+
+// ValidServiceState reports whether state is one of the lifecycle states.
+func ValidServiceState(state string) bool {
+	switch state {
+	case ServiceActive, ServiceDeprecated, ServiceUnpublished, ServiceDeleted:
+		return true
+	}
+	return false
+}
+
+// ParseService decodes an entry of GetServices.
+func ParseService(service string) (ServiceRecord, error) {
+	fields := strings.Split(service, "|")
+	if len(fields) < 2 {
+		return ServiceRecord{}, fmt.Errorf("invalid service %q", service)
+	}
+	record := ServiceRecord{ServiceID: fields[0], PublisherURL: fields[1], State: ServiceActive}
+	for _, field := range fields[2:] {
+		key, value, _ := strings.Cut(field, "=")
+		if key == serviceStateKey {
+			record.State = value
+		}
+	}
+	return record, nil
+}
+
+// TokenURI encodes the record as the URI of its registry token.
+func (s ServiceRecord) TokenURI() string {
+	uri := mintPrefix + s.ServiceID + "|" + s.PublisherURL
+	if s.State != "" && s.State != ServiceActive {
+		uri += "|" + serviceStateKey + "=" + s.State
+	}
+	return uri
+}
+
+// Listed reports whether the service is shown to initiators.
+func (s ServiceRecord) Listed() bool {
+	return s.State == ServiceActive || s.State == ServiceDeprecated
+}
@@ -1,22 +1,37 @@
 package chaincodeservice
 
 import (
+	"errors"
 	"fmt"
+	"math/rand"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var servicePrefix = "Service-"
 var mintPrefix = "Mint|"
 
+const (
+	// attempts to mint under a fresh token ID before giving up
+	maxMintAttempts = 5
+	// longest run of missing token IDs scanned before assuming the end
+	maxTokenIDGap = 1024
+	// upper bound of the random pause between mint attempts
+	mintRetryJitter = 200 * time.Millisecond
+)
+
 type ServiceContract struct {
 	OrgSetup      *OrgSetup
+	Ledger        Ledger
 	ChaincodeName string
 	ChannelID     string
 }
 
-func (cc *ServiceContract) StartListen(callbacks []EventListener) {
-	go cc.OrgSetup.StartListen(cc.ChaincodeName, cc.ChannelID, callbacks)
+// Subscribe to the events of the service chaincode. Run must be called on
+// the returned subscription to start receiving them.
+func (cc *ServiceContract) Subscribe(source EventSource, checkpointFile string) *EventSubscription {
+	return NewEventSubscription(source, cc.ChaincodeName, cc.ChannelID, checkpointFile)
 }
 
 // Put a new service on chain. Return the new service ID
@@ -27,47 +42,151 @@ func (cc *ServiceContract) NewService(servicePostfix string) (string, error) {
 		err = fmt.Errorf("failed to get balance: %w", err)
 		return "", err
 	}
-	clientCount := cc.TotalSupply()
+	existing := make(map[string]bool, len(services))
+	for _, service := range services {
+		existing[strings.Split(service, "|")[0]] = true
+	}
 
-	tokenID := strconv.Itoa(clientCount)
-	newServiceID := servicePrefix + strconv.Itoa(len(services))
-	err = cc.MintWithTokenURI(tokenID, mintPrefix+newServiceID+"|"+servicePostfix)
+	// the service ID is derived from the token ID, which the chaincode
+	// guarantees to be unique. Legacy services were numbered by count, so
+	// token IDs whose service ID is already taken are skipped.
+	tokenID, err := cc.mintNext(func(tokenID string) (string, bool) {
+		serviceID := servicePrefix + tokenID
+		return ServiceRecord{ServiceID: serviceID, PublisherURL: servicePostfix}.TokenURI(), !existing[serviceID]
+	})
 	if err != nil {
-		err = fmt.Errorf("failed to mint %s new service %s: %w", tokenID, newServiceID, err)
+		err = fmt.Errorf("failed to mint new service: %w", err)
 		return "", err
 	}
 
-	return newServiceID, nil
+	return servicePrefix + tokenID, nil
 }
 
 // Get all services
 func (cc *ServiceContract) GetServices() ([]string, error) {
 
 	var services []string
-	maxTokenID := cc.TotalSupply()
-	for i := 0; i < maxTokenID; i++ {
+	err := cc.forEachToken(func(tokenID, tokenURI string) error {
+		if strings.HasPrefix(tokenURI, mintPrefix) {
+			services = append(services, tokenURI[len(mintPrefix):])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+// serviceTokens returns the registry tokens of serviceID by token ID.
+// There is one, unless a change of state was cut short.
+func (cc *ServiceContract) serviceTokens(serviceID string) (map[string]ServiceRecord, error) {
+	tokens := make(map[string]ServiceRecord)
+	err := cc.forEachToken(func(tokenID, tokenURI string) error {
+		if !strings.HasPrefix(tokenURI, mintPrefix) {
+			return nil
+		}
+		record, err := ParseService(tokenURI[len(mintPrefix):])
+		if err == nil && record.ServiceID == serviceID {
+			tokens[tokenID] = record
+		}
+		return nil
+	})
+	return tokens, err
+}
+
+// SetServiceState moves serviceID to a lifecycle state, and to a new
+// publisher URL unless publisherURL is empty. The chaincode cannot change
+// the URI of a token, so a registry token with the new URI is minted before
+// the old one is burned; a service found with two tokens is taken to be in
+// the state of the later. Returns the record as registered.
+func (cc *ServiceContract) SetServiceState(serviceID string, state string, publisherURL string) (ServiceRecord, error) {
+	if !ValidServiceState(state) {
+		return ServiceRecord{}, fmt.Errorf("invalid service state %q", state)
+	}
+	tokens, err := cc.serviceTokens(serviceID)
+	if err != nil {
+		return ServiceRecord{}, fmt.Errorf("failed to find service %s: %w", serviceID, err)
+	}
+	var current ServiceRecord
+	latest := -1
+	for tokenID, record := range tokens {
+		if id, _ := strconv.Atoi(tokenID); id > latest {
+			current, latest = record, id
+		}
+	}
+	if len(tokens) == 0 {
+		return ServiceRecord{}, fmt.Errorf("service %s is not registered", serviceID)
+	}
+	if current.State == ServiceDeleted {
+		return current, fmt.Errorf("service %s is deleted", serviceID)
+	}
+
+	next := ServiceRecord{ServiceID: serviceID, PublisherURL: current.PublisherURL, State: state}
+	if publisherURL != "" {
+		next.PublisherURL = publisherURL
+	}
+	if len(tokens) == 1 && next == current {
+		return next, nil
+	}
+	if _, err := cc.mintNext(func(string) (string, bool) { return next.TokenURI(), true }); err != nil {
+		return current, fmt.Errorf("failed to register service %s as %s: %w", serviceID, state, err)
+	}
+	for tokenID := range tokens {
+		if err := cc.Burn(tokenID); err != nil {
+			return next, fmt.Errorf("failed to burn registry token %s of service %s: %w", tokenID, serviceID, err)
+		}
+	}
+	return next, nil
+}
+
+// forEachToken calls fn for every live token in token ID order. Token IDs
+// are sequential but burned tokens leave gaps.
+func (cc *ServiceContract) forEachToken(fn func(tokenID, tokenURI string) error) error {
+	supply := cc.TotalSupply()
+	found, misses := 0, 0
+	for i := 0; found < supply && misses <= maxTokenIDGap; i++ {
 		tokenID := strconv.Itoa(i)
 
 		tokenURI, err := cc.TokenURI(tokenID)
 		if err != nil {
-			return nil, err
-		}
-		if !strings.HasPrefix(tokenURI, mintPrefix) {
+			if !isNFTNotFound(err) {
+				return err
+			}
+			misses++
 			continue
 		}
-		services = append(services, tokenURI[len(mintPrefix):])
+		found, misses = found+1, 0
+		if err := fn(tokenID, tokenURI); err != nil {
+			return err
+		}
 	}
-	return services, nil
+	return nil
 }
 
 // Approve a service for a user. Returns the token ID
 func (cc *ServiceContract) ApproveServiceFor(serviceID string, recipientIdentity string) (string, error) {
+	return cc.ApproveServiceUntil(serviceID, recipientIdentity, time.Time{})
+}
+
+// ApproveServiceUntil approves a service for a user until expiresAt, or for
+// good if expiresAt is zero. Returns the token ID
+func (cc *ServiceContract) ApproveServiceUntil(serviceID string, recipientIdentity string, expiresAt time.Time) (string, error) {
+	grant := AccessGrant{ServiceID: serviceID}
+	if !expiresAt.IsZero() {
+		grant.ExpiresAt = expiresAt.Unix()
+	}
+	return cc.ApproveGrant(grant, recipientIdentity)
+}
+
+// ApproveGrant approves grant.ServiceID for a user on the terms of grant.
+// TokenID and Owner of grant are ignored. Returns the token ID
+func (cc *ServiceContract) ApproveGrant(grant AccessGrant, recipientIdentity string) (string, error) {
 
-	totalSupply := cc.TotalSupply()
-	tokenID := strconv.Itoa(totalSupply)
-	err := cc.MintWithTokenURI(tokenID, serviceID)
+	serviceID := grant.ServiceID
+	tokenID, err := cc.mintNext(func(string) (string, bool) { return grant.TokenURI(), true })
 	if err != nil {
-		err = fmt.Errorf("failed to mint %s new service %s for %s: %w", tokenID, serviceID, recipientIdentity, err)
+		err = fmt.Errorf("failed to mint new service %s for %s: %w", serviceID, recipientIdentity, err)
 		return "", err
 	}
 	minter, err := cc.ClientAccountID()
@@ -83,6 +202,62 @@ func (cc *ServiceContract) ApproveServiceFor(serviceID string, recipientIdentity
 	return tokenID, nil
 }
 
+// mintNext mints a token under the next free token ID and returns the ID.
+// tokenURI gives the URI for a candidate ID, or false to skip it. When the ID
+// is claimed concurrently, either because the chaincode reports it already
+// minted or because the commit lost an MVCC conflict, the next candidate is
+// tried, up to maxMintAttempts times.
+func (cc *ServiceContract) mintNext(tokenURI func(tokenID string) (string, bool)) (string, error) {
+	mintErr := &MintError{}
+	next := 0
+	for mintErr.Attempts < maxMintAttempts {
+		if supply := cc.TotalSupply(); supply > next {
+			next = supply
+		}
+		tokenID, uri, err := cc.freeTokenID(next, tokenURI)
+		if err != nil {
+			return "", err
+		}
+		mintErr.TokenID, mintErr.TokenURI = tokenID, uri
+		mintErr.Attempts++
+
+		err = cc.MintWithTokenURI(tokenID, uri)
+		if err == nil {
+			return tokenID, nil
+		}
+		mintErr.Err = err
+		if !errors.Is(err, ErrTokenExists) && !errors.Is(err, ErrMVCCConflict) {
+			return "", mintErr
+		}
+		fmt.Printf("Token %s was claimed concurrently, retrying: %s\n", tokenID, err)
+		next, _ = strconv.Atoi(tokenID)
+		next++
+		time.Sleep(time.Duration(rand.Int63n(int64(mintRetryJitter))))
+	}
+	return "", mintErr
+}
+
+// freeTokenID returns the first token ID from start on that is not minted
+// and accepted by tokenURI, together with its URI.
+func (cc *ServiceContract) freeTokenID(start int, tokenURI func(tokenID string) (string, bool)) (string, string, error) {
+	for i := start; i <= start+maxTokenIDGap; i++ {
+		tokenID := strconv.Itoa(i)
+		uri, ok := tokenURI(tokenID)
+		if !ok {
+			continue
+		}
+		_, err := cc.TokenURI(tokenID)
+		if err == nil {
+			continue
+		}
+		if !isNFTNotFound(err) {
+			return "", "", fmt.Errorf("failed to check token %s: %w", tokenID, err)
+		}
+		return tokenID, uri, nil
+	}
+	return "", "", fmt.Errorf("no free token ID in %d..%d", start, start+maxTokenIDGap)
+}
+
 func (cc *ServiceContract) HasAccessToService(serviceID string) (bool, error) {
 
 	operatorMSPID, err := cc.OwnerMSPID()
@@ -93,35 +268,235 @@ func (cc *ServiceContract) HasAccessToService(serviceID string) (bool, error) {
 		return true, nil
 	}
 
-	accessBalance, err := cc.BalanceOfByURIPrefix(cc.OrgSetup.Identity, serviceID)
+	return cc.HasAccess(cc.OrgSetup.Identity, serviceID)
+}
+
+// HasAccess reports whether identity holds an access token for serviceID
+// that has not expired, see AccessGrantOf.
+func (cc *ServiceContract) HasAccess(identity string, serviceID string) (bool, error) {
+	_, ok, err := cc.AccessGrantOf(identity, serviceID, time.Now())
+	return ok, err
+}
+
+// AccessGrantOf returns the grant of identity for serviceID that lasts the
+// longest at now, ok is false if identity holds none. The balances of
+// identity tell whether it holds any access token of serviceID at all; only
+// then are the tokens read one by one for their terms, see AccessGrantsOf.
+// The ledger index answers the same without reading every token.
+func (cc *ServiceContract) AccessGrantOf(identity string, serviceID string, now time.Time) (grant AccessGrant, ok bool, err error) {
+	identity = strings.ReplaceAll(identity, " ", "")
+	permanent, err := cc.BalanceOfByURI(identity, serviceID)
+	if err != nil {
+		return AccessGrant{}, false, err
+	}
+	limited, err := cc.BalanceOfByURIPrefix(identity, grantURIPrefix(serviceID))
+	if err != nil || permanent+limited == 0 {
+		return AccessGrant{}, false, err
+	}
+	grants, err := cc.AccessGrantsOf(identity, serviceID)
+	if err != nil {
+		return AccessGrant{}, false, err
+	}
+	grant, ok = LongestGrant(grants, now)
+	return grant, ok, nil
+}
+
+// AccessGrants lists every access token of serviceID, expired or not.
+func (cc *ServiceContract) AccessGrants(serviceID string) ([]AccessGrant, error) {
+	grants, _, err := cc.scanAccessTokens(serviceID, "")
+	return grants, err
+}
+
+// AccessGrantsOf lists the access tokens identity holds for serviceID. Tokens
+// a revocation record names as held by identity are left out, they count as
+// no access.
+func (cc *ServiceContract) AccessGrantsOf(identity string, serviceID string) ([]AccessGrant, error) {
+	grants, held, err := cc.scanAccessTokens(serviceID, strings.ReplaceAll(identity, " ", ""))
+	if err != nil {
+		return nil, err
+	}
+	var owned []AccessGrant
+	for _, grant := range grants {
+		if !held[grant.TokenID] {
+			owned = append(owned, grant)
+		}
+	}
+	return owned, nil
+}
+
+// scanAccessTokens reads every token for the access tokens of serviceID, of
+// owner only unless owner is empty, and for the IDs of those that revocation
+// records name as held by owner.
+func (cc *ServiceContract) scanAccessTokens(serviceID string, owner string) (grants []AccessGrant, held map[string]bool, err error) {
+	held = make(map[string]bool)
+	err = cc.forEachToken(func(tokenID, tokenURI string) error {
+		revocation, ok, err := ParseRevocation(tokenID, tokenURI)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if revocation.ServiceID == serviceID && strings.ReplaceAll(revocation.Owner, " ", "") == owner {
+				for _, id := range revocation.Held {
+					held[id] = true
+				}
+			}
+			return nil
+		}
+		if tokenURI != serviceID && !strings.HasPrefix(tokenURI, grantURIPrefix(serviceID)) {
+			return nil
+		}
+		tokenOwner, err := cc.OwnerOf(tokenID)
+		if err != nil {
+			return fmt.Errorf("failed to get owner of %s: %w", tokenID, err)
+		}
+		tokenOwner = strings.ReplaceAll(tokenOwner, " ", "")
+		if owner != "" && tokenOwner != owner {
+			return nil
+		}
+		grant, ok, err := ParseAccessGrant(tokenID, tokenOwner, tokenURI)
+		if err != nil {
+			return err
+		}
+		if ok {
+			grants = append(grants, grant)
+		}
+		return nil
+	})
+	return grants, held, err
+}
+
+// BurnExpired takes back and burns the access tokens of serviceID that have
+// expired at now, and returns them. A token that cannot be taken back, e.g.
+// because its owner did not approve me for it, is skipped; the errors are
+// returned joined, along with the grants burned.
+func (cc *ServiceContract) BurnExpired(serviceID string, now time.Time) ([]AccessGrant, error) {
+	grants, err := cc.AccessGrants(serviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list access tokens: %w", err)
+	}
+	var burned []AccessGrant
+	var errs []error
+	for _, grant := range grants {
+		if !grant.Expired(now) {
+			continue
+		}
+		if err := cc.ReclaimGrant(grant); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		burned = append(burned, grant)
+	}
+	return burned, errors.Join(errs...)
+}
+
+// RevokeAccess takes back and burns the access tokens identity holds for
+// serviceID and returns their IDs as burned. Tokens identity did not approve
+// me for stay with it and are returned as held; they count as no access once
+// a Revocation names them. On error, the IDs burned so far are returned
+// along with it.
+func (cc *ServiceContract) RevokeAccess(identity string, serviceID string) (burned []string, held []string, err error) {
+	grants, err := cc.AccessGrantsOf(identity, serviceID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find access tokens: %w", err)
+	}
+	for _, grant := range grants {
+		err := cc.ReclaimGrant(grant)
+		if errors.Is(err, ErrNotApproved) {
+			held = append(held, grant.TokenID)
+			continue
+		}
+		if err != nil {
+			return burned, held, err
+		}
+		burned = append(burned, grant.TokenID)
+	}
+	return burned, held, nil
+}
+
+// ReclaimGrant transfers the access token of grant back from its owner and
+// burns it. ds_service only lets owners burn their tokens, so this relies on
+// the owner having approved my client for the token, see CanReclaim, and
+// fails with ErrNotApproved otherwise.
+func (cc *ServiceContract) ReclaimGrant(grant AccessGrant) error {
+	approved, err := cc.CanReclaim(grant)
+	if err != nil {
+		return fmt.Errorf("failed to check approval of access token %s: %w", grant.TokenID, err)
+	}
+	if !approved {
+		return fmt.Errorf("%w: access token %s of %s", ErrNotApproved, grant.TokenID, grant.Owner)
+	}
+	if err := cc.TransferFrom(grant.Owner, cc.OrgSetup.Identity, grant.TokenID); err != nil {
+		return fmt.Errorf("failed to take back access token %s from %s: %w", grant.TokenID, grant.Owner, err)
+	}
+	if err := cc.Burn(grant.TokenID); err != nil {
+		return fmt.Errorf("failed to burn access token %s: %w", grant.TokenID, err)
+	}
+	return nil
+}
+
+// CanReclaim reports whether the owner of grant approved my client for its
+// access token, or as operator of all its tokens.
+func (cc *ServiceContract) CanReclaim(grant AccessGrant) (bool, error) {
+	me := strings.ReplaceAll(cc.OrgSetup.Identity, " ", "")
+	approved, err := cc.GetApproved(grant.TokenID)
 	if err != nil {
 		return false, err
 	}
-	return accessBalance > 0, nil
+	if strings.ReplaceAll(approved, " ", "") == me {
+		return true, nil
+	}
+	return cc.IsApprovedForAll(strings.ReplaceAll(grant.Owner, " ", ""), me)
+}
+
+// RecordRevocation mints revocation as a record kept by my client. Returns
+// the token ID of the record.
+func (cc *ServiceContract) RecordRevocation(revocation Revocation) (string, error) {
+	tokenID, err := cc.mintNext(func(string) (string, bool) { return revocation.TokenURI(), true })
+	if err != nil {
+		return "", fmt.Errorf("failed to record revocation of %s for %s: %w", revocation.ServiceID, revocation.Owner, err)
+	}
+	return tokenID, nil
+}
+
+// PublisherOf returns the account that registered serviceID, the owner of
+// its latest registry token.
+func (cc *ServiceContract) PublisherOf(serviceID string) (string, error) {
+	tokens, err := cc.serviceTokens(serviceID)
+	if err != nil {
+		return "", fmt.Errorf("failed to find service %s: %w", serviceID, err)
+	}
+	latest := -1
+	for tokenID := range tokens {
+		if id, _ := strconv.Atoi(tokenID); id > latest {
+			latest = id
+		}
+	}
+	if latest < 0 {
+		return "", fmt.Errorf("service %s is not registered", serviceID)
+	}
+	return cc.OwnerOf(strconv.Itoa(latest))
 }
 
 // ======= Original Contract Interfaces =======
 
 func (cc *ServiceContract) Initialize(name string, symbol string, ownerMSPID string) error {
-	_, err := cc.OrgSetup.Invoke(cc.ChaincodeName, cc.ChannelID, "Initialize", []string{name, symbol, ownerMSPID})
-	return err
+	return cc.Ledger.Submit(cc.ChaincodeName, cc.ChannelID, "Initialize", []string{name, symbol, ownerMSPID})
 }
 
 func (cc *ServiceContract) OwnerMSPID() (string, error) {
-	return cc.OrgSetup.Query(cc.ChaincodeName, cc.ChannelID, "OwnerMSPID", []string{})
+	return cc.Ledger.Query(cc.ChaincodeName, cc.ChannelID, "OwnerMSPID", []string{})
 }
 
 func (cc *ServiceContract) Burn(tokenId string) error {
-	_, err := cc.OrgSetup.Invoke(cc.ChaincodeName, cc.ChannelID, "Burn", []string{tokenId})
-	return err
+	return cc.Ledger.Submit(cc.ChaincodeName, cc.ChannelID, "Burn", []string{tokenId})
 }
 
 func (cc *ServiceContract) OwnerOf(tokenId string) (string, error) {
-	return cc.OrgSetup.Query(cc.ChaincodeName, cc.ChannelID, "OwnerOf", []string{tokenId})
+	return cc.Ledger.Query(cc.ChaincodeName, cc.ChannelID, "OwnerOf", []string{tokenId})
 }
 
 func (cc *ServiceContract) TransferFrom(from string, to string, tokenId string) error {
-	return cc.OrgSetup.Submit(cc.ChaincodeName, cc.ChannelID, "TransferFrom", []string{from, to, tokenId})
+	return cc.Ledger.Submit(cc.ChaincodeName, cc.ChannelID, "TransferFrom", []string{from, to, tokenId})
 
 	// cmdString := fmt.Sprintf("CORE_PEER_TLS_ENABLED=true CORE_PEER_LOCALMSPID=\"Org1MSP\" CORE_PEER_MSPCONFIGPATH=${PWD}/organizations/peerOrganizations/org1.example.com/users/User1@org1.example.com/msp CORE_PEER_TLS_ROOTCERT_FILE=${PWD}/organizations/peerOrganizations/org1.example.com/peers/peer0.org1.example.com/tls/ca.crt CORE_PEER_ADDRESS=localhost:7051 ../bin/peer chaincode invoke -o localhost:7050 --ordererTLSHostnameOverride orderer.example.com --tls --cafile \"${PWD}/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem\" --peerAddresses localhost:7051 --tlsRootCertFiles \"${PWD}/organizations/peerOrganizations/org1.example.com/peers/peer0.org1.example.com/tls/ca.crt\" --peerAddresses localhost:9051 --tlsRootCertFiles \"${PWD}/organizations/peerOrganizations/org2.example.com/peers/peer0.org2.example.com/tls/ca.crt\" -C %s -n %s -c '{\"function\":\"TransferFrom\",\"Args\":[\"%s\", \"%s\", \"%s\"]}'", cc.ChannelID, cc.ChaincodeName, from, to, tokenId)
 	// err := os.WriteFile("/home/ubuntu/hyperledger/fabric-samples/test-network/chaincode-invoke.sh", []byte(cmdString), 0644)
@@ -134,35 +509,69 @@ func (cc *ServiceContract) TransferFrom(from string, to string, tokenId string)
 	// return nil
 }
 
+// Let operator transfer one of my tokens, until the token changes hands.
+func (cc *ServiceContract) Approve(operator string, tokenId string) error {
+	return cc.Ledger.Submit(cc.ChaincodeName, cc.ChannelID, "Approve", []string{operator, tokenId})
+}
+
+// GetApproved returns the account approved for tokenId, empty if none is.
+func (cc *ServiceContract) GetApproved(tokenId string) (string, error) {
+	return cc.Ledger.Query(cc.ChaincodeName, cc.ChannelID, "GetApproved", []string{tokenId})
+}
+
+// Let operator transfer all my tokens, or stop it from doing so.
+func (cc *ServiceContract) SetApprovalForAll(operator string, approved bool) error {
+	return cc.Ledger.Submit(cc.ChaincodeName, cc.ChannelID, "SetApprovalForAll", []string{operator, strconv.FormatBool(approved)})
+}
+
+func (cc *ServiceContract) IsApprovedForAll(owner string, operator string) (bool, error) {
+	approved, err := cc.Ledger.Query(cc.ChaincodeName, cc.ChannelID, "IsApprovedForAll", []string{owner, operator})
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(approved)
+}
+
 func (cc *ServiceContract) TokenURI(tokenId string) (string, error) {
-	return cc.OrgSetup.Query(cc.ChaincodeName, cc.ChannelID, "TokenURI", []string{tokenId})
+	return cc.Ledger.Query(cc.ChaincodeName, cc.ChannelID, "TokenURI", []string{tokenId})
 }
 
 func (cc *ServiceContract) TotalSupply() int {
-	supply, _ := cc.OrgSetup.Query(cc.ChaincodeName, cc.ChannelID, "TotalSupply", []string{})
+	supply, _ := cc.Ledger.Query(cc.ChaincodeName, cc.ChannelID, "TotalSupply", []string{})
 	supplyInt, _ := strconv.Atoi(supply)
 	return supplyInt
 }
 
 func (cc *ServiceContract) ClientAccountID() (string, error) {
-	clientAccountID, err := cc.OrgSetup.Query(cc.ChaincodeName, cc.ChannelID, "ClientAccountID", []string{})
+	clientAccountID, err := cc.Ledger.Query(cc.ChaincodeName, cc.ChannelID, "ClientAccountID", []string{})
 	if err != nil {
 		return "", err
 	}
 	return clientAccountID, nil
 }
 
+// Mint a token and wait for it to commit. Fails with ErrTokenExists or
+// ErrMVCCConflict when tokenId is claimed by someone else.
 func (cc *ServiceContract) MintWithTokenURI(tokenId, tokenURI string) error {
-	_, err := cc.OrgSetup.Invoke(cc.ChaincodeName, cc.ChannelID, "MintWithTokenURI", []string{tokenId, tokenURI})
-	if err != nil {
+	err := cc.Ledger.Submit(cc.ChaincodeName, cc.ChannelID, "MintWithTokenURI", []string{tokenId, tokenURI})
+	if err == nil {
+		return nil
+	}
+	if isMVCCConflict(err) {
+		return fmt.Errorf("%w: %s", ErrMVCCConflict, err)
+	}
+	if errors.Is(err, ErrTokenExists) {
 		return err
 	}
-	return nil
+	if isTokenExists(err) {
+		return fmt.Errorf("%w: %s", ErrTokenExists, err)
+	}
+	return err
 }
 
 func (cc *ServiceContract) BalanceOf(owner string) (int, error) {
 
-	balance, err := cc.OrgSetup.Query(cc.ChaincodeName, cc.ChannelID, "BalanceOf", []string{owner})
+	balance, err := cc.Ledger.Query(cc.ChaincodeName, cc.ChannelID, "BalanceOf", []string{owner})
 	if err != nil {
 		return 0, err
 	}
@@ -177,7 +586,7 @@ func (cc *ServiceContract) BalanceOf(owner string) (int, error) {
 
 func (cc *ServiceContract) BalanceOfByURIPrefix(owner string, tokenURI string) (int, error) {
 
-	balance, err := cc.OrgSetup.Query(cc.ChaincodeName, cc.ChannelID, "BalanceOfByURIPrefix", []string{owner, tokenURI})
+	balance, err := cc.Ledger.Query(cc.ChaincodeName, cc.ChannelID, "BalanceOfByURIPrefix", []string{owner, tokenURI})
 	if err != nil {
 		return 0, err
 	}
@@ -192,7 +601,7 @@ func (cc *ServiceContract) BalanceOfByURIPrefix(owner string, tokenURI string) (
 
 func (cc *ServiceContract) BalanceOfByURI(owner string, tokenURI string) (int, error) {
 
-	balance, err := cc.OrgSetup.Query(cc.ChaincodeName, cc.ChannelID, "BalanceOfByURI", []string{strings.Replace(owner, " ", "", -1), tokenURI})
+	balance, err := cc.Ledger.Query(cc.ChaincodeName, cc.ChannelID, "BalanceOfByURI", []string{strings.Replace(owner, " ", "", -1), tokenURI})
 	if err != nil {
 		return 0, err
 	}
@@ -3,16 +3,20 @@ package chaincodeservice
 import (
 	"bytes"
 	"crypto"
-	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path"
+	"strings"
+
+	"service-client/keys"
 
 	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
 func formatJSON(data []byte) string {
@@ -43,7 +47,7 @@ func (setup OrgSetup) newGrpcConnection() *grpc.ClientConn {
 }
 
 // newIdentity creates a client identity for this Gateway connection using an X.509 certificate.
-func (setup OrgSetup) newIdentity() (*identity.X509Identity, *ecdsa.PublicKey) {
+func (setup OrgSetup) newIdentity() (*identity.X509Identity, *x509.Certificate, crypto.PublicKey) {
 	certificate, err := loadCertificate(setup.CertPath)
 	if err != nil {
 		panic(err)
@@ -54,17 +58,17 @@ func (setup OrgSetup) newIdentity() (*identity.X509Identity, *ecdsa.PublicKey) {
 		panic(err)
 	}
 
-	pubKey, ok := certificate.PublicKey.(*ecdsa.PublicKey)
-	if !ok {
-		return nil, nil
+	pubKey := certificate.PublicKey
+	if _, err := keys.Algorithm(pubKey); err != nil {
+		panic(fmt.Errorf("failed to use certificate: %w", err))
 	}
 	fmt.Println("newIdentity generates public key:", pubKey)
 
-	return id, pubKey
+	return id, certificate, pubKey
 }
 
 // newSign creates a function that generates a digital signature from a message digest using a private key.
-func (setup OrgSetup) newSign() (identity.Sign, crypto.Signer, *ecdsa.PrivateKey) {
+func (setup OrgSetup) newSign() (identity.Sign, crypto.Signer, crypto.PrivateKey) {
 	files, err := os.ReadDir(setup.KeyPath)
 	if err != nil {
 		panic(fmt.Errorf("failed to read private key directory: %w", err))
@@ -90,7 +94,7 @@ func (setup OrgSetup) newSign() (identity.Sign, crypto.Signer, *ecdsa.PrivateKey
 		panic(fmt.Errorf("failed to generate signer"))
 	}
 
-	return sign, privateKeySigner, privateKey.(*ecdsa.PrivateKey)
+	return sign, privateKeySigner, privateKey
 }
 
 func loadCertificate(filename string) (*x509.Certificate, error) {
@@ -100,3 +104,31 @@ func loadCertificate(filename string) (*x509.Certificate, error) {
 	}
 	return identity.CertificateFromPEM(certificatePEM)
 }
+
+// errorMessages collects the message of err along with the messages returned
+// by the endorsing peers, which the gateway attaches as status details.
+func errorMessages(err error) []string {
+	if err == nil {
+		return nil
+	}
+	messages := []string{err.Error()}
+	if st, ok := status.FromError(err); ok {
+		for _, detail := range st.Details() {
+			if errorDetail, ok := detail.(*gateway.ErrorDetail); ok {
+				messages = append(messages, errorDetail.GetMessage())
+			}
+		}
+	}
+	return messages
+}
+
+// chaincodeErrorContains reports whether err, or any peer error attached to
+// it, mentions substr.
+func chaincodeErrorContains(err error, substr string) bool {
+	for _, message := range errorMessages(err) {
+		if strings.Contains(message, substr) {
+			return true
+		}
+	}
+	return false
+}
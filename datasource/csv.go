@@ -0,0 +1,103 @@
+package datasource
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+// csvDriver reads a CSV file whose first record names the columns.
+//
+// csv: Path, Delimiter (optional, defaults to ",")
+type csvDriver struct{}
+
+func init() {
+	Register("csv", csvDriver{})
+}
+
+func (csvDriver) Validate(options Options) error {
+	if err := options.require("Path"); err != nil {
+		return err
+	}
+	if delimiter := options["Delimiter"]; delimiter != "" && utf8.RuneCountInString(delimiter) != 1 {
+		return fmt.Errorf("delimiter must be a single character, got %q", delimiter)
+	}
+	return checkFile(options["Path"])
+}
+
+func (csvDriver) Open(options Options) (DataSource, error) {
+	delimiter, _ := utf8.DecodeRuneInString(options.get("Delimiter", ","))
+	return &csvSource{path: options["Path"], delimiter: delimiter}, nil
+}
+
+type csvSource struct {
+	path      string
+	delimiter rune
+}
+
+// open reads the header of the file and returns a reader positioned on the
+// first record.
+func (s *csvSource) open() (*os.File, *csv.Reader, []string, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	reader := csv.NewReader(file)
+	reader.Comma = s.delimiter
+	header, err := reader.Read()
+	if err == io.EOF {
+		err = fmt.Errorf("%s is empty", s.path)
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, nil, err
+	}
+	return file, reader, header, nil
+}
+
+// Columns are all text, CSV has no types.
+func (s *csvSource) Columns(ctx context.Context) ([]Column, error) {
+	file, _, header, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return stringColumns(header), nil
+}
+
+func (s *csvSource) Stream(ctx context.Context, q Query, offset int64) (Rows, error) {
+	file, reader, header, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	next := func() (Row, error) {
+		record, err := reader.Read()
+		if err != nil {
+			return nil, err
+		}
+		row := make(Row, len(header))
+		for i, column := range header {
+			row[column] = record[i]
+		}
+		return row, nil
+	}
+	return newQueryRows(ctx, q, stringColumns(header), offset, next, file.Close), nil
+}
+
+func (s *csvSource) Close() error {
+	return nil
+}
+
+func checkFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	return nil
+}
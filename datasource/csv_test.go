@@ -0,0 +1,66 @@
+package datasource
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCSVSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(path, []byte("id;name\n1;a\n2;b\n10;c\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	source, err := Open("csv", Options{"Path": path, "Delimiter": ";"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	ctx := context.Background()
+
+	columns, err := source.Columns(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Column{{Name: "id", Type: TypeString}, {Name: "name", Type: TypeString}}; !reflect.DeepEqual(columns, want) {
+		t.Fatalf("columns %v, want %v", columns, want)
+	}
+
+	// ids compare as numbers, although CSV holds them as text
+	q := Query{Columns: []string{"name"}, Filters: []Filter{{Field: "id", Op: OpGreaterEqual, Value: float64(2)}}}
+	if err := q.Check(); err != nil {
+		t.Fatal(err)
+	}
+	result, err := Select(ctx, source, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Row{{"name": "b"}, {"name": "c"}}; !reflect.DeepEqual(result.Rows, want) || !reflect.DeepEqual(result.Schema, columns[1:]) {
+		t.Fatalf("selected %v %v, want %v", result.Schema, result.Rows, want)
+	}
+	rows, err := source.Stream(ctx, q, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if !rows.Next() || rows.Row()["name"] != "c" || rows.Next() || rows.Err() != nil {
+		t.Fatalf("stream from offset 1 did not return c alone: %v", rows.Err())
+	}
+	if n, err := Count(ctx, source); err != nil || n != 3 {
+		t.Fatalf("Count = %d, %v", n, err)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.csv")
+	if err := os.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	source, err = Open("csv", Options{"Path": empty})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.Columns(ctx); err == nil {
+		t.Fatal("read columns of an empty file")
+	}
+}
@@ -0,0 +1,136 @@
+// Package datasource reads the data set behind a service. Each kind of source
+// is a Driver registered under a type name; a service names its type and the
+// driver-specific options in the config.
+package datasource
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Row is one record of a data set, keyed by column name.
+type Row map[string]interface{}
+
+// Options configure a data source. Which keys are understood depends on the
+// driver.
+type Options map[string]string
+
+// DataSource is an opened data set.
+type DataSource interface {
+	// Columns describes the columns of the data set in order.
+	Columns(ctx context.Context) ([]Column, error)
+	// Stream reads the rows and columns of the data set picked by q, which
+	// must have passed Check and Validate, skipping the first offset rows.
+	// Continuing from an offset relies on the source returning its rows in
+	// the same order every time, as files and unchanged tables do.
+	Stream(ctx context.Context, q Query, offset int64) (Rows, error)
+	Close() error
+}
+
+// Driver opens data sources of one type.
+type Driver interface {
+	// Validate checks options without connecting to the source.
+	Validate(options Options) error
+	Open(options Options) (DataSource, error)
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// Register makes a driver available under typ. It panics if typ is already
+// registered, like database/sql does.
+func Register(typ string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("datasource: Register driver is nil")
+	}
+	if _, dup := drivers[typ]; dup {
+		panic("datasource: Register called twice for driver " + typ)
+	}
+	drivers[typ] = driver
+}
+
+// Types lists the registered driver types in order.
+func Types() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	types := make([]string, 0, len(drivers))
+	for typ := range drivers {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func lookup(typ string) (Driver, error) {
+	driversMu.RLock()
+	driver, ok := drivers[typ]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown data source type %q, expected one of %s", typ, strings.Join(Types(), ", "))
+	}
+	return driver, nil
+}
+
+// Validate checks options against the driver registered under typ.
+func Validate(typ string, options Options) error {
+	driver, err := lookup(typ)
+	if err != nil {
+		return err
+	}
+	if err := driver.Validate(options); err != nil {
+		return fmt.Errorf("invalid %s options: %w", typ, err)
+	}
+	return nil
+}
+
+// Open validates options and opens a data source of type typ.
+func Open(typ string, options Options) (DataSource, error) {
+	driver, err := lookup(typ)
+	if err != nil {
+		return nil, err
+	}
+	if err := driver.Validate(options); err != nil {
+		return nil, fmt.Errorf("invalid %s options: %w", typ, err)
+	}
+	source, err := driver.Open(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s data source: %w", typ, err)
+	}
+	return source, nil
+}
+
+// require reports the first of keys that options leaves empty.
+func (o Options) require(keys ...string) error {
+	for _, key := range keys {
+		if strings.TrimSpace(o[key]) == "" {
+			return fmt.Errorf("option %s is required", key)
+		}
+	}
+	return nil
+}
+
+// get returns the option key, or fallback if it is not set.
+func (o Options) get(key, fallback string) string {
+	if value := o[key]; value != "" {
+		return value
+	}
+	return fallback
+}
+
+// identifier matches table names, optionally qualified by a schema
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+func validIdentifier(name string) error {
+	if !identifier.MatchString(name) {
+		return fmt.Errorf("invalid table name %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package datasource
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.csv")
+	if err := os.WriteFile(path, []byte("id\n1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.csv")
+	mysql := func(key, value string) Options {
+		options := Options{"Host": "db", "Port": "3306", "User": "reader", "Database": "shop", "Table": "orders"}
+		options[key] = value
+		return options
+	}
+
+	for _, tc := range []struct {
+		name    string
+		typ     string
+		options Options
+		ok      bool
+	}{
+		{"unknown type", "excel", Options{"Path": path}, false},
+		{"csv", "csv", Options{"Path": path}, true},
+		{"csv tab delimited", "csv", Options{"Path": path, "Delimiter": "\t"}, true},
+		{"csv without path", "csv", Options{"Path": " "}, false},
+		{"csv with a long delimiter", "csv", Options{"Path": path, "Delimiter": ";;"}, false},
+		{"csv of a missing file", "csv", Options{"Path": missing}, false},
+		{"csv of a directory", "csv", Options{"Path": dir}, false},
+		{"json", "json", Options{"Path": path, "Format": "ndjson"}, true},
+		{"json of another format", "json", Options{"Path": path, "Format": "xml"}, false},
+		{"http", "http", Options{"URL": "https://api.example.com/rows", "Timeout": "5s"}, true},
+		{"http without URL", "http", Options{}, false},
+		{"http of another scheme", "http", Options{"URL": "ftp://api.example.com/rows"}, false},
+		{"http of a relative URL", "http", Options{"URL": "/rows"}, false},
+		{"http with a negative timeout", "http", Options{"URL": "http://api", "Timeout": "-1s"}, false},
+		{"http with a malformed timeout", "http", Options{"URL": "http://api", "Timeout": "soon"}, false},
+		{"sqlite", "sqlite", Options{"Path": path, "Table": "main.orders"}, true},
+		{"sqlite without table", "sqlite", Options{"Path": path}, false},
+		{"sqlite with an injected table", "sqlite", Options{"Path": path, "Table": "orders; DROP TABLE orders"}, false},
+		{"mysql", "mysql", mysql("Password", ""), true},
+		{"mysql without host", "mysql", mysql("Host", ""), false},
+		{"mysql on port 0", "mysql", mysql("Port", "0"), false},
+		{"mysql on a named port", "mysql", mysql("Port", "mysql"), false},
+		{"mysql with a quoted table", "mysql", mysql("Table", "`orders`"), false},
+		{"postgres", "postgres", mysql("Port", "5432"), true},
+		{"postgres with three part table", "postgres", mysql("Table", "db.public.orders"), false},
+	} {
+		err := Validate(tc.typ, tc.options)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: Validate returned %v", tc.name, err)
+		}
+		if !tc.ok && err != nil && tc.typ != "excel" && !strings.HasPrefix(err.Error(), "invalid "+tc.typ+" options") {
+			t.Errorf("%s: error %q does not name the type", tc.name, err)
+		}
+	}
+
+	// Open validates first
+	if _, err := Open("csv", Options{"Path": missing}); err == nil {
+		t.Error("opened a missing CSV file")
+	}
+}
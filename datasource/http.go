@@ -0,0 +1,131 @@
+package datasource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// httpDriver reads an upstream HTTP API that answers GET with JSON.
+//
+// http: URL, Token (optional, sent as a bearer token), Path (optional, dotted
+// path to the array of objects inside the response), Timeout (optional, a Go
+// duration, defaults to 30s)
+type httpDriver struct{}
+
+func init() {
+	Register("http", httpDriver{})
+}
+
+func (httpDriver) Validate(options Options) error {
+	if err := options.require("URL"); err != nil {
+		return err
+	}
+	u, err := url.Parse(options["URL"])
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("URL must be an absolute http or https URL, got %q", options["URL"])
+	}
+	if timeout := options["Timeout"]; timeout != "" {
+		if d, err := time.ParseDuration(timeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q", timeout)
+		}
+	}
+	return nil
+}
+
+func (httpDriver) Open(options Options) (DataSource, error) {
+	timeout, _ := time.ParseDuration(options.get("Timeout", "30s"))
+	return &httpSource{
+		url:    options["URL"],
+		token:  options["Token"],
+		path:   options["Path"],
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+type httpSource struct {
+	url    string
+	token  string
+	path   string
+	client *http.Client
+}
+
+// Columns are inferred from the values, so reading them reads the whole
+// data set.
+func (s *httpSource) Columns(ctx context.Context) ([]Column, error) {
+	rows, err := s.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return InferColumns(rows), nil
+}
+
+// Stream reads the response whole: an upstream API answers with one
+// document, which has to be decoded before the array inside it is found.
+func (s *httpSource) Stream(ctx context.Context, q Query, offset int64) (Rows, error) {
+	rows, err := s.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newQueryRows(ctx, q, InferColumns(rows), offset, sliceRows(rows), nil), nil
+}
+
+func (s *httpSource) fetch(ctx context.Context) ([]Row, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", s.url, res.Status)
+	}
+	if s.path == "" {
+		return decodeRows(res.Body)
+	}
+
+	var document interface{}
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	for _, key := range strings.Split(s.path, ".") {
+		object, ok := document.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("no %s in response of %s", s.path, s.url)
+		}
+		document = object[key]
+	}
+	items, ok := document.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s in response of %s is not an array", s.path, s.url)
+	}
+	ret := make([]Row, 0, len(items))
+	for i, item := range items {
+		row, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("item %d of %s is not an object", i, s.path)
+		}
+		ret = append(ret, row)
+	}
+	return ret, nil
+}
+
+func (s *httpSource) Close() error {
+	return nil
+}
@@ -0,0 +1,85 @@
+package datasource
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHTTPSource(t *testing.T) {
+	rows := []map[string]interface{}{{"id": 1, "name": "a"}, {"id": 2, "name": "b"}}
+	responses := map[string]interface{}{
+		"/rows":      rows,
+		"/nested":    map[string]interface{}{"data": map[string]interface{}{"items": rows}},
+		"/notarray":  map[string]interface{}{"data": map[string]interface{}{"items": map[string]interface{}{}}},
+		"/notobject": map[string]interface{}{"data": map[string]interface{}{"items": []int{1}}},
+		"/object":    map[string]interface{}{"id": 1},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer token" || req.Header.Get("Accept") != "application/json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		response, ok := responses[req.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	open := func(options Options) DataSource {
+		t.Helper()
+		if options["Token"] == "" {
+			options["Token"] = "token"
+		}
+		source, err := Open("http", options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return source
+	}
+
+	for _, path := range []string{"rows", "nested"} {
+		source := open(Options{"URL": server.URL + "/" + path, "Path": map[string]string{"rows": "", "nested": "data.items"}[path]})
+		columns, err := source.Columns(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if want := []Column{{Name: "id", Type: TypeInteger}, {Name: "name", Type: TypeString}}; !reflect.DeepEqual(columns, want) {
+			t.Fatalf("%s: columns %v, want %v", path, columns, want)
+		}
+		q := Query{Filters: []Filter{{Field: "name", Op: OpIn, Value: []interface{}{"b", "c"}}}}
+		if err := q.Check(); err != nil {
+			t.Fatal(err)
+		}
+		result, err := Select(context.Background(), source, q)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if want := []Row{{"id": json.Number("2"), "name": "b"}}; !reflect.DeepEqual(result.Rows, want) {
+			t.Fatalf("%s: selected %v, want %v", path, result.Rows, want)
+		}
+	}
+
+	for name, options := range map[string]Options{
+		"another token":   {"URL": server.URL + "/rows", "Token": "other"},
+		"missing":         {"URL": server.URL + "/missing"},
+		"object":          {"URL": server.URL + "/object"},
+		"path to object":  {"URL": server.URL + "/notarray", "Path": "data.items"},
+		"path to scalars": {"URL": server.URL + "/notobject", "Path": "data.items"},
+		"path too deep":   {"URL": server.URL + "/rows", "Path": "data.items"},
+		"timeout":         {"URL": server.URL + "/slow", "Timeout": "50ms"},
+	} {
+		if _, err := Select(context.Background(), open(options), Query{}); err == nil {
+			t.Errorf("%s: read without error", name)
+		}
+	}
+}
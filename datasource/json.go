@@ -0,0 +1,168 @@
+package datasource
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// jsonDriver reads a file holding either a JSON array of objects or one
+// object per line (NDJSON).
+//
+// json: Path, Format (optional, "json" or "ndjson", guessed from the file
+// extension by default)
+type jsonDriver struct{}
+
+func init() {
+	Register("json", jsonDriver{})
+}
+
+func (jsonDriver) Validate(options Options) error {
+	if err := options.require("Path"); err != nil {
+		return err
+	}
+	switch options["Format"] {
+	case "", "json", "ndjson":
+	default:
+		return fmt.Errorf("format must be json or ndjson, got %q", options["Format"])
+	}
+	return checkFile(options["Path"])
+}
+
+func (jsonDriver) Open(options Options) (DataSource, error) {
+	format := options["Format"]
+	if format == "" {
+		format = "json"
+		switch strings.ToLower(filepath.Ext(options["Path"])) {
+		case ".ndjson", ".jsonl":
+			format = "ndjson"
+		}
+	}
+	return &jsonSource{path: options["Path"], ndjson: format == "ndjson"}, nil
+}
+
+type jsonSource struct {
+	path   string
+	ndjson bool
+}
+
+// Columns are inferred from the values, so reading them reads the whole
+// data set, one row at a time.
+func (s *jsonSource) Columns(ctx context.Context) ([]Column, error) {
+	next, close, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+	types := make(inference)
+	for {
+		row, err := next()
+		if err == io.EOF {
+			return types.columns(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		types.add(row)
+	}
+}
+
+// Stream reads the file twice, once for the schema and once for the rows.
+func (s *jsonSource) Stream(ctx context.Context, q Query, offset int64) (Rows, error) {
+	columns, err := s.Columns(ctx)
+	if err != nil {
+		return nil, err
+	}
+	next, close, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	return newQueryRows(ctx, q, columns, offset, next, close), nil
+}
+
+// open returns a function reading the rows of the file one by one, which
+// returns io.EOF after the last.
+func (s *jsonSource) open() (func() (Row, error), func() error, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !s.ndjson {
+		next, err := arrayRows(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, fmt.Errorf("%s: %w", s.path, err)
+		}
+		return next, file.Close, nil
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	next := func() (Row, error) {
+		for scanner.Scan() {
+			line++
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			var row Row
+			decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+			decoder.UseNumber()
+			if err := decoder.Decode(&row); err != nil {
+				return nil, fmt.Errorf("%s line %d: %w", s.path, line, err)
+			}
+			return row, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	return next, file.Close, nil
+}
+
+func (s *jsonSource) Close() error {
+	return nil
+}
+
+// decodeRows decodes a JSON array of objects. Numbers are kept as written,
+// so that integers beyond float64 precision survive.
+func decodeRows(reader io.Reader) ([]Row, error) {
+	var ret []Row
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	if err := decoder.Decode(&ret); err != nil {
+		return nil, fmt.Errorf("expected a JSON array of objects: %w", err)
+	}
+	return ret, nil
+}
+
+// arrayRows decodes a JSON array of objects one element at a time, keeping
+// numbers as written like decodeRows.
+func arrayRows(reader io.Reader) (func() (Row, error), error) {
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return nil, fmt.Errorf("expected a JSON array of objects")
+	}
+	next := func() (Row, error) {
+		if !decoder.More() {
+			return nil, io.EOF
+		}
+		var row Row
+		if err := decoder.Decode(&row); err != nil {
+			return nil, fmt.Errorf("expected a JSON array of objects: %w", err)
+		}
+		return row, nil
+	}
+	return next, nil
+}
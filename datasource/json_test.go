@@ -0,0 +1,97 @@
+package datasource
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testJSONRows = `{"id": 1, "name": "a", "score": 2, "tags": ["x"]}
+{"id": 9007199254740993, "name": "b", "score": 1.5, "tags": "y"}
+
+{"id": 3, "name": null, "score": 3, "tags": null}
+`
+
+func TestJSONSource(t *testing.T) {
+	dir := t.TempDir()
+	lines := strings.Split(strings.TrimSpace(testJSONRows), "\n")
+	var objects []string
+	for _, line := range lines {
+		if line != "" {
+			objects = append(objects, line)
+		}
+	}
+	files := map[string]Options{
+		"rows.json":   {},
+		"rows.ndjson": {},
+		"rows.jsonl":  {},
+		"rows.txt":    {"Format": "ndjson"},
+	}
+	wantColumns := []Column{{Name: "id", Type: TypeInteger}, {Name: "name", Type: TypeString}, {Name: "score", Type: TypeFloat}, {Name: "tags", Type: TypeJSON}}
+
+	for name, options := range files {
+		t.Run(name, func(t *testing.T) {
+			content := testJSONRows
+			if name == "rows.json" {
+				content = "[" + strings.Join(objects, ",\n") + "]"
+			}
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			options["Path"] = path
+			source, err := Open("json", options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer source.Close()
+
+			columns, err := source.Columns(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(columns, wantColumns) {
+				t.Fatalf("columns %v, want %v", columns, wantColumns)
+			}
+			q := Query{Columns: []string{"id"}, Filters: []Filter{{Field: "id", Op: OpGreater, Value: float64(1)}}}
+			if err := q.Check(); err != nil {
+				t.Fatal(err)
+			}
+			result, err := Select(context.Background(), source, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// integers beyond float64 precision are kept as written
+			want := []Row{{"id": json.Number("9007199254740993")}, {"id": json.Number("3")}}
+			if !reflect.DeepEqual(result.Rows, want) {
+				t.Fatalf("selected %v, want %v", result.Rows, want)
+			}
+		})
+	}
+
+	for name, content := range map[string]string{
+		"object.json":    `{"id": 1}`,
+		"scalars.json":   `[1, 2]`,
+		"broken.ndjson":  "{\"id\": 1}\n{\"id\": \n",
+		"scalar.ndjson":  "{\"id\": 1}\n2\n",
+		"truncated.json": `[{"id": 1}, {"id"`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		source, err := Open("json", Options{"Path": path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Select(context.Background(), source, Query{}); err == nil {
+			t.Errorf("%s read without error", name)
+		} else if strings.HasSuffix(name, ".ndjson") && !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("%s: error %q does not name the line", name, err)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package datasource
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Masking actions
+const (
+	MaskDrop       = "drop"       // remove the column
+	MaskHash       = "hash"       // keyed hash, salted per recipient
+	MaskTruncate   = "truncate"   // keep the first Length characters
+	MaskGeneralize = "generalize" // round numbers down to Step, dates to Granularity
+	MaskTokenize   = "tokenize"   // stable token, the same for every recipient
+)
+
+// MaskingPolicy redacts columns of a data set before it leaves the
+// publisher. Version identifies the policy in query records and has to be
+// raised whenever the rules change. Secret keys hash and tokenize.
+type MaskingPolicy struct {
+	Version int        `json:"Version"`
+	Secret  string     `json:"Secret,omitempty"`
+	Rules   []MaskRule `json:"Rules"`
+}
+
+type MaskRule struct {
+	Column string `json:"Column"`
+	Action string `json:"Action"`
+	// truncate
+	Length int `json:"Length,omitempty"`
+	// generalize: numbers round down to a multiple of Step, dates to the
+	// start of their "year", "month" or "day"
+	Step        float64 `json:"Step,omitempty"`
+	Granularity string  `json:"Granularity,omitempty"`
+}
+
+// Validate checks the rules of the policy.
+func (p *MaskingPolicy) Validate() error {
+	if p.Version <= 0 {
+		return fmt.Errorf("masking policy version must be positive")
+	}
+	seen := make(map[string]bool, len(p.Rules))
+	for _, rule := range p.Rules {
+		if rule.Column == "" {
+			return fmt.Errorf("masking rule without column")
+		}
+		if seen[rule.Column] {
+			return fmt.Errorf("more than one masking rule for column %s", rule.Column)
+		}
+		seen[rule.Column] = true
+		switch rule.Action {
+		case MaskDrop:
+		case MaskHash, MaskTokenize:
+			if p.Secret == "" {
+				return fmt.Errorf("masking rule %s on %s needs a secret", rule.Action, rule.Column)
+			}
+		case MaskTruncate:
+			if rule.Length <= 0 {
+				return fmt.Errorf("truncate on %s needs a positive length", rule.Column)
+			}
+		case MaskGeneralize:
+			switch rule.Granularity {
+			case "", "year", "month", "day":
+			default:
+				return fmt.Errorf("generalize on %s: granularity must be year, month or day", rule.Column)
+			}
+			if rule.Step < 0 || (rule.Step == 0 && rule.Granularity == "") {
+				return fmt.Errorf("generalize on %s needs a positive step or a granularity", rule.Column)
+			}
+		default:
+			return fmt.Errorf("unknown masking action %q on %s", rule.Action, rule.Column)
+		}
+	}
+	return nil
+}
+
+// Masks reports whether the policy rewrites column.
+func (p *MaskingPolicy) Masks(column string) bool {
+	if p == nil {
+		return false
+	}
+	_, ok := p.rule(column)
+	return ok
+}
+
+// KeyedOnRecipient reports whether the policy masks values differently for
+// each recipient, which is only as good as the recipient's identity.
+func (p *MaskingPolicy) KeyedOnRecipient() bool {
+	if p == nil {
+		return false
+	}
+	for _, rule := range p.Rules {
+		if rule.Action == MaskHash {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckQuery refuses filters on masked columns, which would let a recipient
+// probe the raw values.
+func (p *MaskingPolicy) CheckQuery(q Query) error {
+	for _, f := range q.Filters {
+		if p.Masks(f.Field) {
+			return fmt.Errorf("column %s is masked and cannot be filtered on", f.Field)
+		}
+	}
+	return nil
+}
+
+// Apply masks the rows of result in place for recipient and rewrites its
+// schema to match. A value that cannot be generalized is replaced with null
+// rather than released as is.
+func (p *MaskingPolicy) Apply(result *Result, recipient string) {
+	if p == nil || len(p.Rules) == 0 {
+		return
+	}
+	types := make(map[string]string, len(result.Schema))
+	for _, column := range result.Schema {
+		types[column.Name] = column.Type
+	}
+	salt := hmacSHA256([]byte(p.Secret), recipient)
+	for _, row := range result.Rows {
+		for _, rule := range p.Rules {
+			value, ok := row[rule.Column]
+			if !ok {
+				continue
+			}
+			if rule.Action == MaskDrop {
+				delete(row, rule.Column)
+				continue
+			}
+			if value == nil {
+				continue
+			}
+			text := fmt.Sprint(value)
+			switch rule.Action {
+			case MaskHash:
+				row[rule.Column] = hex.EncodeToString(hmacSHA256(salt, text))
+			case MaskTokenize:
+				row[rule.Column] = "tok_" + hex.EncodeToString(hmacSHA256([]byte(p.Secret), rule.Column+"\x00"+text))[:16]
+			case MaskTruncate:
+				if runes := []rune(text); len(runes) > rule.Length {
+					row[rule.Column] = string(runes[:rule.Length])
+				} else {
+					row[rule.Column] = text
+				}
+			case MaskGeneralize:
+				row[rule.Column] = generalize(rule, text, types[rule.Column])
+			}
+		}
+	}
+
+	schema := make([]Column, 0, len(result.Schema))
+	for _, column := range result.Schema {
+		rule, ok := p.rule(column.Name)
+		if !ok {
+			schema = append(schema, column)
+			continue
+		}
+		if rule.Action == MaskDrop {
+			continue
+		}
+		masked := Column{Name: column.Name, Type: TypeString}
+		if rule.Action == MaskGeneralize && rule.Step > 0 {
+			masked.Type = TypeFloat
+			if column.Type == TypeInteger && rule.Step == math.Trunc(rule.Step) {
+				masked.Type = TypeInteger
+			}
+		}
+		schema = append(schema, masked)
+	}
+	result.Schema = schema
+}
+
+func (p *MaskingPolicy) rule(column string) (MaskRule, bool) {
+	for _, rule := range p.Rules {
+		if rule.Column == column {
+			return rule, true
+		}
+	}
+	return MaskRule{}, false
+}
+
+func hmacSHA256(key []byte, message string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(message))
+	return h.Sum(nil)
+}
+
+var dateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+func generalize(rule MaskRule, text string, typ string) interface{} {
+	if rule.Step > 0 {
+		if number, err := strconv.ParseFloat(text, 64); err == nil {
+			rounded := math.Floor(number/rule.Step) * rule.Step
+			if typ == TypeInteger && rule.Step == math.Trunc(rule.Step) {
+				return int64(rounded)
+			}
+			return rounded
+		}
+	}
+	if rule.Granularity != "" {
+		for _, layout := range dateLayouts {
+			t, err := time.Parse(layout, text)
+			if err != nil {
+				continue
+			}
+			switch rule.Granularity {
+			case "year":
+				return t.Format("2006")
+			case "month":
+				return t.Format("2006-01")
+			default:
+				return t.Format("2006-01-02")
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package datasource
+
+import "testing"
+
+func maskedResult(policy *MaskingPolicy, recipient string) Result {
+	result := Result{
+		Schema: []Column{
+			{Name: "id", Type: TypeInteger},
+			{Name: "name", Type: TypeString},
+			{Name: "email", Type: TypeString},
+			{Name: "city", Type: TypeString},
+			{Name: "age", Type: TypeInteger},
+			{Name: "born", Type: TypeString},
+			{Name: "note", Type: TypeString},
+		},
+		Rows: []Row{
+			{"id": int64(1), "name": "Alice", "email": "alice@example.com", "city": "Hangzhou", "age": int64(37), "born": "1987-06-05", "note": nil},
+		},
+	}
+	policy.Apply(&result, recipient)
+	return result
+}
+
+func TestMaskingPolicyApply(t *testing.T) {
+	policy := &MaskingPolicy{Version: 1, Secret: "secret", Rules: []MaskRule{
+		{Column: "name", Action: MaskHash},
+		{Column: "email", Action: MaskTokenize},
+		{Column: "city", Action: MaskTruncate, Length: 3},
+		{Column: "age", Action: MaskGeneralize, Step: 10},
+		{Column: "born", Action: MaskGeneralize, Granularity: "year"},
+		{Column: "note", Action: MaskDrop},
+	}}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	result := maskedResult(policy, "consumer")
+	row := result.Rows[0]
+	if name, _ := row["name"].(string); len(name) != 64 || name == "Alice" {
+		t.Errorf("name not hashed: %v", row["name"])
+	}
+	if email, _ := row["email"].(string); len(email) != 20 || email[:4] != "tok_" {
+		t.Errorf("email not tokenized: %v", row["email"])
+	}
+	if row["city"] != "Han" || row["age"] != int64(30) || row["born"] != "1987" || row["id"] != int64(1) {
+		t.Errorf("masked row %v", row)
+	}
+	if _, ok := row["note"]; ok {
+		t.Errorf("dropped column released: %v", row)
+	}
+
+	types := map[string]string{}
+	for _, column := range result.Schema {
+		types[column.Name] = column.Type
+	}
+	want := map[string]string{"id": TypeInteger, "name": TypeString, "email": TypeString, "city": TypeString, "age": TypeInteger, "born": TypeString}
+	if len(types) != len(want) {
+		t.Fatalf("schema %v", result.Schema)
+	}
+	for name, typ := range want {
+		if types[name] != typ {
+			t.Errorf("column %s is %s, want %s", name, types[name], typ)
+		}
+	}
+
+	// hashes differ between recipients, tokens do not
+	other := maskedResult(policy, "other").Rows[0]
+	if other["name"] == row["name"] {
+		t.Error("hash is the same for every recipient")
+	}
+	if other["email"] != row["email"] {
+		t.Error("token differs between recipients")
+	}
+	if !policy.KeyedOnRecipient() {
+		t.Error("policy hashing a column is not keyed on the recipient")
+	}
+}
+
+func TestMaskingPolicyGeneralizeUnparsable(t *testing.T) {
+	policy := &MaskingPolicy{Version: 1, Rules: []MaskRule{{Column: "age", Action: MaskGeneralize, Step: 10}}}
+	result := Result{Schema: []Column{{Name: "age", Type: TypeString}}, Rows: []Row{{"age": "unknown"}}}
+	policy.Apply(&result, "consumer")
+	if result.Rows[0]["age"] != nil {
+		t.Fatalf("value that cannot be generalized released: %v", result.Rows[0])
+	}
+}
+
+func TestMaskingPolicyValidate(t *testing.T) {
+	invalid := map[string]MaskingPolicy{
+		"no version":            {Rules: []MaskRule{{Column: "a", Action: MaskDrop}}},
+		"no column":             {Version: 1, Rules: []MaskRule{{Action: MaskDrop}}},
+		"two rules":             {Version: 1, Rules: []MaskRule{{Column: "a", Action: MaskDrop}, {Column: "a", Action: MaskDrop}}},
+		"hash no secret":        {Version: 1, Rules: []MaskRule{{Column: "a", Action: MaskHash}}},
+		"truncate nothing":      {Version: 1, Rules: []MaskRule{{Column: "a", Action: MaskTruncate}}},
+		"generalize to nothing": {Version: 1, Rules: []MaskRule{{Column: "a", Action: MaskGeneralize}}},
+		"unknown unit":          {Version: 1, Rules: []MaskRule{{Column: "a", Action: MaskGeneralize, Granularity: "week"}}},
+		"unknown action":        {Version: 1, Rules: []MaskRule{{Column: "a", Action: "encrypt"}}},
+		"negative step":         {Version: 1, Rules: []MaskRule{{Column: "a", Action: MaskGeneralize, Step: -1}}},
+		"tokenize no secret":    {Version: 1, Rules: []MaskRule{{Column: "a", Action: MaskTokenize}}},
+	}
+	for name, policy := range invalid {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("policy with %s is valid", name)
+		}
+	}
+}
+
+func TestMaskingPolicyCheckQuery(t *testing.T) {
+	policy := &MaskingPolicy{Version: 1, Rules: []MaskRule{{Column: "name", Action: MaskDrop}}}
+	if err := policy.CheckQuery(Query{Filters: []Filter{{Field: "name", Op: OpEqual, Value: "Alice"}}}); err == nil {
+		t.Error("filter on a masked column allowed")
+	}
+	if err := policy.CheckQuery(Query{Filters: []Filter{{Field: "id", Op: OpEqual, Value: 1}}}); err != nil {
+		t.Error(err)
+	}
+	var none *MaskingPolicy
+	if none.Masks("name") || none.KeyedOnRecipient() {
+		t.Error("no policy masks")
+	}
+}
@@ -0,0 +1,71 @@
+package datasource
+
+import (
+	"context"
+	"fmt"
+)
+
+// Stages of a probe, in order
+const (
+	StageOptions = "options" // the options are invalid
+	StageConnect = "connect" // the source cannot be reached or logged into
+	StageTable   = "table"   // the table or file is missing or has no columns
+	StageRead    = "read"    // the rows cannot be read
+)
+
+// ProbeError tells at which stage a data source failed its probe.
+type ProbeError struct {
+	Stage string
+	Err   error
+}
+
+func (e *ProbeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Stage, e.Err)
+}
+
+func (e *ProbeError) Unwrap() error {
+	return e.Err
+}
+
+// pinger is implemented by sources that connect before they are read, so a
+// probe can tell a wrong host or login from a wrong table.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Probe opens the data source of type typ, checks that its table exists and
+// that a row can be read, and returns the detected columns. Failures are a
+// *ProbeError.
+func Probe(ctx context.Context, typ string, options Options) ([]Column, error) {
+	if err := Validate(typ, options); err != nil {
+		return nil, &ProbeError{StageOptions, err}
+	}
+	source, err := Open(typ, options)
+	if err != nil {
+		return nil, &ProbeError{StageConnect, err}
+	}
+	defer source.Close()
+	if p, ok := source.(pinger); ok {
+		if err := p.Ping(ctx); err != nil {
+			return nil, &ProbeError{StageConnect, err}
+		}
+	}
+
+	columns, err := source.Columns(ctx)
+	if err == nil && len(columns) == 0 {
+		err = fmt.Errorf("no columns")
+	}
+	if err != nil {
+		return nil, &ProbeError{StageTable, err}
+	}
+	rows, err := source.Stream(ctx, Query{}, 0)
+	if err != nil {
+		return nil, &ProbeError{StageRead, err}
+	}
+	defer rows.Close()
+	rows.Next()
+	if err := rows.Err(); err != nil {
+		return nil, &ProbeError{StageRead, err}
+	}
+	return columns, nil
+}
@@ -0,0 +1,252 @@
+package datasource
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Query selects columns and rows of a data set. The zero Query selects
+// everything.
+type Query struct {
+	Columns []string `json:"Columns"`
+	Filters []Filter `json:"Filters"`
+}
+
+// Filter keeps the rows whose Field compares to Value by Op. A row without
+// the field never matches.
+type Filter struct {
+	Field string      `json:"Field"`
+	Op    string      `json:"Op"`
+	Value interface{} `json:"Value"`
+}
+
+// Filter operators. "in" takes an array of values, "contains" a string that
+// is matched as a substring.
+const (
+	OpEqual        = "="
+	OpNotEqual     = "!="
+	OpLess         = "<"
+	OpLessEqual    = "<="
+	OpGreater      = ">"
+	OpGreaterEqual = ">="
+	OpIn           = "in"
+	OpContains     = "contains"
+)
+
+// maxInValues bounds the values of an "in" filter
+const maxInValues = 1000
+
+// Check validates the shape of q without a schema and brings its values into
+// canonical form: whole numbers become int64. It must be called before a
+// query is normalized or run.
+func (q *Query) Check() error {
+	for _, column := range q.Columns {
+		if column == "" {
+			return fmt.Errorf("empty column name")
+		}
+	}
+	for i := range q.Filters {
+		f := &q.Filters[i]
+		if f.Field == "" {
+			return fmt.Errorf("filter %d has no field", i)
+		}
+		switch f.Op {
+		case OpEqual, OpNotEqual, OpLess, OpLessEqual, OpGreater, OpGreaterEqual:
+			value, err := scalar(f.Value)
+			if err != nil {
+				return fmt.Errorf("filter on %s: %w", f.Field, err)
+			}
+			f.Value = value
+		case OpContains:
+			if _, ok := f.Value.(string); !ok {
+				return fmt.Errorf("filter on %s: contains takes a string", f.Field)
+			}
+		case OpIn:
+			values, ok := f.Value.([]interface{})
+			if !ok || len(values) == 0 || len(values) > maxInValues {
+				return fmt.Errorf("filter on %s: in takes 1 to %d values", f.Field, maxInValues)
+			}
+			canonical := make([]interface{}, len(values))
+			for j, value := range values {
+				value, err := scalar(value)
+				if err != nil {
+					return fmt.Errorf("filter on %s: %w", f.Field, err)
+				}
+				canonical[j] = value
+			}
+			f.Value = canonical
+		default:
+			return fmt.Errorf("filter on %s: unknown operator %q", f.Field, f.Op)
+		}
+	}
+	return nil
+}
+
+func scalar(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string, bool, int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v), nil
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("value %v is not a string, number or boolean", value)
+	}
+}
+
+// Validate checks that q only names columns of the data set.
+func (q Query) Validate(columns []Column) error {
+	known := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		known[column.Name] = true
+	}
+	for _, column := range q.Columns {
+		if !known[column] {
+			return fmt.Errorf("unknown column %q", column)
+		}
+	}
+	for _, f := range q.Filters {
+		if !known[f.Field] {
+			return fmt.Errorf("unknown filter field %q", f.Field)
+		}
+	}
+	return nil
+}
+
+// Normalize renders q as canonical SQL-like text over table, with columns
+// and filters sorted and values inlined, so that equivalent queries read the
+// same. It is what gets hashed into the query record.
+func (q Query) Normalize(table string) string {
+	columns := "*"
+	if len(q.Columns) > 0 {
+		quoted := make([]string, 0, len(q.Columns))
+		seen := make(map[string]bool, len(q.Columns))
+		for _, column := range q.Columns {
+			if !seen[column] {
+				seen[column] = true
+				quoted = append(quoted, quoteName(column))
+			}
+		}
+		sort.Strings(quoted)
+		columns = strings.Join(quoted, ", ")
+	}
+	text := "SELECT " + columns + " FROM " + quoteName(table)
+	if len(q.Filters) == 0 {
+		return text
+	}
+	conditions := make([]string, len(q.Filters))
+	for i, f := range q.Filters {
+		value := literal(f.Value)
+		if values, ok := f.Value.([]interface{}); ok {
+			literals := make([]string, len(values))
+			for j, v := range values {
+				literals[j] = literal(v)
+			}
+			sort.Strings(literals)
+			value = "(" + strings.Join(literals, ", ") + ")"
+		}
+		conditions[i] = quoteName(f.Field) + " " + strings.ToUpper(f.Op) + " " + value
+	}
+	sort.Strings(conditions)
+	return text + " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// Digest is the hex SHA-256 of the normalized text of q over table.
+func (q Query) Digest(table string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(q.Normalize(table))))
+}
+
+func quoteName(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func literal(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	default:
+		text, _ := json.Marshal(v)
+		return string(text)
+	}
+}
+
+// projectRow keeps the columns q selects of a row read in memory.
+func (q Query) projectRow(row Row) Row {
+	if len(q.Columns) == 0 {
+		return row
+	}
+	projected := make(Row, len(q.Columns))
+	for _, column := range q.Columns {
+		if value, ok := row[column]; ok {
+			projected[column] = value
+		}
+	}
+	return projected
+}
+
+func (q Query) match(row Row) bool {
+	for _, f := range q.Filters {
+		value, ok := row[f.Field]
+		if !ok || value == nil || !f.match(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f Filter) match(value interface{}) bool {
+	switch f.Op {
+	case OpContains:
+		return strings.Contains(fmt.Sprint(value), f.Value.(string))
+	case OpIn:
+		for _, v := range f.Value.([]interface{}) {
+			if compare(value, v) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	c := compare(value, f.Value)
+	switch f.Op {
+	case OpEqual:
+		return c == 0
+	case OpNotEqual:
+		return c != 0
+	case OpLess:
+		return c < 0
+	case OpLessEqual:
+		return c <= 0
+	case OpGreater:
+		return c > 0
+	case OpGreaterEqual:
+		return c >= 0
+	}
+	return false
+}
+
+// compare orders two values numerically when both read as numbers, since
+// file sources hold numbers as text, and as text otherwise.
+func compare(a, b interface{}) int {
+	x, xErr := strconv.ParseFloat(fmt.Sprint(a), 64)
+	y, yErr := strconv.ParseFloat(fmt.Sprint(b), 64)
+	if xErr == nil && yErr == nil {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
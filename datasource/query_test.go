@@ -0,0 +1,172 @@
+package datasource
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQueryCheck(t *testing.T) {
+	q := Query{Filters: []Filter{
+		{Field: "a", Op: OpEqual, Value: float64(2)},
+		{Field: "b", Op: OpLess, Value: 2.5},
+		{Field: "c", Op: OpIn, Value: []interface{}{float64(1), "x", true}},
+		{Field: "d", Op: OpNotEqual, Value: 3},
+	}}
+	if err := q.Check(); err != nil {
+		t.Fatal(err)
+	}
+	// whole numbers become int64
+	want := []interface{}{int64(2), 2.5, []interface{}{int64(1), "x", true}, int64(3)}
+	for i, f := range q.Filters {
+		if !reflect.DeepEqual(f.Value, want[i]) {
+			t.Errorf("filter on %s has value %#v, want %#v", f.Field, f.Value, want[i])
+		}
+	}
+
+	tooMany := make([]interface{}, maxInValues+1)
+	for i := range tooMany {
+		tooMany[i] = "x"
+	}
+	for name, q := range map[string]Query{
+		"empty column":        {Columns: []string{"a", ""}},
+		"no field":            {Filters: []Filter{{Op: OpEqual, Value: "x"}}},
+		"unknown operator":    {Filters: []Filter{{Field: "a", Op: "like", Value: "x"}}},
+		"object value":        {Filters: []Filter{{Field: "a", Op: OpEqual, Value: map[string]interface{}{}}}},
+		"null value":          {Filters: []Filter{{Field: "a", Op: OpGreater}}},
+		"contains a number":   {Filters: []Filter{{Field: "a", Op: OpContains, Value: float64(1)}}},
+		"in a scalar":         {Filters: []Filter{{Field: "a", Op: OpIn, Value: "x"}}},
+		"in nothing":          {Filters: []Filter{{Field: "a", Op: OpIn, Value: []interface{}{}}}},
+		"in too many":         {Filters: []Filter{{Field: "a", Op: OpIn, Value: tooMany}}},
+		"in an array":         {Filters: []Filter{{Field: "a", Op: OpIn, Value: []interface{}{[]interface{}{"x"}}}}},
+		"uppercase operator":  {Filters: []Filter{{Field: "a", Op: "IN", Value: []interface{}{"x"}}}},
+		"operator with space": {Filters: []Filter{{Field: "a", Op: " =", Value: "x"}}},
+	} {
+		if err := q.Check(); err == nil {
+			t.Errorf("%s: Check accepted %+v", name, q)
+		}
+	}
+
+	columns := []Column{{Name: "a"}, {Name: "b"}}
+	if err := (Query{Columns: []string{"a"}, Filters: []Filter{{Field: "b"}}}).Validate(columns); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	if err := (Query{Columns: []string{"c"}}).Validate(columns); err == nil {
+		t.Error("Validate accepted an unknown column")
+	}
+	if err := (Query{Filters: []Filter{{Field: "c"}}}).Validate(columns); err == nil {
+		t.Error("Validate accepted an unknown filter field")
+	}
+}
+
+func TestQueryNormalize(t *testing.T) {
+	check := func(q Query) Query {
+		t.Helper()
+		if err := q.Check(); err != nil {
+			t.Fatal(err)
+		}
+		return q
+	}
+	q := check(Query{
+		Columns: []string{"name", "id", "name"},
+		Filters: []Filter{
+			{Field: "id", Op: OpIn, Value: []interface{}{float64(3), float64(1)}},
+			{Field: "name", Op: OpContains, Value: "O'Brien"},
+			{Field: `we"ird`, Op: OpGreaterEqual, Value: 1.5},
+		},
+	})
+	want := `SELECT "id", "name" FROM "t" WHERE "id" IN (1, 3) AND "name" CONTAINS 'O''Brien' AND "we""ird" >= 1.5`
+	if text := q.Normalize("t"); text != want {
+		t.Fatalf("normalized to\n%s\nwant\n%s", text, want)
+	}
+
+	// equivalent queries read the same
+	same := check(Query{
+		Columns: []string{"id", "name"},
+		Filters: []Filter{
+			{Field: `we"ird`, Op: OpGreaterEqual, Value: 1.5},
+			{Field: "name", Op: OpContains, Value: "O'Brien"},
+			{Field: "id", Op: OpIn, Value: []interface{}{1, 3}},
+		},
+	})
+	if same.Normalize("t") != want || same.Digest("t") != q.Digest("t") {
+		t.Fatalf("equivalent query normalized to %s", same.Normalize("t"))
+	}
+	if len(q.Digest("t")) != 64 || strings.Trim(q.Digest("t"), "0123456789abcdef") != "" {
+		t.Fatalf("digest %s is not hex SHA-256", q.Digest("t"))
+	}
+
+	// anything else does not
+	digests := map[string]string{q.Digest("t"): "query"}
+	for name, other := range map[string]Query{
+		"another table":    q,
+		"all columns":      check(Query{Filters: q.Filters}),
+		"no filters":       check(Query{Columns: q.Columns}),
+		"a number as text": check(Query{Filters: []Filter{{Field: "id", Op: OpEqual, Value: "1"}}}),
+		"a number":         check(Query{Filters: []Filter{{Field: "id", Op: OpEqual, Value: float64(1)}}}),
+		"another operator": check(Query{Filters: []Filter{{Field: "id", Op: OpNotEqual, Value: float64(1)}}}),
+		"an injection":     check(Query{Filters: []Filter{{Field: "id", Op: OpEqual, Value: "1' OR '1'='1"}}}),
+	} {
+		table := "t"
+		if name == "another table" {
+			table = "u"
+		}
+		digest := other.Digest(table)
+		if previous, ok := digests[digest]; ok {
+			t.Errorf("%s has the digest of %s: %s", name, previous, other.Normalize(table))
+		}
+		digests[digest] = name
+	}
+	if text := (Query{}).Normalize("t"); text != `SELECT * FROM "t"` {
+		t.Fatalf("zero query normalized to %s", text)
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	for _, tc := range []struct {
+		value  interface{}
+		filter Filter
+		want   bool
+	}{
+		// file sources hold numbers as text, which compare as numbers
+		{"10", Filter{Op: OpGreater, Value: int64(9)}, true},
+		{"10", Filter{Op: OpGreater, Value: "9"}, true},
+		{"1e1", Filter{Op: OpEqual, Value: int64(10)}, true},
+		{int64(2), Filter{Op: OpLessEqual, Value: 2.0}, true},
+		{2.5, Filter{Op: OpLess, Value: int64(2)}, false},
+		{"abc", Filter{Op: OpLess, Value: "abd"}, true},
+		{"abc", Filter{Op: OpGreaterEqual, Value: "abc"}, true},
+		{"abc", Filter{Op: OpNotEqual, Value: "abc"}, false},
+		{"10", Filter{Op: OpNotEqual, Value: "10.0"}, false},
+		{true, Filter{Op: OpEqual, Value: true}, true},
+		{"hello world", Filter{Op: OpContains, Value: "o w"}, true},
+		{int64(1234), Filter{Op: OpContains, Value: "23"}, true},
+		{"x", Filter{Op: OpContains, Value: "X"}, false},
+		{"2", Filter{Op: OpIn, Value: []interface{}{int64(1), int64(2)}}, true},
+		{"c", Filter{Op: OpIn, Value: []interface{}{"a", "b"}}, false},
+	} {
+		tc.filter.Field = "f"
+		if got := tc.filter.match(tc.value); got != tc.want {
+			t.Errorf("%#v %s %#v = %v", tc.value, tc.filter.Op, tc.filter.Value, got)
+		}
+	}
+
+	q := Query{Filters: []Filter{{Field: "a", Op: OpEqual, Value: int64(1)}, {Field: "b", Op: OpNotEqual, Value: "x"}}}
+	for _, tc := range []struct {
+		row  Row
+		want bool
+	}{
+		{Row{"a": "1", "b": "y"}, true},
+		{Row{"a": "1", "b": "x"}, false},
+		// a row without the field, or with null, never matches, != included
+		{Row{"a": "1"}, false},
+		{Row{"a": "1", "b": nil}, false},
+	} {
+		if got := q.match(tc.row); got != tc.want {
+			t.Errorf("match(%v) = %v", tc.row, got)
+		}
+	}
+	if compare("a", "b") >= 0 || compare(int64(3), "3.0") != 0 || compare("2", "10") >= 0 {
+		t.Error("compare")
+	}
+}
@@ -0,0 +1,56 @@
+package datasource
+
+import "fmt"
+
+// RowRule restricts the rows a recipient sees to those whose Field equals
+// one of the values of Attribute in the recipient's identity.
+type RowRule struct {
+	Field     string `json:"Field"`
+	Attribute string `json:"Attribute"`
+}
+
+// Identity attributes a RowRule can refer to
+const (
+	AttributeMSPID = "MSPID"
+	AttributeOU    = "OU"
+	AttributeCN    = "CN"
+)
+
+// ValidateRowRules checks rules without a schema; their fields are checked
+// with the query they end up in.
+func ValidateRowRules(rules []RowRule) error {
+	for _, rule := range rules {
+		if rule.Field == "" {
+			return fmt.Errorf("row rule without field")
+		}
+		switch rule.Attribute {
+		case AttributeMSPID, AttributeOU, AttributeCN:
+		default:
+			return fmt.Errorf("row rule on %s: attribute must be MSPID, OU or CN, got %q", rule.Field, rule.Attribute)
+		}
+	}
+	return nil
+}
+
+// RowFilters turns rules into the filters for a recipient with the given
+// identity attributes. A recipient lacking an attribute a rule refers to is
+// refused rather than shown every row.
+func RowFilters(rules []RowRule, attributes map[string][]string) ([]Filter, error) {
+	filters := make([]Filter, 0, len(rules))
+	for _, rule := range rules {
+		values := attributes[rule.Attribute]
+		switch len(values) {
+		case 0:
+			return nil, fmt.Errorf("identity has no %s, which rows of this service are restricted by", rule.Attribute)
+		case 1:
+			filters = append(filters, Filter{Field: rule.Field, Op: OpEqual, Value: values[0]})
+		default:
+			in := make([]interface{}, len(values))
+			for i, value := range values {
+				in[i] = value
+			}
+			filters = append(filters, Filter{Field: rule.Field, Op: OpIn, Value: in})
+		}
+	}
+	return filters, nil
+}
@@ -0,0 +1,45 @@
+package datasource
+
+import "testing"
+
+func TestRowFilters(t *testing.T) {
+	rules := []RowRule{{Field: "region", Attribute: AttributeMSPID}, {Field: "team", Attribute: AttributeOU}}
+	if err := ValidateRowRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	filters, err := RowFilters(rules, map[string][]string{
+		AttributeMSPID: {"Org2MSP"},
+		AttributeOU:    {"client", "sales"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) != 2 {
+		t.Fatalf("filters %+v", filters)
+	}
+	if f := filters[0]; f.Field != "region" || f.Op != OpEqual || f.Value != "Org2MSP" {
+		t.Errorf("filter on one value %+v", f)
+	}
+	if f := filters[1]; f.Field != "team" || f.Op != OpIn {
+		t.Errorf("filter on two values %+v", f)
+	} else if in, _ := f.Value.([]interface{}); len(in) != 2 || in[0] != "client" || in[1] != "sales" {
+		t.Errorf("filter on two values %+v", f)
+	}
+
+	// a recipient without the attribute sees nothing rather than everything
+	if _, err := RowFilters(rules, map[string][]string{AttributeMSPID: {"Org2MSP"}}); err == nil {
+		t.Error("recipient without an OU got filters")
+	}
+}
+
+func TestValidateRowRules(t *testing.T) {
+	for _, rules := range [][]RowRule{
+		{{Attribute: AttributeMSPID}},
+		{{Field: "region", Attribute: "O"}},
+		{{Field: "region"}},
+	} {
+		if err := ValidateRowRules(rules); err == nil {
+			t.Errorf("rules %+v are valid", rules)
+		}
+	}
+}
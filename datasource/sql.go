@@ -0,0 +1,334 @@
+package datasource
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqlDriver reads one table of a SQL database.
+//
+// mysql, postgres: Host, Port, User, Password, Database, Table
+// sqlite: Path, Table
+type sqlDriver struct {
+	driverName string
+	// quote quotes an identifier in the SQL dialect
+	quote func(string) string
+	// placeholder returns the marker of the nth parameter, counting from 1
+	placeholder func(n int) string
+	// noLimit is the LIMIT parameter that sets no limit
+	noLimit interface{}
+}
+
+func init() {
+	backtick := func(name string) string { return "`" + strings.ReplaceAll(name, "`", "``") + "`" }
+	doubleQuote := func(name string) string { return `"` + strings.ReplaceAll(name, `"`, `""`) + `"` }
+	question := func(int) string { return "?" }
+	dollar := func(n int) string { return "$" + strconv.Itoa(n) }
+	Register("mysql", sqlDriver{driverName: "mysql", quote: backtick, placeholder: question, noLimit: uint64(math.MaxUint64)})
+	Register("postgres", sqlDriver{driverName: "postgres", quote: doubleQuote, placeholder: dollar, noLimit: nil})
+	Register("sqlite", sqlDriver{driverName: "sqlite3", quote: doubleQuote, placeholder: question, noLimit: int64(-1)})
+}
+
+func (d sqlDriver) Validate(options Options) error {
+	if d.driverName == "sqlite3" {
+		if err := options.require("Path", "Table"); err != nil {
+			return err
+		}
+		return validIdentifier(options["Table"])
+	}
+	if err := options.require("Host", "Port", "User", "Database", "Table"); err != nil {
+		return err
+	}
+	if port, err := strconv.Atoi(options["Port"]); err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port %q", options["Port"])
+	}
+	return validIdentifier(options["Table"])
+}
+
+func (d sqlDriver) dsn(options Options) string {
+	switch d.driverName {
+	case "mysql":
+		return options["User"] + ":" + options["Password"] + "@tcp(" + options["Host"] + ":" + options["Port"] + ")/" + options["Database"]
+	case "postgres":
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(options["User"], options["Password"]),
+			Host:     options["Host"] + ":" + options["Port"],
+			Path:     "/" + options["Database"],
+			RawQuery: "sslmode=" + options.get("SSLMode", "disable"),
+		}
+		return dsn.String()
+	default:
+		return "file:" + options["Path"] + "?mode=ro"
+	}
+}
+
+func (d sqlDriver) Open(options Options) (DataSource, error) {
+	db, err := sql.Open(d.driverName, d.dsn(options))
+	if err != nil {
+		return nil, err
+	}
+	return &sqlSource{db: db, driver: d, name: options["Table"], table: d.quoteTable(options["Table"])}, nil
+}
+
+func (d sqlDriver) quoteTable(table string) string {
+	parts := strings.Split(table, ".")
+	for i, part := range parts {
+		parts[i] = d.quote(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+type sqlSource struct {
+	db     *sql.DB
+	driver sqlDriver
+	name   string // the table as configured
+	table  string // the table quoted for statements
+}
+
+func (s *sqlSource) Columns(ctx context.Context) ([]Column, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT * FROM "+s.table+" WHERE 1 = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return columnsOfRows(rows)
+}
+
+func columnsOfRows(rows *sql.Rows) ([]Column, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]Column, len(types))
+	for i, t := range types {
+		columns[i] = Column{Name: t.Name(), Type: sqlColumnType(t.DatabaseTypeName()), SQLType: t.DatabaseTypeName()}
+	}
+	return columns, nil
+}
+
+// build renders q as a parameterized statement, ordered by orderBy and
+// starting at row offset. Column names come from the schema and are quoted,
+// values are only ever passed as parameters.
+func (s *sqlSource) build(q Query, orderBy []string, offset int64) (string, []interface{}) {
+	columns := "*"
+	if len(q.Columns) > 0 {
+		quoted := make([]string, len(q.Columns))
+		for i, column := range q.Columns {
+			quoted[i] = s.driver.quote(column)
+		}
+		columns = strings.Join(quoted, ", ")
+	}
+	statement := "SELECT " + columns + " FROM " + s.table
+
+	var conditions []string
+	var args []interface{}
+	param := func(value interface{}) string {
+		args = append(args, value)
+		return s.driver.placeholder(len(args))
+	}
+	for _, f := range q.Filters {
+		field := s.driver.quote(f.Field)
+		switch f.Op {
+		case OpIn:
+			values := f.Value.([]interface{})
+			markers := make([]string, len(values))
+			for i, value := range values {
+				markers[i] = param(value)
+			}
+			conditions = append(conditions, field+" IN ("+strings.Join(markers, ", ")+")")
+		case OpContains:
+			pattern := "%" + likeEscaper.Replace(f.Value.(string)) + "%"
+			conditions = append(conditions, field+" LIKE "+param(pattern)+" ESCAPE '!'")
+		case OpNotEqual:
+			conditions = append(conditions, field+" <> "+param(f.Value))
+		default:
+			conditions = append(conditions, field+" "+f.Op+" "+param(f.Value))
+		}
+	}
+	if len(conditions) > 0 {
+		statement += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if len(orderBy) > 0 {
+		quoted := make([]string, len(orderBy))
+		for i, column := range orderBy {
+			quoted[i] = s.driver.quote(column)
+		}
+		statement += " ORDER BY " + strings.Join(quoted, ", ")
+	}
+	if offset > 0 {
+		statement += " LIMIT " + param(s.driver.noLimit) + " OFFSET " + param(offset)
+	}
+	return statement, args
+}
+
+// orderBy returns the columns that order the rows of the table the same way
+// on every read, so that a stream continued from an offset neither skips nor
+// repeats rows: its primary key, or else every column that can be compared.
+func (s *sqlSource) orderBy(ctx context.Context) ([]string, error) {
+	var statement string
+	var args []interface{}
+	schema, table, qualified := strings.Cut(s.name, ".")
+	if !qualified {
+		table = schema
+	}
+	switch s.driver.driverName {
+	case "mysql":
+		statement = "SELECT COLUMN_NAME FROM information_schema.KEY_COLUMN_USAGE WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND CONSTRAINT_NAME = 'PRIMARY' ORDER BY ORDINAL_POSITION"
+		args = []interface{}{table}
+		if qualified {
+			statement = "SELECT COLUMN_NAME FROM information_schema.KEY_COLUMN_USAGE WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND CONSTRAINT_NAME = 'PRIMARY' ORDER BY ORDINAL_POSITION"
+			args = []interface{}{schema, table}
+		}
+	case "postgres":
+		statement = `SELECT a.attname FROM pg_index i JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+			WHERE i.indrelid = to_regclass($1) AND i.indisprimary ORDER BY array_position(i.indkey::int2[], a.attnum)`
+		args = []interface{}{s.table}
+	default:
+		statement = "SELECT name FROM pragma_table_info(?) WHERE pk > 0 ORDER BY pk"
+		args = []interface{}{table}
+		if qualified {
+			statement = "SELECT name FROM pragma_table_info(?, ?) WHERE pk > 0 ORDER BY pk"
+			args = []interface{}{table, schema}
+		}
+	}
+	rows, err := s.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read primary key of %s: %w", s.name, err)
+	}
+	defer rows.Close()
+	var key []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		key = append(key, column)
+	}
+	if err := rows.Err(); err != nil || len(key) > 0 {
+		return key, err
+	}
+
+	columns, err := s.Columns(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range columns {
+		if column.Type != TypeJSON && column.Type != TypeBytes {
+			key = append(key, column.Name)
+		}
+	}
+	return key, nil
+}
+
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+func (s *sqlSource) Stream(ctx context.Context, q Query, offset int64) (Rows, error) {
+	orderBy, err := s.orderBy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statement, args := s.build(q, orderBy, offset)
+	rows, err := s.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := columnsOfRows(rows)
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	return &sqlRows{rows: rows, schema: columns}, nil
+}
+
+type sqlRows struct {
+	rows   *sql.Rows
+	schema []Column
+	row    Row
+	err    error
+}
+
+func (r *sqlRows) Schema() []Column {
+	return r.schema
+}
+
+func (r *sqlRows) Next() bool {
+	if r.err != nil || !r.rows.Next() {
+		return false
+	}
+	values := make([]interface{}, len(r.schema))
+	buff := make([]interface{}, len(r.schema))
+	for i := range buff {
+		buff[i] = &values[i]
+	}
+	if r.err = r.rows.Scan(buff...); r.err != nil {
+		return false
+	}
+	r.row = make(Row, len(r.schema))
+	for i, column := range r.schema {
+		r.row[column.Name] = convert(column.Type, values[i])
+	}
+	return true
+}
+
+func (r *sqlRows) Row() Row {
+	return r.row
+}
+
+func (r *sqlRows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.rows.Err()
+}
+
+func (r *sqlRows) Close() error {
+	return r.rows.Close()
+}
+
+// Count reads the row estimate of the table statistics where the database
+// keeps one, and counts the rows otherwise.
+func (s *sqlSource) Count(ctx context.Context) (int64, error) {
+	var estimate sql.NullInt64
+	var err error
+	schema, table, qualified := strings.Cut(s.name, ".")
+	if !qualified {
+		table = schema
+	}
+	switch s.driver.driverName {
+	case "mysql":
+		statement := "SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?"
+		args := []interface{}{table}
+		if qualified {
+			statement = "SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?"
+			args = []interface{}{schema, table}
+		}
+		err = s.db.QueryRowContext(ctx, statement, args...).Scan(&estimate)
+	case "postgres":
+		err = s.db.QueryRowContext(ctx, "SELECT reltuples::bigint FROM pg_class WHERE oid = to_regclass($1)", s.table).Scan(&estimate)
+	}
+	// tables that were never analyzed have no estimate
+	if err == nil && estimate.Valid && estimate.Int64 >= 0 {
+		return estimate.Int64, nil
+	}
+	var count int64
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+s.table).Scan(&count)
+	return count, err
+}
+
+func (s *sqlSource) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlSource) Close() error {
+	return s.db.Close()
+}
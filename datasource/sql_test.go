@@ -0,0 +1,77 @@
+package datasource
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// newTestSQLite creates table t with schema, which has an id and a name,
+// inserts a row for each of ids in that order and opens it as table, "t"
+// or "main.t".
+func newTestSQLite(t *testing.T, table string, schema string, ids ...int) DataSource {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE t " + schema); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		// names sort the other way round from ids
+		if _, err := db.Exec("INSERT INTO t (id, name) VALUES (?, ?)", id, fmt.Sprintf("name-%d", 9-id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	source, err := Open("sqlite", Options{"Path": path, "Table": table})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { source.Close() })
+	return source
+}
+
+func streamIDs(t *testing.T, source DataSource, q Query, offset int64) []int64 {
+	t.Helper()
+	rows, err := source.Stream(context.Background(), q, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		ids = append(ids, rows.Row()["id"].(int64))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestSQLStreamOffset(t *testing.T) {
+	// rows are ordered by the key, or else by every column in table order
+	for name, tc := range map[string]struct{ table, schema string }{
+		"primary key":       {"t", "(name TEXT, id INTEGER PRIMARY KEY)"},
+		"no key":            {"t", "(id INTEGER, name TEXT)"},
+		"schema and key":    {"main.t", "(name TEXT, id INTEGER PRIMARY KEY)"},
+		"composite key":     {"t", "(name TEXT, id INTEGER, PRIMARY KEY (id, name))"},
+		"schema and no key": {"main.t", "(id INTEGER, name TEXT)"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			source := newTestSQLite(t, tc.table, tc.schema, 5, 2, 4, 1, 3)
+			if ids := streamIDs(t, source, Query{}, 0); len(ids) != 5 || ids[0] != 1 || ids[4] != 5 {
+				t.Fatalf("streamed %v, want 1 to 5", ids)
+			}
+			// a stream continued from an offset picks up where it stopped
+			ids := streamIDs(t, source, Query{Filters: []Filter{{Field: "id", Op: ">", Value: 1}}}, 2)
+			if len(ids) != 2 || ids[0] != 4 || ids[1] != 5 {
+				t.Fatalf("streamed %v from offset 2, want [4 5]", ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package datasource
+
+import (
+	"context"
+	"io"
+)
+
+// Rows iterates over the rows of a query as they are read, so that a data
+// set never has to fit in memory. The schema is known before the first row.
+// Like sql.Rows, call Next until it returns false, then check Err; Close
+// must always be called.
+type Rows interface {
+	Schema() []Column
+	Next() bool
+	Row() Row
+	Err() error
+	Close() error
+}
+
+// Select reads all the rows picked by q into memory.
+func Select(ctx context.Context, source DataSource, q Query) (Result, error) {
+	rows, err := source.Stream(ctx, q, 0)
+	if err != nil {
+		return Result{}, err
+	}
+	defer rows.Close()
+	result := Result{Schema: rows.Schema()}
+	for rows.Next() {
+		result.Rows = append(result.Rows, rows.Row())
+	}
+	return result, rows.Err()
+}
+
+// queryRows runs a query over raw rows in memory, for sources that cannot
+// run one themselves. next returns io.EOF after the last row.
+type queryRows struct {
+	ctx    context.Context
+	q      Query
+	schema []Column
+	next   func() (Row, error)
+	close  func() error
+	skip   int64
+	row    Row
+	err    error
+}
+
+func newQueryRows(ctx context.Context, q Query, schema []Column, offset int64, next func() (Row, error), close func() error) *queryRows {
+	return &queryRows{ctx: ctx, q: q, schema: q.project(schema), next: next, close: close, skip: offset}
+}
+
+func (r *queryRows) Schema() []Column {
+	return r.schema
+}
+
+func (r *queryRows) Next() bool {
+	for r.err == nil {
+		if r.err = r.ctx.Err(); r.err != nil {
+			return false
+		}
+		row, err := r.next()
+		if err == io.EOF {
+			return false
+		}
+		if err != nil {
+			r.err = err
+			return false
+		}
+		if !r.q.match(row) {
+			continue
+		}
+		if r.skip > 0 {
+			r.skip--
+			continue
+		}
+		r.row = r.q.projectRow(row)
+		return true
+	}
+	return false
+}
+
+func (r *queryRows) Row() Row {
+	return r.row
+}
+
+func (r *queryRows) Err() error {
+	return r.err
+}
+
+func (r *queryRows) Close() error {
+	if r.close == nil {
+		return nil
+	}
+	return r.close()
+}
+
+// sliceRows returns the rows one by one, for sources read whole.
+func sliceRows(rows []Row) func() (Row, error) {
+	return func() (Row, error) {
+		if len(rows) == 0 {
+			return nil, io.EOF
+		}
+		row := rows[0]
+		rows = rows[1:]
+		return row, nil
+	}
+}
+
+// Count returns the number of rows of a data set, an estimate where the
+// source keeps one, such as the table statistics of a database.
+func Count(ctx context.Context, source DataSource) (int64, error) {
+	if counter, ok := source.(interface {
+		Count(ctx context.Context) (int64, error)
+	}); ok {
+		return counter.Count(ctx)
+	}
+	rows, err := source.Stream(ctx, Query{}, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var n int64
+	for rows.Next() {
+		n++
+	}
+	return n, rows.Err()
+}
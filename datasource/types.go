@@ -0,0 +1,309 @@
+package datasource
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Column types of a schema. Values are released as native JSON values of
+// their type, with these encodings where JSON has none:
+//
+//	decimal    string holding the exact decimal, e.g. "12.30"
+//	timestamp  RFC 3339 string, read as UTC when the source has no zone
+//	date       "2006-01-02"
+//	time       "15:04:05"
+//	bytes      base64 string
+//
+// NULL is released as null whatever the type.
+const (
+	TypeInteger   = "integer"
+	TypeFloat     = "float"
+	TypeDecimal   = "decimal"
+	TypeBoolean   = "boolean"
+	TypeString    = "string"
+	TypeTimestamp = "timestamp"
+	TypeDate      = "date"
+	TypeTime      = "time"
+	TypeBytes     = "bytes"
+	TypeJSON      = "json"
+)
+
+// Column describes one column of a data set. SQLType is the type declared
+// in the database, empty for file and HTTP sources.
+type Column struct {
+	Name    string `json:"Name"`
+	Type    string `json:"Type"`
+	SQLType string `json:"SQLType,omitempty"`
+}
+
+// Result is a data set as released: the schema header of the selected
+// columns and the rows.
+type Result struct {
+	Schema []Column `json:"Schema"`
+	Rows   []Row    `json:"Rows"`
+}
+
+// project keeps the columns q selects, in the order it selects them.
+func (q Query) project(columns []Column) []Column {
+	if len(q.Columns) == 0 {
+		return columns
+	}
+	byName := make(map[string]Column, len(columns))
+	for _, column := range columns {
+		byName[column.Name] = column
+	}
+	projected := make([]Column, 0, len(q.Columns))
+	for _, name := range q.Columns {
+		if column, ok := byName[name]; ok {
+			projected = append(projected, column)
+		}
+	}
+	return projected
+}
+
+// sqlColumnTypes maps the type names drivers report to column types.
+// Names of more than one word, such as "DOUBLE PRECISION" or "TIMESTAMP WITH
+// TIME ZONE", are looked up by their first word.
+var sqlColumnTypes = map[string]string{
+	"BOOL":        TypeBoolean,
+	"BOOLEAN":     TypeBoolean,
+	"TINYINT":     TypeInteger,
+	"SMALLINT":    TypeInteger,
+	"MEDIUMINT":   TypeInteger,
+	"INT":         TypeInteger,
+	"INTEGER":     TypeInteger,
+	"BIGINT":      TypeInteger,
+	"INT2":        TypeInteger,
+	"INT4":        TypeInteger,
+	"INT8":        TypeInteger,
+	"SMALLSERIAL": TypeInteger,
+	"SERIAL":      TypeInteger,
+	"BIGSERIAL":   TypeInteger,
+	"DEC":         TypeDecimal,
+	"DECIMAL":     TypeDecimal,
+	"NUMERIC":     TypeDecimal,
+	"FLOAT":       TypeFloat,
+	"FLOAT4":      TypeFloat,
+	"FLOAT8":      TypeFloat,
+	"REAL":        TypeFloat,
+	"DOUBLE":      TypeFloat,
+	"TIMESTAMP":   TypeTimestamp,
+	"TIMESTAMPTZ": TypeTimestamp,
+	"DATETIME":    TypeTimestamp,
+	"DATE":        TypeDate,
+	"TIME":        TypeTime,
+	"TIMETZ":      TypeTime,
+	"BINARY":      TypeBytes,
+	"VARBINARY":   TypeBytes,
+	"TINYBLOB":    TypeBytes,
+	"BLOB":        TypeBytes,
+	"MEDIUMBLOB":  TypeBytes,
+	"LONGBLOB":    TypeBytes,
+	"BYTEA":       TypeBytes,
+	"JSON":        TypeJSON,
+	"JSONB":       TypeJSON,
+}
+
+// sqlColumnType maps a database type name to a column type. Lengths and
+// UNSIGNED are ignored; names not known, e.g. INTERVAL or POINT, are
+// released as strings.
+func sqlColumnType(databaseType string) string {
+	t := strings.ToUpper(strings.TrimSpace(databaseType))
+	if i := strings.IndexByte(t, '('); i >= 0 {
+		t = strings.TrimSpace(t[:i])
+	}
+	t = strings.TrimPrefix(t, "UNSIGNED ")
+	if first, _, found := strings.Cut(t, " "); found {
+		t = first
+	}
+	if typ, ok := sqlColumnTypes[t]; ok {
+		return typ
+	}
+	return TypeString
+}
+
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// convert brings a value scanned from a database into the released
+// encoding of typ. Values that do not parse as their type are released as
+// text rather than dropped.
+func convert(typ string, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	if b, ok := value.([]byte); ok {
+		if typ == TypeBytes {
+			return base64.StdEncoding.EncodeToString(b)
+		}
+		value = string(b)
+	}
+	if t, ok := value.(time.Time); ok {
+		switch typ {
+		case TypeDate:
+			return t.Format("2006-01-02")
+		case TypeTime:
+			return t.Format("15:04:05")
+		default:
+			return t.Format(time.RFC3339Nano)
+		}
+	}
+	text, ok := value.(string)
+	if !ok {
+		// native values of the driver
+		switch v := value.(type) {
+		case int64:
+			if typ == TypeBoolean {
+				return v != 0
+			}
+			if typ == TypeDecimal {
+				return strconv.FormatInt(v, 10)
+			}
+		case float64:
+			if typ == TypeDecimal {
+				return strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		}
+		return value
+	}
+
+	switch typ {
+	case TypeInteger:
+		if v, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return v
+		}
+	case TypeFloat:
+		if v, err := strconv.ParseFloat(text, 64); err == nil {
+			return v
+		}
+	case TypeBoolean:
+		switch strings.ToLower(text) {
+		case "1", "t", "true":
+			return true
+		case "0", "f", "false":
+			return false
+		}
+	case TypeTimestamp:
+		for _, layout := range timestampLayouts {
+			if t, err := time.Parse(layout, text); err == nil {
+				return t.Format(time.RFC3339Nano)
+			}
+		}
+	case TypeDate:
+		if len(text) >= 10 {
+			if t, err := time.Parse("2006-01-02", text[:10]); err == nil {
+				return t.Format("2006-01-02")
+			}
+		}
+	case TypeBytes:
+		return base64.StdEncoding.EncodeToString([]byte(text))
+	case TypeJSON:
+		if json.Valid([]byte(text)) {
+			return json.RawMessage(text)
+		}
+	}
+	return text
+}
+
+// InferColumns derives the schema of schemaless rows from their values, such
+// as results of publishers that do not send one.
+func InferColumns(rows []Row) []Column {
+	types := make(inference)
+	for _, row := range rows {
+		types.add(row)
+	}
+	return types.columns()
+}
+
+// inference collects the types of schemaless rows one row at a time. Values
+// of mixed types make a json column, integers mixed with floats a float one.
+type inference map[string]string
+
+func (types inference) add(row Row) {
+	for name, value := range row {
+		typ := valueType(value)
+		if typ == "" {
+			if _, seen := types[name]; !seen {
+				types[name] = ""
+			}
+			continue
+		}
+		switch types[name] {
+		case "", typ:
+			types[name] = typ
+		case TypeInteger:
+			if typ == TypeFloat {
+				types[name] = TypeFloat
+			} else {
+				types[name] = TypeJSON
+			}
+		case TypeFloat:
+			if typ != TypeInteger {
+				types[name] = TypeJSON
+			}
+		default:
+			types[name] = TypeJSON
+		}
+	}
+}
+
+// columns are sorted, since objects have no column order. Columns that were
+// only ever null are typed as text.
+func (types inference) columns() []Column {
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	columns := make([]Column, len(names))
+	for i, name := range names {
+		typ := types[name]
+		if typ == "" {
+			typ = TypeString
+		}
+		columns[i] = Column{Name: name, Type: typ}
+	}
+	return columns
+}
+
+// valueType is the column type of a decoded JSON value, "" for null.
+func valueType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case bool:
+		return TypeBoolean
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			return TypeFloat
+		}
+		return TypeInteger
+	case float64:
+		if v == float64(int64(v)) {
+			return TypeInteger
+		}
+		return TypeFloat
+	case string:
+		return TypeString
+	default:
+		return TypeJSON
+	}
+}
+
+// stringColumns types every column of an untyped source as text.
+func stringColumns(names []string) []Column {
+	columns := make([]Column, len(names))
+	for i, name := range names {
+		columns[i] = Column{Name: name, Type: TypeString}
+	}
+	return columns
+}
@@ -0,0 +1,36 @@
+package datasource
+
+import "testing"
+
+func TestSQLColumnType(t *testing.T) {
+	for databaseType, want := range map[string]string{
+		"INTEGER":                     TypeInteger,
+		"bigint":                      TypeInteger,
+		"BIGINT UNSIGNED":             TypeInteger,
+		"UNSIGNED INT":                TypeInteger,
+		"INT4":                        TypeInteger,
+		"DECIMAL(10,2)":               TypeDecimal,
+		"DOUBLE PRECISION":            TypeFloat,
+		"TIMESTAMP WITH TIME ZONE":    TypeTimestamp,
+		"TIMESTAMPTZ":                 TypeTimestamp,
+		"DATETIME":                    TypeTimestamp,
+		"DATE":                        TypeDate,
+		"TIME WITHOUT TIME ZONE":      TypeTime,
+		"BYTEA":                       TypeBytes,
+		"JSONB":                       TypeJSON,
+		"VARCHAR(255)":                TypeString,
+		"":                            TypeString,
+		"POINT":                       TypeString,
+		"INTERVAL":                    TypeString,
+		"INTERVAL DAY TO SECOND":      TypeString,
+		"CHARACTER VARYING":           TypeString,
+		"POLYGON":                     TypeString,
+		"INET":                        TypeString,
+		"_INT4":                       TypeString,
+		"TIMESTAMP(6) WITH TIME ZONE": TypeTimestamp,
+	} {
+		if got := sqlColumnType(databaseType); got != want {
+			t.Errorf("sqlColumnType(%q) = %s, want %s", databaseType, got, want)
+		}
+	}
+}
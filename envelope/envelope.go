@@ -0,0 +1,244 @@
+// Package envelope encrypts data for the holder of an identity key, bound to
+// the context it is delivered in.
+//
+// An envelope is an ephemeral ECDH key agreement with the recipient key, on
+// its curve or on X25519 for Ed25519 keys, HKDF-SHA256 over the shared
+// secret, and AES-256-GCM or ChaCha20-Poly1305 with the header of the
+// envelope and its Context as additional data:
+//
+//	magic "SCEV" | version | cipher | curve | key length (2 bytes) | ephemeral key | nonce | ciphertext
+//
+// It is sent as is or in base64, see Encode.
+package envelope
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"service-client/keys"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Version is the version of the envelopes Seal makes
+const Version = 1
+
+// Ciphers of envelopes
+const (
+	AES256GCM        = "aes-256-gcm"
+	ChaCha20Poly1305 = "chacha20-poly1305"
+)
+
+// Framings of envelopes
+const (
+	Binary = "binary"
+	Base64 = "base64"
+)
+
+var magic = []byte("SCEV")
+
+// kdfInfo separates the keys derived for envelopes
+const kdfInfo = "service-client envelope v1"
+
+var cipherIDs = map[string]byte{AES256GCM: 1, ChaCha20Poly1305: 2}
+
+var curveIDs = map[ecdh.Curve]byte{ecdh.P256(): 1, ecdh.P384(): 2, ecdh.X25519(): 3}
+
+// Context is what an envelope is delivered for. Opening it in any other
+// context fails.
+type Context struct {
+	QueryID   string
+	ServiceID string
+	// Recipient is the identity of the recipient, see ClientAccountID
+	Recipient string
+	// Digest is the hex SHA-256 of the plaintext
+	Digest string
+}
+
+// bytes encodes the context unambiguously, every field prefixed by its
+// length.
+func (c Context) bytes() []byte {
+	var b bytes.Buffer
+	for _, field := range []string{c.QueryID, c.ServiceID, c.Recipient, c.Digest} {
+		binary.Write(&b, binary.BigEndian, uint32(len(field)))
+		b.WriteString(field)
+	}
+	return b.Bytes()
+}
+
+// Digest is the Digest of the Context of plaintext.
+func Digest(plaintext []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(plaintext))
+}
+
+// ValidCipher reports whether Seal supports cipherName.
+func ValidCipher(cipherName string) bool {
+	_, ok := cipherIDs[cipherName]
+	return ok
+}
+
+// Seal encrypts plaintext for the holder of publicKey in context with
+// cipherName, AES256GCM if empty.
+func Seal(publicKey crypto.PublicKey, plaintext []byte, cipherName string, context Context) ([]byte, error) {
+	if cipherName == "" {
+		cipherName = AES256GCM
+	}
+	cipherID, ok := cipherIDs[cipherName]
+	if !ok {
+		return nil, fmt.Errorf("unsupported cipher %q", cipherName)
+	}
+	recipient, err := keys.ECDH(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := recipient.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(cipherName, secret, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralKey := ephemeral.PublicKey().Bytes()
+	header := append([]byte{}, magic...)
+	header = append(header, Version, cipherID, curveIDs[recipient.Curve()])
+	header = binary.BigEndian.AppendUint16(header, uint16(len(ephemeralKey)))
+	header = append(header, ephemeralKey...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header = append(header, nonce...)
+	return aead.Seal(header, nonce, plaintext, additionalData(header, context)), nil
+}
+
+// Open decrypts an envelope sealed for the public key of privateKey in
+// context.
+func Open(privateKey crypto.PrivateKey, envelope []byte, context Context) ([]byte, error) {
+	if !IsEnvelope(envelope) {
+		return nil, fmt.Errorf("not an envelope")
+	}
+	rest := envelope[len(magic):]
+	if len(rest) < 5 {
+		return nil, fmt.Errorf("envelope too short")
+	}
+	if rest[0] != Version {
+		return nil, fmt.Errorf("unsupported envelope version %d", rest[0])
+	}
+	cipherName := ""
+	for name, id := range cipherIDs {
+		if id == rest[1] {
+			cipherName = name
+		}
+	}
+	if cipherName == "" {
+		return nil, fmt.Errorf("unsupported envelope cipher %d", rest[1])
+	}
+	recipient, err := keys.ECDHPrivate(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if curveIDs[recipient.Curve()] != rest[2] {
+		return nil, fmt.Errorf("envelope is not for a %s key", recipient.Curve())
+	}
+	keyLength := int(binary.BigEndian.Uint16(rest[3:5]))
+	rest = rest[5:]
+	if len(rest) < keyLength {
+		return nil, fmt.Errorf("envelope too short")
+	}
+	ephemeral, err := recipient.Curve().NewPublicKey(rest[:keyLength])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %w", err)
+	}
+	rest = rest[keyLength:]
+	secret, err := recipient.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(cipherName, secret, ephemeral, recipient.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, fmt.Errorf("envelope too short")
+	}
+	nonce, ciphertext := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+	header := envelope[:len(envelope)-len(ciphertext)]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData(header, context))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open envelope: %w", err)
+	}
+	return plaintext, nil
+}
+
+// IsEnvelope tells envelopes in binary framing from data encrypted otherwise.
+func IsEnvelope(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+// Encode frames an envelope, in base64 if framing is Base64.
+func Encode(envelope []byte, framing string) ([]byte, error) {
+	switch framing {
+	case Binary:
+		return envelope, nil
+	case Base64, "":
+		return []byte(base64.StdEncoding.EncodeToString(envelope)), nil
+	default:
+		return nil, fmt.Errorf("unsupported framing %q", framing)
+	}
+}
+
+// Decode reverses Encode.
+func Decode(data []byte, framing string) ([]byte, error) {
+	switch framing {
+	case Binary:
+		return data, nil
+	case Base64, "":
+		envelope, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid envelope: %w", err)
+		}
+		return envelope, nil
+	default:
+		return nil, fmt.Errorf("unsupported framing %q", framing)
+	}
+}
+
+func additionalData(header []byte, context Context) []byte {
+	return append(append([]byte{}, header...), context.bytes()...)
+}
+
+// newAEAD keys cipherName from the shared secret of an envelope. Both keys
+// of the agreement are part of the derivation.
+func newAEAD(cipherName string, secret []byte, ephemeral *ecdh.PublicKey, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeral.Bytes()...), recipient.Bytes()...)
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(kdfInfo+" "+cipherName)), key); err != nil {
+		return nil, err
+	}
+	switch cipherName {
+	case ChaCha20Poly1305:
+		return chacha20poly1305.New(key)
+	default:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	}
+}
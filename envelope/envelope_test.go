@@ -0,0 +1,104 @@
+package envelope
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+)
+
+func recipients(t *testing.T) map[string]crypto.Signer {
+	t.Helper()
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]crypto.Signer{"P-256": p256, "P-384": p384, "Ed25519": ed}
+}
+
+func TestSealOpen(t *testing.T) {
+	plaintext := []byte(`[{"id":1}]`)
+	context := Context{QueryID: "Org1MSP-q", ServiceID: "Service-0", Recipient: "x509::CN=consumer::CN=ca", Digest: Digest(plaintext)}
+	for name, key := range recipients(t) {
+		for _, cipherName := range []string{AES256GCM, ChaCha20Poly1305} {
+			sealed, err := Seal(key.Public(), plaintext, cipherName, context)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsEnvelope(sealed) {
+				t.Fatalf("%s %s envelope not recognized", name, cipherName)
+			}
+			for _, framing := range []string{Binary, Base64} {
+				encoded, err := Encode(sealed, framing)
+				if err != nil {
+					t.Fatal(err)
+				}
+				decoded, err := Decode(encoded, framing)
+				if err != nil {
+					t.Fatal(err)
+				}
+				opened, err := Open(key, decoded, context)
+				if err != nil || string(opened) != string(plaintext) {
+					t.Fatalf("%s %s %s opened %q: %v", name, cipherName, framing, opened, err)
+				}
+			}
+		}
+	}
+}
+
+func TestOpenElsewhere(t *testing.T) {
+	plaintext := []byte(`[{"id":1}]`)
+	context := Context{QueryID: "Org1MSP-q", ServiceID: "Service-0", Recipient: "x509::CN=consumer::CN=ca", Digest: Digest(plaintext)}
+	for name, key := range recipients(t) {
+		sealed, err := Seal(key.Public(), plaintext, AES256GCM, context)
+		if err != nil {
+			t.Fatal(err)
+		}
+		others := map[string]Context{
+			"another query":     {QueryID: "Org1MSP-r", ServiceID: context.ServiceID, Recipient: context.Recipient, Digest: context.Digest},
+			"another service":   {QueryID: context.QueryID, ServiceID: "Service-1", Recipient: context.Recipient, Digest: context.Digest},
+			"another recipient": {QueryID: context.QueryID, ServiceID: context.ServiceID, Recipient: "x509::CN=other::CN=ca", Digest: context.Digest},
+			"another digest":    {QueryID: context.QueryID, ServiceID: context.ServiceID, Recipient: context.Recipient, Digest: Digest([]byte("[]"))},
+			// the fields are length prefixed, so moving a boundary changes the context
+			"shifted fields": {QueryID: context.QueryID + context.ServiceID[:1], ServiceID: context.ServiceID[1:], Recipient: context.Recipient, Digest: context.Digest},
+		}
+		for other, otherContext := range others {
+			if _, err := Open(key, sealed, otherContext); err == nil {
+				t.Errorf("%s envelope opened for %s", name, other)
+			}
+		}
+		if _, err := Open(recipients(t)[name], sealed, context); err == nil {
+			t.Errorf("%s envelope opened with another key", name)
+		}
+		for _, i := range []int{4, 5, len(sealed) - 1} {
+			altered := append([]byte(nil), sealed...)
+			altered[i] ^= 1
+			if _, err := Open(key, altered, context); err == nil {
+				t.Errorf("%s envelope altered at byte %d opened", name, i)
+			}
+		}
+	}
+}
+
+func TestUnsupportedCipher(t *testing.T) {
+	key := recipients(t)["P-256"]
+	if ValidCipher("rot13") {
+		t.Error("rot13 is valid")
+	}
+	if _, err := Seal(key.Public(), []byte("x"), "rot13", Context{}); err == nil {
+		t.Error("sealed with rot13")
+	}
+	if _, err := Encode([]byte("x"), "hex"); err == nil {
+		t.Error("encoded in hex")
+	}
+}
@@ -0,0 +1,197 @@
+package export
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+
+	"service-client/datasource"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/decimal128"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet"
+	"github.com/apache/arrow/go/v15/parquet/compress"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+)
+
+// batchSize is the number of rows per Arrow record batch and Parquet row group
+const batchSize = 4096
+
+// decimalType reads the precision and scale of a declared decimal type
+var decimalType = regexp.MustCompile(`\(\s*(\d+)\s*,\s*(\d+)\s*\)`)
+
+// arrowType maps a column to an Arrow type. Decimals keep their declared
+// precision when it fits Decimal128 and are text otherwise, as are json
+// columns.
+func arrowType(column datasource.Column) arrow.DataType {
+	switch column.Type {
+	case datasource.TypeInteger:
+		return arrow.PrimitiveTypes.Int64
+	case datasource.TypeFloat:
+		return arrow.PrimitiveTypes.Float64
+	case datasource.TypeBoolean:
+		return arrow.FixedWidthTypes.Boolean
+	case datasource.TypeDecimal:
+		if m := decimalType.FindStringSubmatch(column.SQLType); m != nil {
+			precision, _ := strconv.Atoi(m[1])
+			scale, _ := strconv.Atoi(m[2])
+			if precision >= 1 && precision <= 38 && scale <= precision {
+				return &arrow.Decimal128Type{Precision: int32(precision), Scale: int32(scale)}
+			}
+		}
+		return arrow.BinaryTypes.String
+	case datasource.TypeTimestamp:
+		return &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}
+	case datasource.TypeDate:
+		return arrow.FixedWidthTypes.Date32
+	case datasource.TypeTime:
+		return arrow.FixedWidthTypes.Time64us
+	case datasource.TypeBytes:
+		return arrow.BinaryTypes.Binary
+	default:
+		return arrow.BinaryTypes.String
+	}
+}
+
+func arrowSchema(schema []datasource.Column) *arrow.Schema {
+	fields := make([]arrow.Field, len(schema))
+	for i, column := range schema {
+		fields[i] = arrow.Field{Name: column.Name, Type: arrowType(column), Nullable: true}
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+// appendValue appends one decoded JSON value to the builder of its column.
+func appendValue(builder array.Builder, value interface{}) error {
+	if value == nil {
+		builder.AppendNull()
+		return nil
+	}
+	text := fmt.Sprint(value)
+	switch b := builder.(type) {
+	case *array.Int64Builder:
+		v, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", text)
+		}
+		b.Append(v)
+	case *array.Float64Builder:
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", text)
+		}
+		b.Append(v)
+	case *array.BooleanBuilder:
+		v, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("%q is not a boolean", text)
+		}
+		b.Append(v)
+	case *array.Decimal128Builder:
+		t := b.Type().(*arrow.Decimal128Type)
+		v, err := decimal128.FromString(text, t.Precision, t.Scale)
+		if err != nil {
+			return fmt.Errorf("%q is not a decimal(%d,%d)", text, t.Precision, t.Scale)
+		}
+		b.Append(v)
+	case *array.TimestampBuilder:
+		t, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return fmt.Errorf("%q is not a timestamp", text)
+		}
+		b.Append(arrow.Timestamp(t.UnixMicro()))
+	case *array.Date32Builder:
+		t, err := time.Parse("2006-01-02", text)
+		if err != nil {
+			return fmt.Errorf("%q is not a date", text)
+		}
+		b.Append(arrow.Date32FromTime(t))
+	case *array.Time64Builder:
+		v, err := arrow.Time64FromString(text, arrow.Microsecond)
+		if err != nil {
+			return fmt.Errorf("%q is not a time", text)
+		}
+		b.Append(v)
+	case *array.BinaryBuilder:
+		v, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return fmt.Errorf("%q is not base64", text)
+		}
+		b.Append(v)
+	case *array.StringBuilder:
+		switch value.(type) {
+		case string, json.Number, bool:
+			b.Append(text)
+		default:
+			data, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			b.Append(string(data))
+		}
+	default:
+		return fmt.Errorf("unsupported column type %s", builder.Type())
+	}
+	return nil
+}
+
+// batches reads next into record batches of batchSize rows and hands each
+// to write.
+func batches(schema []datasource.Column, next Reader, write func(arrow.Record) error) error {
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, arrowSchema(schema))
+	defer builder.Release()
+	rows := 0
+	flush := func() error {
+		record := builder.NewRecord()
+		defer record.Release()
+		rows = 0
+		return write(record)
+	}
+	err := each(next, func(n int, row datasource.Row) error {
+		for i, column := range schema {
+			if err := appendValue(builder.Field(i), row[column.Name]); err != nil {
+				return fmt.Errorf("row %d, column %s: %w", n, column.Name, err)
+			}
+		}
+		if rows++; rows == batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if rows > 0 {
+		return flush()
+	}
+	return nil
+}
+
+func writeArrow(w io.Writer, schema []datasource.Column, next Reader) error {
+	writer := ipc.NewWriter(w, ipc.WithSchema(arrowSchema(schema)))
+	if err := batches(schema, next, writer.Write); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+func writeParquet(w io.Writer, schema []datasource.Column, next Reader) error {
+	props := parquet.NewWriterProperties(parquet.WithCompression(compress.Codecs.Snappy))
+	writer, err := pqarrow.NewFileWriter(arrowSchema(schema), w, props, pqarrow.NewArrowWriterProperties(pqarrow.WithStoreSchema()))
+	if err != nil {
+		return err
+	}
+	if err := batches(schema, next, writer.Write); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,160 @@
+// Package export writes fetched data sets in the formats analysts load them
+// with. Every format is written row by row from a reader, typed by the
+// schema the publisher released with the data.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"service-client/datasource"
+)
+
+// Formats
+const (
+	FormatJSON    = "json"    // a JSON array of objects
+	FormatNDJSON  = "ndjson"  // one JSON object per line
+	FormatCSV     = "csv"     // a header of column names, nulls as empty fields
+	FormatArrow   = "arrow"   // Apache Arrow IPC stream
+	FormatParquet = "parquet" // Apache Parquet file
+)
+
+type format struct {
+	contentType string
+	extension   string
+	write       func(w io.Writer, schema []datasource.Column, next Reader) error
+}
+
+var formats = map[string]format{
+	FormatJSON:    {"application/json", ".json", writeJSON},
+	FormatNDJSON:  {"application/x-ndjson", ".ndjson", writeNDJSON},
+	FormatCSV:     {"text/csv; charset=utf-8", ".csv", writeCSV},
+	FormatArrow:   {"application/vnd.apache.arrow.stream", ".arrows", writeArrow},
+	FormatParquet: {"application/vnd.apache.parquet", ".parquet", writeParquet},
+}
+
+// Reader returns the rows of a data set one by one, and io.EOF after the
+// last. Values are as decoded from JSON with numbers kept as json.Number.
+type Reader func() (datasource.Row, error)
+
+// Check reports whether format is known.
+func Check(name string) error {
+	if _, ok := formats[name]; !ok {
+		return fmt.Errorf("unknown format %q, expected one of %s, %s, %s, %s or %s", name, FormatJSON, FormatNDJSON, FormatCSV, FormatArrow, FormatParquet)
+	}
+	return nil
+}
+
+// ContentType is the media type of a format.
+func ContentType(name string) string {
+	return formats[name].contentType
+}
+
+// Extension is the file extension of a format, with its dot.
+func Extension(name string) string {
+	return formats[name].extension
+}
+
+// Write writes the rows of next to w in format. Tabular formats take their
+// columns in the order of schema; a value that does not fit the type of its
+// column is an error.
+func Write(w io.Writer, name string, schema []datasource.Column, next Reader) error {
+	if err := Check(name); err != nil {
+		return err
+	}
+	return formats[name].write(w, schema, next)
+}
+
+// each calls fn for every row of next, counting rows from 1.
+func each(next Reader, fn func(n int, row datasource.Row) error) error {
+	for n := 1; ; n++ {
+		row, err := next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(n, row); err != nil {
+			return err
+		}
+	}
+}
+
+func writeJSON(w io.Writer, schema []datasource.Column, next Reader) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	err := each(next, func(n int, row datasource.Row) error {
+		data, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if n > 1 {
+			data = append([]byte(","), data...)
+		}
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
+
+func writeNDJSON(w io.Writer, schema []datasource.Column, next Reader) error {
+	encoder := json.NewEncoder(w)
+	return each(next, func(_ int, row datasource.Row) error {
+		return encoder.Encode(row)
+	})
+}
+
+func writeCSV(w io.Writer, schema []datasource.Column, next Reader) error {
+	writer := csv.NewWriter(w)
+	header := make([]string, len(schema))
+	for i, column := range schema {
+		header[i] = column.Name
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, len(schema))
+	err := each(next, func(n int, row datasource.Row) error {
+		for i, column := range schema {
+			text, err := csvField(row[column.Name])
+			if err != nil {
+				return fmt.Errorf("row %d, column %s: %w", n, column.Name, err)
+			}
+			record[i] = text
+		}
+		return writer.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func csvField(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		if v {
+			return "true", nil
+		}
+		return "false", nil
+	default:
+		data, err := json.Marshal(v)
+		return strings.TrimSpace(string(data)), err
+	}
+}
@@ -0,0 +1,231 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"service-client/datasource"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/decimal128"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet/file"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+)
+
+var testSchema = []datasource.Column{
+	{Name: "id", Type: datasource.TypeInteger},
+	{Name: "score", Type: datasource.TypeFloat},
+	{Name: "price", Type: datasource.TypeDecimal, SQLType: "DECIMAL(10,2)"},
+	{Name: "total", Type: datasource.TypeDecimal, SQLType: "NUMERIC"},
+	{Name: "active", Type: datasource.TypeBoolean},
+	{Name: "name", Type: datasource.TypeString},
+	{Name: "created", Type: datasource.TypeTimestamp},
+	{Name: "day", Type: datasource.TypeDate},
+	{Name: "at", Type: datasource.TypeTime},
+	{Name: "blob", Type: datasource.TypeBytes},
+	{Name: "tags", Type: datasource.TypeJSON},
+}
+
+// testRows are as a fetched data set is read back: numbers as json.Number
+const testRows = `{"id": 9007199254740993, "score": 1.5, "price": "12.30", "total": "1.5", "active": true, "name": "a, \"b\"", "created": "2024-03-01T12:30:00.000001Z", "day": "2024-03-01", "at": "12:30:00", "blob": "AAE=", "tags": ["x", 1]}
+{"id": 2, "score": null, "price": null, "total": null, "active": null, "name": null, "created": null, "day": null, "at": null, "blob": null, "tags": null}
+`
+
+func testReader(t *testing.T, rows string) Reader {
+	t.Helper()
+	scanner := bufio.NewScanner(strings.NewReader(rows))
+	return func() (datasource.Row, error) {
+		if !scanner.Scan() {
+			return nil, io.EOF
+		}
+		var row datasource.Row
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.UseNumber()
+		if err := decoder.Decode(&row); err != nil {
+			t.Fatal(err)
+		}
+		return row, nil
+	}
+}
+
+func write(t *testing.T, format string) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	if err := Write(&out, format, testSchema, testReader(t, testRows)); err != nil {
+		t.Fatalf("%s: %v", format, err)
+	}
+	return out.Bytes()
+}
+
+func TestWriteJSON(t *testing.T) {
+	var rows []map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(write(t, FormatJSON)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0]["id"] != json.Number("9007199254740993") || rows[1]["name"] != nil {
+		t.Fatalf("JSON read back as %v", rows)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(write(t, FormatNDJSON))), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("NDJSON has %d lines, want 2", len(lines))
+	}
+	var row map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &row); err != nil || row["price"] != "12.30" || row["active"] != true {
+		t.Fatalf("NDJSON line read back as %v, %v", row, err)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	records, err := csv.NewReader(bytes.NewReader(write(t, FormatCSV))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"id", "score", "price", "total", "active", "name", "created", "day", "at", "blob", "tags"},
+		{"9007199254740993", "1.5", "12.30", "1.5", "true", `a, "b"`, "2024-03-01T12:30:00.000001Z", "2024-03-01", "12:30:00", "AAE=", `["x",1]`},
+		{"2", "", "", "", "", "", "", "", "", "", ""},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("CSV read back as\n%q\nwant\n%q", records, want)
+	}
+}
+
+// checkTable checks the types and values of a data set read back from Arrow
+// or Parquet.
+func checkTable(t *testing.T, schema *arrow.Schema, columns []arrow.Array) {
+	t.Helper()
+	wantTypes := []arrow.DataType{
+		arrow.PrimitiveTypes.Int64,
+		arrow.PrimitiveTypes.Float64,
+		&arrow.Decimal128Type{Precision: 10, Scale: 2},
+		arrow.BinaryTypes.String,
+		arrow.FixedWidthTypes.Boolean,
+		arrow.BinaryTypes.String,
+		&arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"},
+		arrow.FixedWidthTypes.Date32,
+		arrow.FixedWidthTypes.Time64us,
+		arrow.BinaryTypes.Binary,
+		arrow.BinaryTypes.String,
+	}
+	for i, field := range schema.Fields() {
+		if field.Name != testSchema[i].Name || !arrow.TypeEqual(field.Type, wantTypes[i]) {
+			t.Errorf("column %d is %s %s, want %s %s", i, field.Name, field.Type, testSchema[i].Name, wantTypes[i])
+		}
+	}
+	if len(columns) != len(testSchema) || columns[0].Len() != 2 {
+		t.Fatalf("read back %d columns", len(columns))
+	}
+	for i, column := range columns[1:] {
+		if !column.IsNull(1) {
+			t.Errorf("null of column %s read back as %v", testSchema[i+1].Name, column.GetOneForMarshal(1))
+		}
+	}
+	got := []interface{}{
+		columns[0].(*array.Int64).Value(0),
+		columns[1].(*array.Float64).Value(0),
+		columns[2].(*array.Decimal128).Value(0),
+		columns[3].(*array.String).Value(0),
+		columns[4].(*array.Boolean).Value(0),
+		columns[5].(*array.String).Value(0),
+		columns[6].(*array.Timestamp).Value(0),
+		columns[7].(*array.Date32).Value(0).FormattedString(),
+		columns[8].(*array.Time64).Value(0).FormattedString(arrow.Microsecond),
+		columns[9].(*array.Binary).Value(0),
+		columns[10].(*array.String).Value(0),
+	}
+	want := []interface{}{
+		int64(9007199254740993), 1.5, decimal128.FromI64(1230), "1.5", true, `a, "b"`,
+		arrow.Timestamp(1709296200000001), "2024-03-01", "12:30:00.000000", []byte{0, 1}, `["x",1]`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("read back\n%#v\nwant\n%#v", got, want)
+	}
+}
+
+func TestWriteArrow(t *testing.T) {
+	reader, err := ipc.NewReader(bytes.NewReader(write(t, FormatArrow)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Release()
+	if !reader.Next() {
+		t.Fatalf("no record batch: %v", reader.Err())
+	}
+	record := reader.Record()
+	checkTable(t, reader.Schema(), record.Columns())
+	if reader.Next() {
+		t.Fatal("more than one record batch")
+	}
+}
+
+func TestWriteParquet(t *testing.T) {
+	parquetFile, err := file.NewParquetReader(bytes.NewReader(write(t, FormatParquet)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parquetFile.Close()
+	reader, err := pqarrow.NewFileReader(parquetFile, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := reader.ReadTable(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Release()
+	columns := make([]arrow.Array, table.NumCols())
+	for i := range columns {
+		chunks := table.Column(i).Data().Chunks()
+		if len(chunks) != 1 {
+			t.Fatalf("column %d has %d chunks", i, len(chunks))
+		}
+		columns[i] = chunks[0]
+	}
+	checkTable(t, table.Schema(), columns)
+}
+
+func TestWriteMismatch(t *testing.T) {
+	for _, format := range []string{FormatCSV, FormatArrow, FormatParquet} {
+		if Check(format) != nil || ContentType(format) == "" || !strings.HasPrefix(Extension(format), ".") {
+			t.Errorf("format %s is not described", format)
+		}
+	}
+	if err := Check("xlsx"); err == nil {
+		t.Error("unknown format accepted")
+	}
+	if err := Write(io.Discard, "xlsx", testSchema, testReader(t, testRows)); err == nil {
+		t.Error("wrote an unknown format")
+	}
+
+	// a value that does not fit its column fails the export, naming it
+	for column, value := range map[string]string{
+		"id":      `"abc"`,
+		"score":   `"high"`,
+		"price":   `"12,30"`,
+		"active":  `"yes"`,
+		"created": `"yesterday"`,
+		"day":     `"2024-13-01"`,
+		"blob":    `"not base64!"`,
+	} {
+		row := `{"` + column + `": ` + value + "}\n"
+		for _, format := range []string{FormatArrow, FormatParquet} {
+			err := Write(io.Discard, format, testSchema, testReader(t, row))
+			if err == nil || !strings.Contains(err.Error(), "row 1, column "+column) {
+				t.Errorf("%s with %s %s: %v", format, column, value, err)
+			}
+		}
+	}
+}
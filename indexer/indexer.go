@@ -0,0 +1,321 @@
+package indexer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"service-client/chaincodeservice"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Indexer mirrors the tokens of the service contract and the records of the
+// query contract into a local SQLite database, fed by the chaincode events of
+// both contracts, so that listings and balances can be answered without a
+// gateway round trip per token.
+type Indexer struct {
+	db              *sql.DB
+	queryContract   *chaincodeservice.QueryContract
+	serviceContract *chaincodeservice.ServiceContract
+	source          chaincodeservice.EventSource
+
+	// lifecycle serializes Start, Rebuild and Close; mu guards the fields
+	// read by Status
+	lifecycle  sync.Mutex
+	cancel     context.CancelFunc
+	done       sync.WaitGroup
+	mu         sync.Mutex
+	subs       []*chaincodeservice.EventSubscription
+	rebuilding bool
+	ownerMSPID string
+}
+
+// Status tells API clients how far they can trust answers from the index.
+type Status struct {
+	Stale       bool   `json:"stale"`
+	Rebuilding  bool   `json:"rebuilding"`
+	LastBlock   uint64 `json:"lastBlock"`
+	LastEventAt string `json:"lastEventAt"`
+}
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS tokens (
+		token_id     TEXT PRIMARY KEY,
+		owner        TEXT NOT NULL,
+		token_uri    TEXT NOT NULL,
+		block_number INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS tokens_owner ON tokens (owner, token_uri)`,
+	`CREATE TABLE IF NOT EXISTS queries (
+		query_id         TEXT PRIMARY KEY,
+		certificate      TEXT NOT NULL,
+		data_digest      TEXT NOT NULL,
+		data_rows        INTEGER NOT NULL,
+		initiator_id     TEXT NOT NULL,
+		initiator_msp_id TEXT NOT NULL,
+		legitimacy       TEXT NOT NULL,
+		queried_table    TEXT NOT NULL,
+		query_digest     TEXT NOT NULL,
+		service_id       TEXT NOT NULL,
+		timestamp        INTEGER NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS queries_service ON queries (service_id, initiator_id, timestamp)`,
+	`CREATE INDEX IF NOT EXISTS queries_initiator ON queries (initiator_id, timestamp)`,
+	`CREATE TABLE IF NOT EXISTS checkpoints (
+		chaincode      TEXT PRIMARY KEY,
+		block_number   INTEGER NOT NULL,
+		transaction_id TEXT NOT NULL,
+		updated_at     INTEGER NOT NULL
+	)`,
+}
+
+// Open opens or creates the index database at path.
+func Open(path string, queryContract *chaincodeservice.QueryContract, serviceContract *chaincodeservice.ServiceContract, source chaincodeservice.EventSource) (*Indexer, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index %s: %w", path, err)
+	}
+	// a single connection serializes writers, which SQLite needs anyway
+	db.SetMaxOpenConns(1)
+	for _, statement := range schema {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create index schema: %w", err)
+		}
+	}
+	return &Indexer{
+		db:              db,
+		queryContract:   queryContract,
+		serviceContract: serviceContract,
+		source:          source,
+	}, nil
+}
+
+// Start follows both contracts in the background. A fresh index replays the
+// chain from the genesis block; an existing one resumes from its checkpoints.
+func (ix *Indexer) Start() error {
+	ix.lifecycle.Lock()
+	defer ix.lifecycle.Unlock()
+	return ix.start()
+}
+
+func (ix *Indexer) start() error {
+	fresh, err := ix.isEmpty()
+	if err != nil {
+		return err
+	}
+	if fresh {
+		// ds_query may predate its events, so seed the records directly
+		if err := ix.seedQueries(); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ix.cancel = cancel
+	subs := []*chaincodeservice.EventSubscription{ix.subscribeQueries(), ix.subscribeTokens()}
+	ix.mu.Lock()
+	ix.subs = subs
+	ix.mu.Unlock()
+	for _, sub := range subs {
+		ix.done.Add(1)
+		go func(sub *chaincodeservice.EventSubscription) {
+			defer ix.done.Done()
+			if err := sub.Run(ctx); err != nil && err != context.Canceled {
+				log.Printf("index subscription for %s stopped: %s\n", sub.ChaincodeName, err)
+			}
+		}(sub)
+	}
+	return nil
+}
+
+func (ix *Indexer) stop() {
+	if ix.cancel != nil {
+		ix.cancel()
+		ix.done.Wait()
+		ix.cancel = nil
+	}
+	ix.mu.Lock()
+	ix.subs = nil
+	ix.mu.Unlock()
+}
+
+func (ix *Indexer) setRebuilding(rebuilding bool) {
+	ix.mu.Lock()
+	ix.rebuilding = rebuilding
+	ix.mu.Unlock()
+}
+
+// Rebuild drops the index and replays both contracts from the genesis block.
+func (ix *Indexer) Rebuild() error {
+	ix.lifecycle.Lock()
+	defer ix.lifecycle.Unlock()
+
+	ix.setRebuilding(true)
+	defer ix.setRebuilding(false)
+
+	ix.stop()
+	for _, table := range []string{"tokens", "queries", "checkpoints"} {
+		if _, err := ix.db.Exec("DELETE FROM " + table); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", table, err)
+		}
+	}
+	log.Println("Index cleared, replaying from genesis")
+	return ix.start()
+}
+
+func (ix *Indexer) Close() error {
+	ix.lifecycle.Lock()
+	ix.stop()
+	ix.lifecycle.Unlock()
+	return ix.db.Close()
+}
+
+// Status reports the index as stale while it is rebuilding or while any of
+// its event streams is disconnected.
+func (ix *Indexer) Status() Status {
+	ix.mu.Lock()
+	status := Status{Rebuilding: ix.rebuilding, Stale: ix.rebuilding || len(ix.subs) == 0}
+	for _, sub := range ix.subs {
+		if !sub.Connected() {
+			status.Stale = true
+		}
+	}
+	ix.mu.Unlock()
+
+	var lastBlock sql.NullInt64
+	var updatedAt sql.NullInt64
+	row := ix.db.QueryRow("SELECT MAX(block_number), MAX(updated_at) FROM checkpoints")
+	if err := row.Scan(&lastBlock, &updatedAt); err == nil && lastBlock.Valid {
+		status.LastBlock = uint64(lastBlock.Int64)
+		status.LastEventAt = time.Unix(updatedAt.Int64, 0).Format("2006-01-02 15:04:05")
+	}
+	return status
+}
+
+func (ix *Indexer) isEmpty() (bool, error) {
+	var count int
+	if err := ix.db.QueryRow("SELECT COUNT(*) FROM checkpoints").Scan(&count); err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
+// ======= events =======
+
+func (ix *Indexer) subscribeTokens() *chaincodeservice.EventSubscription {
+	sub := ix.serviceContract.Subscribe(ix.source, "")
+	sub.Checkpointer = ix.checkpointer(ix.serviceContract.ChaincodeName)
+	sub.FromGenesis = true
+	chaincodeservice.HandleEvent(sub, "Transfer", ix.onTransfer)
+	return sub
+}
+
+func (ix *Indexer) subscribeQueries() *chaincodeservice.EventSubscription {
+	sub := ix.queryContract.Subscribe(ix.source, "")
+	sub.Checkpointer = ix.checkpointer(ix.queryContract.ChaincodeName)
+	sub.FromGenesis = true
+	chaincodeservice.HandleEvent(sub, "CreateQuery", ix.onCreateQuery)
+	return sub
+}
+
+func (ix *Indexer) onTransfer(e *client.ChaincodeEvent, transfer chaincodeservice.TransferEvent) {
+	var err error
+	switch {
+	case transfer.To == "0x0":
+		_, err = ix.db.Exec("DELETE FROM tokens WHERE token_id = ?", transfer.TokenID)
+	case transfer.From == "0x0":
+		// the event does not carry the URI. A token burned since cannot be
+		// read any more, but its burn event follows anyway.
+		tokenURI, uriErr := ix.serviceContract.TokenURI(transfer.TokenID)
+		if uriErr != nil {
+			log.Printf("index: failed to read URI of token %s: %s\n", transfer.TokenID, uriErr)
+		}
+		_, err = ix.db.Exec(`INSERT INTO tokens (token_id, owner, token_uri, block_number) VALUES (?, ?, ?, ?)
+			ON CONFLICT (token_id) DO UPDATE SET owner = excluded.owner, token_uri = excluded.token_uri, block_number = excluded.block_number`,
+			transfer.TokenID, normalizeAccount(transfer.To), tokenURI, e.BlockNumber)
+	default:
+		_, err = ix.db.Exec("UPDATE tokens SET owner = ?, block_number = ? WHERE token_id = ?", normalizeAccount(transfer.To), e.BlockNumber, transfer.TokenID)
+	}
+	if err != nil {
+		log.Printf("index: failed to apply transfer of token %s: %s\n", transfer.TokenID, err)
+	}
+}
+
+func (ix *Indexer) onCreateQuery(_ *client.ChaincodeEvent, query chaincodeservice.Query) {
+	if err := ix.putQuery(query); err != nil {
+		log.Printf("index: failed to store query %s: %s\n", query.QueryID, err)
+	}
+}
+
+func (ix *Indexer) putQuery(q chaincodeservice.Query) error {
+	_, err := ix.db.Exec(`INSERT OR REPLACE INTO queries (query_id, certificate, data_digest, data_rows, initiator_id, initiator_msp_id, legitimacy, queried_table, query_digest, service_id, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		q.QueryID, q.Certificate, q.DataDigest, q.DataRows, q.InitiatorID, q.InitiatorMSPID, q.Legitimacy, q.QueriedTable, q.QueryDigest, q.ServiceID, q.Timestamp)
+	return err
+}
+
+func (ix *Indexer) seedQueries() error {
+	queries, err := ix.queryContract.GetAllQuerys()
+	if err != nil {
+		return fmt.Errorf("failed to seed query index: %w", err)
+	}
+	for _, query := range queries {
+		if err := ix.putQuery(query); err != nil {
+			return fmt.Errorf("failed to seed query %s: %w", query.QueryID, err)
+		}
+	}
+	log.Printf("Index seeded with %d queries\n", len(queries))
+	return nil
+}
+
+// ds_service compares account IDs with whitespace removed
+func normalizeAccount(account string) string {
+	return strings.ReplaceAll(account, " ", "")
+}
+
+// ======= checkpoints =======
+
+// checkpointer keeps the stream position in the index itself, so that it can
+// never run ahead of or behind the mirrored data by more than one event.
+type checkpointer struct {
+	db        *sql.DB
+	chaincode string
+}
+
+func (ix *Indexer) checkpointer(chaincode string) *checkpointer {
+	return &checkpointer{db: ix.db, chaincode: chaincode}
+}
+
+func (c *checkpointer) read() (uint64, string) {
+	var blockNumber uint64
+	var transactionID string
+	err := c.db.QueryRow("SELECT block_number, transaction_id FROM checkpoints WHERE chaincode = ?", c.chaincode).Scan(&blockNumber, &transactionID)
+	if err != nil {
+		return 0, ""
+	}
+	return blockNumber, transactionID
+}
+
+func (c *checkpointer) BlockNumber() uint64 {
+	blockNumber, _ := c.read()
+	return blockNumber
+}
+
+func (c *checkpointer) TransactionID() string {
+	_, transactionID := c.read()
+	return transactionID
+}
+
+func (c *checkpointer) CheckpointChaincodeEvent(event *client.ChaincodeEvent) error {
+	_, err := c.db.Exec(`INSERT INTO checkpoints (chaincode, block_number, transaction_id, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (chaincode) DO UPDATE SET block_number = excluded.block_number, transaction_id = excluded.transaction_id, updated_at = excluded.updated_at`,
+		c.chaincode, event.BlockNumber, event.TransactionID, time.Now().Unix())
+	return err
+}
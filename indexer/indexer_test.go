@@ -0,0 +1,356 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"service-client/chaincodeservice"
+)
+
+// testLedger is an emulated ledger with a publisher, who owns ds_service, and
+// a consumer.
+type testLedger struct {
+	emulator  *chaincodeservice.Emulator
+	publisher *chaincodeservice.ServiceContract
+	consumer  *chaincodeservice.ServiceContract
+	queries   *chaincodeservice.QueryContract
+}
+
+func newTestLedger(t *testing.T) *testLedger {
+	t.Helper()
+	e := chaincodeservice.NewEmulator()
+	contracts := make([]*chaincodeservice.ServiceContract, 0, 2)
+	var queries *chaincodeservice.QueryContract
+	for _, org := range [][3]string{{"Org1", "Org1MSP", "publisher"}, {"Org2", "Org2MSP", "consumer"}} {
+		orgSetup, ledger, err := e.Enroll(org[0], org[1], org[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		contracts = append(contracts, &chaincodeservice.ServiceContract{OrgSetup: orgSetup, Ledger: ledger, ChaincodeName: "ds_service", ChannelID: "ch"})
+		if queries == nil {
+			queries = &chaincodeservice.QueryContract{OrgSetup: orgSetup, Ledger: ledger, ChaincodeName: "ds_query", ChannelID: "ch"}
+		}
+	}
+	if err := contracts[0].Initialize("service", "SVC", "Org1MSP"); err != nil {
+		t.Fatal(err)
+	}
+	return &testLedger{emulator: e, publisher: contracts[0], consumer: contracts[1], queries: queries}
+}
+
+// open opens the index at path for the publisher without starting it.
+func (l *testLedger) open(t *testing.T, path string) *Indexer {
+	t.Helper()
+	ix, err := Open(path, l.queries, l.publisher, l.emulator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ix.Close() })
+	return ix
+}
+
+// start opens and starts the index at path.
+func (l *testLedger) start(t *testing.T, path string) *Indexer {
+	t.Helper()
+	ix := l.open(t, path)
+	if err := ix.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return ix
+}
+
+// settle waits until ix mirrors the balances and queries on the ledger.
+func (l *testLedger) settle(t *testing.T, ix *Indexer) {
+	t.Helper()
+	caughtUp := func() bool {
+		queries, err := l.queries.GetAllQuerys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := ix.FindQueries(chaincodeservice.QueryFilter{}, len(queries)+1, "")
+		if err != nil || len(page.Queries) != len(queries) {
+			return false
+		}
+		for _, cc := range []*chaincodeservice.ServiceContract{l.publisher, l.consumer} {
+			onLedger, err := l.publisher.BalanceOf(cc.OrgSetup.Identity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if indexed, err := ix.BalanceOf(cc.OrgSetup.Identity); err != nil || indexed != onLedger {
+				return false
+			}
+		}
+		return !ix.Status().Stale
+	}
+	for deadline := time.Now().Add(5 * time.Second); !caughtUp(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("index did not catch up with the ledger")
+		}
+	}
+}
+
+func (l *testLedger) createQuery(t *testing.T, initiatorID string, legitimacy string) string {
+	t.Helper()
+	queryID, err := l.queries.CreateQuery("", "", 1, initiatorID, "Org2MSP", legitimacy, "t", "", "Service-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queryID
+}
+
+func TestReplayFromGenesis(t *testing.T) {
+	l := newTestLedger(t)
+	serviceID, err := l.publisher.NewService("http://publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenID, err := l.publisher.ApproveServiceFor(serviceID, l.consumer.OrgSetup.Identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryID := l.createQuery(t, "alice", chaincodeservice.LegitimacyGranted)
+
+	// everything above happened before the index was created
+	ix := l.start(t, filepath.Join(t.TempDir(), "index.db"))
+	l.settle(t, ix)
+	if services, err := ix.GetServices(); err != nil || len(services) != 1 || !strings.HasPrefix(services[0], serviceID+"|") {
+		t.Fatalf("indexed services %v, %v", services, err)
+	}
+	if publisher, err := ix.PublisherOf(serviceID); err != nil || publisher != normalizeAccount(l.publisher.OrgSetup.Identity) {
+		t.Fatalf("indexed publisher %s, %v", publisher, err)
+	}
+	if owner, err := ix.OwnerOf(tokenID); err != nil || owner != normalizeAccount(l.consumer.OrgSetup.Identity) {
+		t.Fatalf("indexed owner of %s: %s, %v", tokenID, owner, err)
+	}
+	page, err := ix.FindQueries(chaincodeservice.QueryFilter{}, 10, "")
+	if err != nil || len(page.Queries) != 1 || page.Queries[0].QueryID != queryID {
+		t.Fatalf("indexed queries %+v, %v", page, err)
+	}
+	if status := ix.Status(); status.LastBlock == 0 || status.LastEventAt == "" {
+		t.Fatalf("status after replay: %+v", status)
+	}
+}
+
+func TestCheckpointResumeAndRebuild(t *testing.T) {
+	l := newTestLedger(t)
+	path := filepath.Join(t.TempDir(), "index.db")
+	ix := l.start(t, path)
+	serviceID, err := l.publisher.NewService("http://publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.settle(t, ix)
+	lastBlock := ix.Status().LastBlock
+	if err := ix.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a token the index lost while it was closed is not replayed, as the
+	// index resumes after its checkpoint rather than from genesis
+	ix = l.open(t, path)
+	if _, err := ix.db.Exec("DELETE FROM tokens"); err != nil {
+		t.Fatal(err)
+	}
+	tokenID, err := l.publisher.ApproveServiceFor(serviceID, l.consumer.OrgSetup.Identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := ix.OwnerOf(tokenID); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("token %s minted while the index was closed was not indexed", tokenID)
+		}
+	}
+	if status := ix.Status(); status.LastBlock <= lastBlock {
+		t.Fatalf("checkpoint at block %d after resuming from block %d", status.LastBlock, lastBlock)
+	}
+	if services, err := ix.GetServices(); err != nil || len(services) != 0 {
+		t.Fatalf("registry token was replayed on resume: %v, %v", services, err)
+	}
+
+	if err := ix.Rebuild(); err != nil {
+		t.Fatal(err)
+	}
+	l.settle(t, ix)
+	if services, err := ix.GetServices(); err != nil || len(services) != 1 {
+		t.Fatalf("services after rebuild: %v, %v", services, err)
+	}
+	if status := ix.Status(); status.Rebuilding {
+		t.Fatalf("status after rebuild: %+v", status)
+	}
+}
+
+func TestOnTransfer(t *testing.T) {
+	l := newTestLedger(t)
+	ix := l.start(t, filepath.Join(t.TempDir(), "index.db"))
+	serviceID, err := l.publisher.NewService("http://publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerOf := func(tokenID string) string {
+		t.Helper()
+		l.settle(t, ix)
+		owner, err := ix.OwnerOf(tokenID)
+		if err != nil {
+			return ""
+		}
+		return owner
+	}
+
+	tokenID, err := l.publisher.ApproveServiceFor(serviceID, l.consumer.OrgSetup.Identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer := normalizeAccount(l.consumer.OrgSetup.Identity)
+	if owner := ownerOf(tokenID); owner != consumer {
+		t.Fatalf("minted token owned by %q in the index", owner)
+	}
+	if count, err := ix.BalanceOfByURI(consumer, serviceID); err != nil || count != 1 {
+		t.Fatalf("indexed balance of %s: %d, %v", serviceID, count, err)
+	}
+
+	publisher := normalizeAccount(l.publisher.OrgSetup.Identity)
+	if err := l.consumer.TransferFrom(l.consumer.OrgSetup.Identity, l.publisher.OrgSetup.Identity, tokenID); err != nil {
+		t.Fatal(err)
+	}
+	if owner := ownerOf(tokenID); owner != publisher {
+		t.Fatalf("transferred token owned by %q in the index", owner)
+	}
+	if count, err := ix.BalanceOfByURIPrefix(consumer, serviceID); err != nil || count != 0 {
+		t.Fatalf("indexed balance after transfer: %d, %v", count, err)
+	}
+
+	if err := l.publisher.Burn(tokenID); err != nil {
+		t.Fatal(err)
+	}
+	if owner := ownerOf(tokenID); owner != "" {
+		t.Fatalf("burned token owned by %q in the index", owner)
+	}
+}
+
+func TestAccessGrantOf(t *testing.T) {
+	l := newTestLedger(t)
+	ix := l.start(t, filepath.Join(t.TempDir(), "index.db"))
+	serviceID, err := l.publisher.NewService("http://publisher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer := l.consumer.OrgSetup.Identity
+	now := time.Now()
+	if _, err := l.publisher.ApproveServiceUntil(serviceID, consumer, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	longest, err := l.publisher.ApproveServiceUntil(serviceID, consumer, now.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.settle(t, ix)
+
+	grant, ok, err := ix.AccessGrantOf(consumer, serviceID, now)
+	if err != nil || !ok || grant.TokenID != longest {
+		t.Fatalf("AccessGrantOf = %+v, %v, %v, want token %s", grant, ok, err, longest)
+	}
+	if onLedger, _, _ := l.publisher.AccessGrantOf(consumer, serviceID, now); grant != onLedger {
+		t.Fatalf("indexed grant %+v, on the ledger %+v", grant, onLedger)
+	}
+	if _, ok, err := ix.AccessGrantOf(consumer, serviceID, now.Add(4*time.Hour)); err != nil || ok {
+		t.Fatalf("AccessGrantOf after expiry: %v, %v", ok, err)
+	}
+	if _, ok, err := ix.AccessGrantOf(consumer, "Service-99", now); err != nil || ok {
+		t.Fatalf("AccessGrantOf another service: %v, %v", ok, err)
+	}
+
+	// a token a revocation left with its holder is no access
+	if _, err := l.publisher.RecordRevocation(chaincodeservice.Revocation{ServiceID: serviceID, Owner: normalizeAccount(consumer), Held: []string{longest}, RevokedAt: now.Unix()}); err != nil {
+		t.Fatal(err)
+	}
+	l.settle(t, ix)
+	grant, ok, err = ix.AccessGrantOf(consumer, serviceID, now)
+	if err != nil || !ok || grant.TokenID == longest || grant.ExpiresAt != now.Add(time.Hour).Unix() {
+		t.Fatalf("AccessGrantOf with a held token: %+v, %v, %v", grant, ok, err)
+	}
+}
+
+func TestFindQueries(t *testing.T) {
+	l := newTestLedger(t)
+	ix := l.start(t, filepath.Join(t.TempDir(), "index.db"))
+	want := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		want[l.createQuery(t, "alice", chaincodeservice.LegitimacyGranted)] = true
+	}
+	l.createQuery(t, "bob", chaincodeservice.LegitimacyGranted)
+	l.createQuery(t, "alice", chaincodeservice.LegitimacyNoAccess)
+	l.settle(t, ix)
+
+	filter := chaincodeservice.QueryFilter{InitiatorID: "alice", Legitimacy: chaincodeservice.LegitimacyGranted}
+	var listed []chaincodeservice.Query
+	pages := 0
+	for bookmark := ""; ; {
+		page, err := ix.FindQueries(filter, 2, bookmark)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		for _, query := range page.Queries {
+			if !filter.Match(query) {
+				t.Fatalf("query %s does not match the filter", query.QueryID)
+			}
+			listed = append(listed, query)
+		}
+		if page.Bookmark == "" {
+			break
+		}
+		if last := page.Queries[len(page.Queries)-1]; page.Bookmark != fmt.Sprintf("%d|%s", last.Timestamp, last.QueryID) {
+			t.Fatalf("bookmark %s is not the last query of its page", page.Bookmark)
+		}
+		bookmark = page.Bookmark
+	}
+	if len(listed) != len(want) || pages != 3 {
+		t.Fatalf("listed %d queries in %d pages, want %d in 3", len(listed), pages, len(want))
+	}
+	for i, query := range listed {
+		if !want[query.QueryID] {
+			t.Fatalf("query %s listed", query.QueryID)
+		}
+		if i > 0 && (query.Timestamp < listed[i-1].Timestamp || query.Timestamp == listed[i-1].Timestamp && query.QueryID <= listed[i-1].QueryID) {
+			t.Fatalf("queries listed out of order: %+v", listed)
+		}
+	}
+
+	if page, err := ix.FindQueries(chaincodeservice.QueryFilter{From: 1, To: 2}, 10, ""); err != nil || len(page.Queries) != 0 {
+		t.Fatalf("queries outside the time range: %+v, %v", page, err)
+	}
+	if _, err := ix.FindQueries(filter, 0, ""); err == nil {
+		t.Fatal("page size 0 was accepted")
+	}
+	if _, err := ix.FindQueries(filter, 2, "Org1MSP-1"); !errors.Is(err, ErrInvalidBookmark) {
+		t.Fatalf("FindQueries with a malformed bookmark: %v", err)
+	}
+
+	// a record created later lands on the last page
+	page, err := ix.FindQueries(chaincodeservice.QueryFilter{}, 7, "")
+	if err != nil || page.Bookmark != "" {
+		t.Fatalf("all queries: %+v, %v", page, err)
+	}
+	if _, err := l.queries.Ledger.Invoke("ds_query", "ch", "CreateQuery", []string{"", "", "1", "alice", "Org2MSP", chaincodeservice.LegitimacyGranted, "t", "", "Org1MSP-0", "Service-0", strconv.FormatInt(time.Now().Unix()+60, 10)}); err != nil {
+		t.Fatal(err)
+	}
+	l.settle(t, ix)
+	page, err = ix.FindQueries(chaincodeservice.QueryFilter{}, 7, "")
+	if err != nil || page.Bookmark == "" {
+		t.Fatalf("first page after a new query: %+v, %v", page, err)
+	}
+	page, err = ix.FindQueries(chaincodeservice.QueryFilter{}, 7, page.Bookmark)
+	if err != nil || len(page.Queries) != 1 || page.Queries[0].QueryID != "Org1MSP-0" {
+		t.Fatalf("last page after a new query: %+v, %v", page, err)
+	}
+}
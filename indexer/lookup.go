@@ -0,0 +1,247 @@
+package indexer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"service-client/chaincodeservice"
+)
+
+// mintPrefix marks the registry token of a service, see ServiceContract.NewService
+const mintPrefix = "Mint|"
+
+// ErrInvalidBookmark is returned for a bookmark FindQueries did not hand out
+var ErrInvalidBookmark = errors.New("invalid bookmark")
+
+// revocationPrefix marks a revocation record, see chaincodeservice.Revocation
+const revocationPrefix = "Revoked|"
+
+// GetServices answers ServiceContract.GetServices from the index, in token
+// order.
+func (ix *Indexer) GetServices() ([]string, error) {
+	rows, err := ix.db.Query("SELECT token_uri FROM tokens WHERE token_uri LIKE ? ESCAPE '\\' ORDER BY CAST(token_id AS INTEGER), token_id", escapeLike(mintPrefix)+"%")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indexed services: %w", err)
+	}
+	defer rows.Close()
+
+	var services []string
+	for rows.Next() {
+		var tokenURI string
+		if err := rows.Scan(&tokenURI); err != nil {
+			return nil, err
+		}
+		services = append(services, tokenURI[len(mintPrefix):])
+	}
+	return services, rows.Err()
+}
+
+// PublisherOf mirrors ServiceContract.PublisherOf.
+func (ix *Indexer) PublisherOf(serviceID string) (string, error) {
+	var owner string
+	err := ix.db.QueryRow("SELECT owner FROM tokens WHERE token_uri LIKE ? ESCAPE '\\' ORDER BY CAST(token_id AS INTEGER) DESC LIMIT 1", escapeLike(mintPrefix+serviceID+"|")+"%").Scan(&owner)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("service %s is not indexed", serviceID)
+	}
+	return owner, err
+}
+
+func (ix *Indexer) OwnerOf(tokenID string) (string, error) {
+	var owner string
+	err := ix.db.QueryRow("SELECT owner FROM tokens WHERE token_id = ?", tokenID).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("token %s is not indexed", tokenID)
+	}
+	return owner, err
+}
+
+func (ix *Indexer) BalanceOf(owner string) (int, error) {
+	return ix.count("SELECT COUNT(*) FROM tokens WHERE owner = ?", normalizeAccount(owner))
+}
+
+func (ix *Indexer) BalanceOfByURI(owner string, tokenURI string) (int, error) {
+	return ix.count("SELECT COUNT(*) FROM tokens WHERE owner = ? AND token_uri = ?", normalizeAccount(owner), tokenURI)
+}
+
+func (ix *Indexer) BalanceOfByURIPrefix(owner string, tokenURI string) (int, error) {
+	return ix.count("SELECT COUNT(*) FROM tokens WHERE owner = ? AND token_uri LIKE ? ESCAPE '\\'", normalizeAccount(owner), escapeLike(tokenURI)+"%")
+}
+
+// HasAccessToService mirrors ServiceContract.HasAccessToService for the
+// identity of the service contract's client.
+func (ix *Indexer) HasAccessToService(serviceID string) (bool, error) {
+	ownerMSPID, err := ix.OwnerMSPID()
+	if err != nil {
+		return false, err
+	}
+	if ownerMSPID == ix.serviceContract.OrgSetup.MSPID {
+		return true, nil
+	}
+	_, ok, err := ix.AccessGrantOf(ix.serviceContract.OrgSetup.Identity, serviceID, time.Now())
+	return ok, err
+}
+
+// AccessGrantOf mirrors ServiceContract.AccessGrantOf.
+func (ix *Indexer) AccessGrantOf(identity string, serviceID string, now time.Time) (grant chaincodeservice.AccessGrant, ok bool, err error) {
+	identity = normalizeAccount(identity)
+	held, err := ix.heldTokens(identity, serviceID)
+	if err != nil {
+		return grant, false, err
+	}
+	rows, err := ix.db.Query("SELECT token_id, token_uri FROM tokens WHERE owner = ? AND (token_uri = ? OR token_uri LIKE ? ESCAPE '\\')", identity, serviceID, escapeLike(serviceID+"|")+"%")
+	if err != nil {
+		return grant, false, fmt.Errorf("failed to list indexed access tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var grants []chaincodeservice.AccessGrant
+	for rows.Next() {
+		var tokenID, tokenURI string
+		if err := rows.Scan(&tokenID, &tokenURI); err != nil {
+			return grant, false, err
+		}
+		g, isGrant, err := chaincodeservice.ParseAccessGrant(tokenID, identity, tokenURI)
+		if err != nil || !isGrant || held[tokenID] {
+			continue
+		}
+		grants = append(grants, g)
+	}
+	if err := rows.Err(); err != nil {
+		return grant, false, err
+	}
+	grant, ok = chaincodeservice.LongestGrant(grants, now)
+	return grant, ok, nil
+}
+
+// heldTokens returns the IDs of the access tokens of serviceID that
+// revocation records name as held by identity, see Revocation.Held.
+func (ix *Indexer) heldTokens(identity string, serviceID string) (map[string]bool, error) {
+	rows, err := ix.db.Query("SELECT token_id, token_uri FROM tokens WHERE token_uri LIKE ? ESCAPE '\\'", escapeLike(revocationPrefix+serviceID+"|"+identity+"|")+"%")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indexed revocations: %w", err)
+	}
+	defer rows.Close()
+
+	held := make(map[string]bool)
+	for rows.Next() {
+		var tokenID, tokenURI string
+		if err := rows.Scan(&tokenID, &tokenURI); err != nil {
+			return nil, err
+		}
+		revocation, ok, err := chaincodeservice.ParseRevocation(tokenID, tokenURI)
+		if err != nil || !ok {
+			continue
+		}
+		for _, id := range revocation.Held {
+			held[id] = true
+		}
+	}
+	return held, rows.Err()
+}
+
+// OwnerMSPID is fixed when the contract is initialized, so it is read from
+// the ledger once and then cached.
+func (ix *Indexer) OwnerMSPID() (string, error) {
+	ix.mu.Lock()
+	cached := ix.ownerMSPID
+	ix.mu.Unlock()
+	if cached != "" {
+		return cached, nil
+	}
+	ownerMSPID, err := ix.serviceContract.OwnerMSPID()
+	if err != nil {
+		return "", err
+	}
+	ix.mu.Lock()
+	ix.ownerMSPID = ownerMSPID
+	ix.mu.Unlock()
+	return ownerMSPID, nil
+}
+
+// FindQueries pages through the query records matching filter. Pages are
+// ordered by Timestamp, then QueryID, so records created while a client
+// pages through land on its last pages. The bookmark is
+// "<Timestamp>|<QueryID>" of the last record of a page.
+func (ix *Indexer) FindQueries(filter chaincodeservice.QueryFilter, pageSize int, bookmark string) (chaincodeservice.QueryPage, error) {
+	if pageSize <= 0 {
+		return chaincodeservice.QueryPage{}, fmt.Errorf("invalid page size %d", pageSize)
+	}
+	var conditions []string
+	var args []interface{}
+	if bookmark != "" {
+		timestamp, queryID, err := parseQueryBookmark(bookmark)
+		if err != nil {
+			return chaincodeservice.QueryPage{}, err
+		}
+		conditions = append(conditions, "(timestamp > ? OR (timestamp = ? AND query_id > ?))")
+		args = append(args, timestamp, timestamp, queryID)
+	}
+	for column, value := range map[string]string{"service_id": filter.ServiceID, "initiator_id": filter.InitiatorID, "legitimacy": filter.Legitimacy} {
+		if value != "" {
+			conditions = append(conditions, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	if filter.From != 0 {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, filter.From)
+	}
+	if filter.To != 0 {
+		conditions = append(conditions, "timestamp <= ?")
+		args = append(args, filter.To)
+	}
+	// one extra row tells whether there is a next page
+	args = append(args, pageSize+1)
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	rows, err := ix.db.Query(`SELECT query_id, certificate, data_digest, data_rows, initiator_id, initiator_msp_id, legitimacy, queried_table, query_digest, service_id, timestamp
+		FROM queries `+where+` ORDER BY timestamp, query_id LIMIT ?`, args...)
+	if err != nil {
+		return chaincodeservice.QueryPage{}, fmt.Errorf("failed to list indexed queries: %w", err)
+	}
+	defer rows.Close()
+
+	page := chaincodeservice.QueryPage{Queries: []chaincodeservice.Query{}}
+	for rows.Next() {
+		var q chaincodeservice.Query
+		if err := rows.Scan(&q.QueryID, &q.Certificate, &q.DataDigest, &q.DataRows, &q.InitiatorID, &q.InitiatorMSPID, &q.Legitimacy, &q.QueriedTable, &q.QueryDigest, &q.ServiceID, &q.Timestamp); err != nil {
+			return chaincodeservice.QueryPage{}, err
+		}
+		page.Queries = append(page.Queries, q)
+	}
+	if len(page.Queries) > pageSize {
+		page.Queries = page.Queries[:pageSize]
+		last := page.Queries[pageSize-1]
+		page.Bookmark = fmt.Sprintf("%d|%s", last.Timestamp, last.QueryID)
+	}
+	return page, rows.Err()
+}
+
+func parseQueryBookmark(bookmark string) (int64, string, error) {
+	timestamp, queryID, ok := strings.Cut(bookmark, "|")
+	if ok {
+		if t, err := strconv.ParseInt(timestamp, 10, 64); err == nil {
+			return t, queryID, nil
+		}
+	}
+	return 0, "", fmt.Errorf("%w %s", ErrInvalidBookmark, bookmark)
+}
+
+func (ix *Indexer) count(query string, args ...interface{}) (int, error) {
+	var count int
+	if err := ix.db.QueryRow(query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count indexed tokens: %w", err)
+	}
+	return count, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(s)
+}
@@ -0,0 +1,186 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"golang.org/x/crypto/hkdf"
+)
+
+// x25519Info separates the keys derived for X25519 encryption
+const x25519Info = "service-client x25519 v1"
+
+// Encrypt encrypts plaintext for the holder of publicKey, binding it to
+// shared, which decryption has to be given as well. ECDSA keys use ECIES on
+// their curve with shared as its MAC data. Ed25519 keys are converted to
+// X25519: the ciphertext is an ephemeral X25519 key, then a nonce and
+// AES-256-GCM over plaintext with shared as additional data, keyed by
+// HKDF-SHA256 over the shared secret.
+func Encrypt(publicKey crypto.PublicKey, plaintext []byte, shared []byte) ([]byte, error) {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if _, err := Algorithm(key); err != nil {
+			return nil, err
+		}
+		return ecies.Encrypt(rand.Reader, ecies.ImportECDSAPublic(key), plaintext, nil, shared)
+	case ed25519.PublicKey:
+		recipient, err := X25519Public(key)
+		if err != nil {
+			return nil, err
+		}
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := x25519AEAD(ephemeral, recipient, ephemeral.PublicKey())
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		ciphertext := append(ephemeral.PublicKey().Bytes(), nonce...)
+		return aead.Seal(ciphertext, nonce, plaintext, shared), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", publicKey)
+	}
+}
+
+// Decrypt decrypts what Encrypt encrypted for the public key of privateKey.
+func Decrypt(privateKey crypto.PrivateKey, ciphertext []byte, shared []byte) ([]byte, error) {
+	switch key := privateKey.(type) {
+	case *ecdsa.PrivateKey:
+		return ecies.ImportECDSA(key).Decrypt(ciphertext, nil, shared)
+	case ed25519.PrivateKey:
+		recipient, err := X25519Private(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(ciphertext) < 32 {
+			return nil, fmt.Errorf("ciphertext too short")
+		}
+		ephemeral, err := ecdh.X25519().NewPublicKey(ciphertext[:32])
+		if err != nil {
+			return nil, err
+		}
+		aead, err := x25519AEAD(recipient, ephemeral, ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		rest := ciphertext[32:]
+		if len(rest) < aead.NonceSize() {
+			return nil, fmt.Errorf("ciphertext too short")
+		}
+		return aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], shared)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", privateKey)
+	}
+}
+
+// x25519AEAD keys AES-256-GCM with the secret private shares with peer. The
+// ephemeral key of the message is part of the derivation.
+func x25519AEAD(private *ecdh.PrivateKey, peer *ecdh.PublicKey, ephemeral *ecdh.PublicKey) (cipher.AEAD, error) {
+	secret, err := private.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, ephemeral.Bytes(), []byte(x25519Info)), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ECDH returns the key agreement form of an identity public key: the same
+// point for ECDSA keys, the X25519 equivalent of an Ed25519 key.
+func ECDH(publicKey crypto.PublicKey) (*ecdh.PublicKey, error) {
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if _, err := Algorithm(key); err != nil {
+			return nil, err
+		}
+		return key.ECDH()
+	case ed25519.PublicKey:
+		return X25519Public(key)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", publicKey)
+	}
+}
+
+// ECDHPrivate is ECDH for private keys.
+func ECDHPrivate(privateKey crypto.PrivateKey) (*ecdh.PrivateKey, error) {
+	switch key := privateKey.(type) {
+	case *ecdsa.PrivateKey:
+		if _, err := Algorithm(key); err != nil {
+			return nil, err
+		}
+		return key.ECDH()
+	case ed25519.PrivateKey:
+		return X25519Private(key)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", privateKey)
+	}
+}
+
+// curve25519P is the prime of the field of Curve25519, 2^255 - 19
+var curve25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// X25519Public converts an Ed25519 public key to X25519, mapping the
+// Edwards y coordinate to the Montgomery u = (1 + y) / (1 - y) as RFC 7748
+// describes.
+func X25519Public(publicKey ed25519.PublicKey) (*ecdh.PublicKey, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 public key")
+	}
+	// little-endian y without the sign bit of x
+	le := make([]byte, 32)
+	copy(le, publicKey)
+	le[31] &= 0x7f
+	y := new(big.Int).SetBytes(reverse(le))
+	if y.Cmp(curve25519P) >= 0 {
+		return nil, fmt.Errorf("invalid Ed25519 public key")
+	}
+	one := big.NewInt(1)
+	denominator := new(big.Int).Sub(one, y)
+	denominator.Mod(denominator, curve25519P)
+	if denominator.Sign() == 0 {
+		return nil, fmt.Errorf("invalid Ed25519 public key")
+	}
+	u := new(big.Int).Add(one, y)
+	u.Mul(u, denominator.ModInverse(denominator, curve25519P))
+	u.Mod(u, curve25519P)
+	return ecdh.X25519().NewPublicKey(reverse(u.FillBytes(make([]byte, 32))))
+}
+
+// X25519Private converts an Ed25519 private key to X25519: the scalar is
+// the first half of the SHA-512 of the seed, as Ed25519 uses it.
+func X25519Private(privateKey ed25519.PrivateKey) (*ecdh.PrivateKey, error) {
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 private key")
+	}
+	h := sha512.Sum512(privateKey.Seed())
+	return ecdh.X25519().NewPrivateKey(h[:32])
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
@@ -0,0 +1,162 @@
+// Package keys abstracts over the key algorithms Fabric identities may use:
+// ECDSA on P-256 or P-384, and Ed25519. Identities sign with their own
+// algorithm; data for them is encrypted with ECIES on their curve, or for
+// Ed25519 identities with their key converted to X25519.
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+)
+
+// Algorithms of identity keys
+const (
+	P256    = "P-256"
+	P384    = "P-384"
+	Ed25519 = "Ed25519"
+)
+
+// Algorithms lists the supported algorithms.
+func Algorithms() []string {
+	return []string{P256, P384, Ed25519}
+}
+
+// Algorithm names the algorithm of a public or private key.
+func Algorithm(key interface{}) (string, error) {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return curveAlgorithm(k.Curve)
+	case *ecdsa.PrivateKey:
+		return curveAlgorithm(k.Curve)
+	case ed25519.PublicKey, ed25519.PrivateKey:
+		return Ed25519, nil
+	default:
+		return "", fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+func curveAlgorithm(curve elliptic.Curve) (string, error) {
+	switch curve {
+	case elliptic.P256():
+		return P256, nil
+	case elliptic.P384():
+		return P384, nil
+	default:
+		return "", fmt.Errorf("unsupported curve %s", curve.Params().Name)
+	}
+}
+
+// hash is the digest ECDSA signatures of an algorithm are made over, 0 for
+// Ed25519 which signs the message itself.
+func hash(algorithm string) crypto.Hash {
+	switch algorithm {
+	case P256:
+		return crypto.SHA256
+	case P384:
+		return crypto.SHA384
+	default:
+		return 0
+	}
+}
+
+func digest(algorithm string, message []byte) []byte {
+	switch hash(algorithm) {
+	case crypto.SHA256:
+		sum := sha256.Sum256(message)
+		return sum[:]
+	case crypto.SHA384:
+		sum := sha512.Sum384(message)
+		return sum[:]
+	default:
+		return message
+	}
+}
+
+// Sign signs message with signer: ECDSA signatures are ASN.1 encoded over
+// SHA-256 on P-256 and SHA-384 on P-384, Ed25519 ones sign message itself.
+func Sign(signer crypto.Signer, message []byte) ([]byte, error) {
+	algorithm, err := Algorithm(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signer.Sign(rand.Reader, digest(algorithm, message), hash(algorithm))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %w", err)
+	}
+	return signature, nil
+}
+
+// Verify checks a signature made by Sign.
+func Verify(publicKey crypto.PublicKey, message []byte, signature []byte) error {
+	algorithm, err := Algorithm(publicKey)
+	if err != nil {
+		return err
+	}
+	var valid bool
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest(algorithm, message), signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, message, signature)
+	}
+	if !valid {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// MarshalPublic encodes a public key as base64 PKIX, to be sent in a
+// request payload.
+func MarshalPublic(publicKey crypto.PublicKey) (string, error) {
+	if _, err := Algorithm(publicKey); err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(der), nil
+}
+
+// ParsePublic decodes a public key encoded by MarshalPublic.
+func ParsePublic(encoded string) (crypto.PublicKey, error) {
+	der, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if _, err := Algorithm(publicKey); err != nil {
+		return nil, err
+	}
+	return publicKey, nil
+}
+
+// Equal reports whether two public keys are the same.
+func Equal(a crypto.PublicKey, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
+
+// Material returns the secret bytes of a private key, to derive other keys
+// from: the scalar of an ECDSA key, the seed of an Ed25519 one.
+func Material(privateKey crypto.PrivateKey) ([]byte, error) {
+	switch key := privateKey.(type) {
+	case *ecdsa.PrivateKey:
+		return key.D.FillBytes(make([]byte, (key.Curve.Params().BitSize+7)/8)), nil
+	case ed25519.PrivateKey:
+		return key.Seed(), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", privateKey)
+	}
+}
@@ -0,0 +1,104 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+)
+
+func generate(t *testing.T, algorithm string) crypto.Signer {
+	t.Helper()
+	var key crypto.Signer
+	var err error
+	switch algorithm {
+	case P256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case P384:
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case Ed25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSignVerify(t *testing.T) {
+	for _, algorithm := range Algorithms() {
+		key := generate(t, algorithm)
+		if got, err := Algorithm(key.Public()); err != nil || got != algorithm {
+			t.Fatalf("algorithm of a %s key: %s, %v", algorithm, got, err)
+		}
+		message := []byte("message")
+		signature, err := Sign(key, message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Verify(key.Public(), message, signature); err != nil {
+			t.Errorf("%s signature does not verify: %v", algorithm, err)
+		}
+		if Verify(key.Public(), []byte("other message"), signature) == nil {
+			t.Errorf("%s signature verifies over another message", algorithm)
+		}
+		if Verify(generate(t, algorithm).Public(), message, signature) == nil {
+			t.Errorf("%s signature verifies with another key", algorithm)
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sign(key, []byte("message")); err == nil {
+		t.Error("signed with a P-224 key")
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	for _, algorithm := range Algorithms() {
+		key := generate(t, algorithm)
+		plaintext, shared := []byte("plaintext"), []byte("shared")
+		ciphertext, err := Encrypt(key.Public(), plaintext, shared)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := Decrypt(key, ciphertext, shared)
+		if err != nil || string(decrypted) != string(plaintext) {
+			t.Fatalf("%s decrypted %q: %v", algorithm, decrypted, err)
+		}
+		if _, err := Decrypt(key, ciphertext, []byte("other")); err == nil {
+			t.Errorf("%s ciphertext decrypts with other shared data", algorithm)
+		}
+		if _, err := Decrypt(generate(t, algorithm), ciphertext, shared); err == nil {
+			t.Errorf("%s ciphertext decrypts with another key", algorithm)
+		}
+		ciphertext[len(ciphertext)-1] ^= 1
+		if _, err := Decrypt(key, ciphertext, shared); err == nil {
+			t.Errorf("altered %s ciphertext decrypts", algorithm)
+		}
+	}
+}
+
+func TestMarshalPublic(t *testing.T) {
+	for _, algorithm := range Algorithms() {
+		key := generate(t, algorithm)
+		encoded, err := MarshalPublic(key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParsePublic(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !Equal(parsed, key.Public()) || Equal(parsed, generate(t, algorithm).Public()) {
+			t.Errorf("%s key does not survive encoding", algorithm)
+		}
+	}
+	if _, err := ParsePublic("not a key"); err == nil {
+		t.Error("parsed an invalid key")
+	}
+}
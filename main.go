@@ -79,14 +79,24 @@ func main() {
 	app.POST("/send_application", r.SendApplication())
 	app.POST("/request_data", r.CRequestData())
 	app.POST("/put_service", r.IPutService())
+	app.POST("/update_service", internalOnlyMiddleware(), r.IUpdateService())
+	app.POST("/set_service_state", internalOnlyMiddleware(), r.ISetServiceState())
+	app.POST("/delete_service", internalOnlyMiddleware(), r.IDeleteService())
 	app.POST("/forward_application", r.ForwardApplication())
 	app.POST("/receive_message", r.ReceiveMessage())
 	app.POST("/fetch_data", r.FetchData())
+	app.GET("/download", internalOnlyMiddleware(), r.IDownload())
 	app.POST("/approve_application", r.IApproveApplication())
 	app.POST("/debug_query", r.IDebugQuery())
+	app.POST("/revoke_access", internalOnlyMiddleware(), r.IRevokeAccess())
+	app.POST("/receive_revocation", r.ReceiveRevocation())
 	app.GET("/get_toMe", r.GetToMe())
 	app.GET("/get_sendOut", r.GetSendOut())
 	app.GET("/get_services", r.IGetServices())
+	app.GET("/get_queries", internalOnlyMiddleware(), r.IGetQueries())
+	app.GET("/describe_service", r.DescribeService())
+	app.GET("/describe", r.IDescribeService())
+	app.POST("/rebuild_index", internalOnlyMiddleware(), r.IRebuildIndex())
 
 	listenConfig(r)
 	runApp(app, r.Port)
@@ -1,9 +1,11 @@
 package routers
 
 import (
-	"crypto/ecdsa"
+	"crypto"
 	"fmt"
 	"net/http"
+	"service-client/chaincodeservice"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hyperledger/fabric-gateway/pkg/client"
@@ -18,17 +20,20 @@ type ApplicationAnswer struct {
 	// TODO
 	ApplicationTime string `json:"ApplicationTime"`
 	ProcessTime     string `json:"ProcessTime"`
-	Status          int    `json:"Status"` // 0-pending 1-approved 2-rejected
+	Status          int    `json:"Status"` // 0-pending 1-approved 2-rejected 3-revoked
+	Reason          string `json:"Reason"`
+	Renewal         bool   `json:"Renewal"`
 }
 
 type Application struct {
 	InitiatorURL       string           `json:"InitiatorURL"`
-	InitiatorPublicKey *ecdsa.PublicKey `json:"InitiatorPublicKey"`
+	InitiatorPublicKey crypto.PublicKey `json:"InitiatorPublicKey"`
 	InitiatorID        string           `json:"InitiatorID"`
 	ServiceID          string           `json:"ServiceID"`
 	ServiceName        string           `json:"ServiceName"`
 	ApplicationTime    string           `json:"ApplicationTime"`
 	Status             int              `json:"Status"`
+	Renewal            bool             `json:"Renewal"`
 }
 
 func (r *Routers) GetToMe() func(*gin.Context) {
@@ -43,9 +48,14 @@ func (r *Routers) GetSendOut() func(*gin.Context) {
 	}
 }
 
-func (r *Routers) ListenTransfer(e *client.ChaincodeEvent) {
-	fmt.Printf("Transfer event received: %s\n", e.Payload)
-	if e.EventName == "Transfer" {
-		fmt.Printf("Transfer event received: %s\n", e.Payload)
+// ListenTransfer approves publishers for the access tokens they transfer to
+// me, see approveGrant.
+func (r *Routers) ListenTransfer(e *client.ChaincodeEvent, transfer chaincodeservice.TransferEvent) {
+	fmt.Printf("Transfer event received: token %s from %s to %s (block %d, tx %s)\n", transfer.TokenID, transfer.From, transfer.To, e.BlockNumber, e.TransactionID)
+	if transfer.From == "0x0" || strings.ReplaceAll(transfer.To, " ", "") != strings.ReplaceAll(r.OrgSetup.Identity, " ", "") {
+		return
+	}
+	if err := r.approveGrant(transfer.From, transfer.TokenID); err != nil {
+		fmt.Printf("failed to approve %s for access token %s: %s\n", transfer.From, transfer.TokenID, err)
 	}
 }
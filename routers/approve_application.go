@@ -4,30 +4,79 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
+
+	"service-client/chaincodeservice"
 
 	"github.com/gin-gonic/gin"
 )
 
-// Handle application approval in the front-end. Args: ServiceID, InitiatorIdentity
+// Handle application approval in the front-end. Args: ServiceID, InitiatorID,
+// ExpiresAt (optional, Unix seconds), MaxFetches, MaxRows and QuotaPeriod
+// (optional, seconds). Approving a grant for an initiator that already holds a
+// time-limited one renews it.
 func (r *Routers) IApproveApplication() func(c *gin.Context) {
 	return func(c *gin.Context) {
 		var httpData map[string]interface{}
 		if err := c.ShouldBindJSON(&httpData); err != nil {
 			fmt.Printf("error: %v\n", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 
-		serviceID := httpData["ServiceID"].(string)
-		recipientIdentity := httpData["InitiatorID"].(string)
+		serviceID, _ := httpData["ServiceID"].(string)
+		recipientIdentity, _ := httpData["InitiatorID"].(string)
 		recipientIdentity = strings.ReplaceAll(recipientIdentity, " ", "")
+		if recipientIdentity == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "InitiatorID is required"})
+			return
+		}
+		if service, valid := r.service(serviceID); !valid || service.state() == chaincodeservice.ServiceUnpublished {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("service %s is not published", serviceID)})
+			return
+		}
+
+		var expiresAt time.Time
+		if expires, ok := httpData["ExpiresAt"].(float64); ok && expires > 0 {
+			expiresAt = time.Unix(int64(expires), 0)
+			if !expiresAt.After(time.Now()) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("ExpiresAt %s is in the past", expiresAt.Format("2006-01-02 15:04:05"))})
+				return
+			}
+		}
 
-		tokenId, err := r.ServiceContract.ApproveServiceFor(serviceID, recipientIdentity)
+		grant := chaincodeservice.AccessGrant{ServiceID: serviceID}
+		if !expiresAt.IsZero() {
+			grant.ExpiresAt = expiresAt.Unix()
+		}
+		if maxFetches, ok := httpData["MaxFetches"].(float64); ok {
+			grant.MaxFetches = int(maxFetches)
+		}
+		if maxRows, ok := httpData["MaxRows"].(float64); ok {
+			grant.MaxRows = int(maxRows)
+		}
+		if period, ok := httpData["QuotaPeriod"].(float64); ok {
+			grant.QuotaPeriod = int64(period)
+		}
+		if grant.MaxFetches < 0 || grant.MaxRows < 0 || grant.QuotaPeriod < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "quota terms must not be negative"})
+			return
+		}
+		tokenId, err := r.ServiceContract.ApproveGrant(grant, recipientIdentity)
 		if err != nil {
 			fmt.Printf("error: %v\n", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
+		var renewed []string
+		if !expiresAt.IsZero() {
+			renewed, err = r.renewGrants(recipientIdentity, serviceID, tokenId, expiresAt)
+			if err != nil {
+				fmt.Printf("failed to retire renewed access tokens: %s\n", err)
+			}
+		}
+
 		// 4. 删除applicationToMe里的对应项，先别删了
 		// for i := 0; i < len(r.ApplicationToMe); i++ {
 		// 	if r.ApplicationToMe[i].InitiatorID == httpData["InitiatorID"].(string) && r.ApplicationToMe[i].ServiceID == httpData["ServiceID"].(string) {
@@ -36,6 +85,6 @@ func (r *Routers) IApproveApplication() func(c *gin.Context) {
 		// 	}
 		// }
 
-		c.JSON(http.StatusOK, gin.H{"tokenId": tokenId})
+		c.JSON(http.StatusOK, gin.H{"tokenId": tokenId, "renewed": renewed})
 	}
 }
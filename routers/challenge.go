@@ -0,0 +1,145 @@
+package routers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Challenge is the only thing ReceiveMessage signs. It is bound to the
+// publisher asking, the identity challenged, what the answer is for and how
+// long it counts, and is signed in a canonical encoding prefixed with a
+// domain tag, so that the signature is no use for anything else:
+//
+//	service-client challenge v1
+//	Purpose: authenticate
+//	Publisher: https://publisher.example:8080
+//	Identity: x509::CN=...::CN=...
+//	Nonce: 9f86d081884c7d659a2feaa0c55ad015
+//	Expires: 1767225600
+type Challenge struct {
+	Version   int    `json:"Version"`
+	Purpose   string `json:"Purpose"`
+	Publisher string `json:"Publisher"`
+	Identity  string `json:"Identity"`
+	Nonce     string `json:"Nonce"`
+	Expires   int64  `json:"Expires"` // Unix seconds
+}
+
+const (
+	challengeVersion = 1
+	challengeDomain  = "service-client challenge v"
+	// purposeAuthenticate challenges an initiator to prove it holds the key
+	// of the identity it applies or requests data as
+	purposeAuthenticate = "authenticate"
+	// challengeLifetime is how long an answer counts, and the longest a
+	// challenge may ask for
+	challengeLifetime = time.Minute
+)
+
+// newChallenge challenges identity on behalf of publisher.
+func newChallenge(publisher string, identity string, purpose string) (Challenge, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return Challenge{}, err
+	}
+	return Challenge{
+		Version:   challengeVersion,
+		Purpose:   purpose,
+		Publisher: publisher,
+		Identity:  strings.ReplaceAll(identity, " ", ""),
+		Nonce:     hex.EncodeToString(nonce),
+		Expires:   time.Now().Add(challengeLifetime).Unix(),
+	}, nil
+}
+
+// Encode returns the canonical encoding of the challenge, which is what is
+// signed.
+func (ch Challenge) Encode() string {
+	return fmt.Sprintf("%s%d\nPurpose: %s\nPublisher: %s\nIdentity: %s\nNonce: %s\nExpires: %d\n",
+		challengeDomain, ch.Version, ch.Purpose, ch.Publisher, ch.Identity, ch.Nonce, ch.Expires)
+}
+
+// Check reports why a challenge to identity must not be signed at now, nil
+// if it may be.
+func (ch Challenge) Check(identity string, now time.Time) error {
+	if ch.Version != challengeVersion {
+		return fmt.Errorf("unsupported challenge version %d", ch.Version)
+	}
+	if ch.Purpose != purposeAuthenticate {
+		return fmt.Errorf("unknown challenge purpose %q", ch.Purpose)
+	}
+	for name, value := range map[string]string{"Publisher": ch.Publisher, "Identity": ch.Identity, "Nonce": ch.Nonce} {
+		if value == "" || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid challenge %s", name)
+		}
+	}
+	if publisher, err := url.Parse(ch.Publisher); err != nil || (publisher.Scheme != "http" && publisher.Scheme != "https") || publisher.Host == "" {
+		return fmt.Errorf("challenge publisher %q is not a URL", ch.Publisher)
+	}
+	if ch.Identity != strings.ReplaceAll(identity, " ", "") {
+		return fmt.Errorf("challenge is for another identity")
+	}
+	if _, err := hex.DecodeString(ch.Nonce); err != nil || len(ch.Nonce) < 32 || len(ch.Nonce) > 128 {
+		return fmt.Errorf("challenge nonce must be 16 to 64 bytes in hex")
+	}
+	expires := time.Unix(ch.Expires, 0)
+	if !expires.After(now) {
+		return fmt.Errorf("challenge expired at %s", expires.UTC().Format(time.RFC3339))
+	}
+	if expires.After(now.Add(challengeLifetime)) {
+		return fmt.Errorf("challenge must expire within %s", challengeLifetime)
+	}
+	return nil
+}
+
+// pendingPublishers counts my requests in flight per publisher URL. Only a
+// publisher I am waiting on may challenge me, so that a challenge cannot be
+// relayed to me by anyone else, see ReceiveMessage.
+type pendingPublishers struct {
+	mu    sync.Mutex
+	count map[string]int
+}
+
+func newPendingPublishers() *pendingPublishers {
+	return &pendingPublishers{count: make(map[string]int)}
+}
+
+// begin records a request to publisherURL until the returned end is called.
+func (p *pendingPublishers) begin(publisherURL string) (end func()) {
+	key := publisherKey(publisherURL)
+	p.mu.Lock()
+	p.count[key]++
+	p.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			if p.count[key]--; p.count[key] <= 0 {
+				delete(p.count, key)
+			}
+			p.mu.Unlock()
+		})
+	}
+}
+
+// waitingOn reports whether a request to publisherURL is in flight.
+func (p *pendingPublishers) waitingOn(publisherURL string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.count[publisherKey(publisherURL)] > 0
+}
+
+// publisherKey compares publisher URLs by scheme, host and path, ignoring
+// case where URLs do and a trailing slash.
+func publisherKey(publisherURL string) string {
+	u, err := url.Parse(strings.TrimSpace(publisherURL))
+	if err != nil {
+		return publisherURL
+	}
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host) + strings.TrimRight(u.Path, "/")
+}
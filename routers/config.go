@@ -13,6 +13,9 @@ import (
 	"path/filepath"
 	"time"
 
+	"service-client/chaincodeservice"
+	"service-client/datasource"
+
 	"github.com/fsnotify/fsnotify"
 )
 
@@ -32,11 +35,73 @@ type ServiceInformation struct {
 
 type ServiceType struct {
 	Information ServiceInformation `json:"Information"`
+	// Type names the data source driver and Options configure it, see package
+	// datasource. Services without a Type read MySQL through Credentials.
+	Type        string             `json:"Type,omitempty"`
+	Options     datasource.Options `json:"Options,omitempty"`
 	Credentials ServiceCredentials `json:"Credentials"`
+	// Masking redacts columns before the data is released. Its version is
+	// recorded on chain with every query, see RecordedTable.
+	Masking *datasource.MaskingPolicy `json:"Masking,omitempty"`
+	// RowSecurity limits every recipient to the rows matching its identity
+	RowSecurity []datasource.RowRule `json:"RowSecurity,omitempty"`
+	// PreviewRows is how many masked rows /describe_service may show to
+	// anyone, 0 for none
+	PreviewRows int `json:"PreviewRows,omitempty"`
+	// State is the lifecycle state of the service, one of the service states
+	// of package chaincodeservice, active if empty
+	State string `json:"State,omitempty"`
+}
+
+func (s ServiceType) state() string {
+	if s.State == "" {
+		return chaincodeservice.ServiceActive
+	}
+	return s.State
+}
+
+// DataSource returns the driver type and options of the service.
+func (s ServiceType) DataSource() (string, datasource.Options) {
+	if s.Type != "" {
+		return s.Type, s.Options
+	}
+	return "mysql", datasource.Options{
+		"Host":     s.Credentials.DatabaseIP,
+		"Port":     s.Credentials.DatabasePort,
+		"User":     s.Credentials.DatabaseUser,
+		"Password": s.Credentials.DatabasePassword,
+		"Database": s.Credentials.DatabaseName,
+		"Table":    s.Credentials.DatabaseTable,
+	}
+}
+
+// RecordedTable is the QueriedTable of query records, the table followed by
+// "|Masking=<version>" when a masking policy applies.
+func (s ServiceType) RecordedTable() string {
+	if s.Masking == nil {
+		return s.Table()
+	}
+	return fmt.Sprintf("%s|Masking=%d", s.Table(), s.Masking.Version)
+}
+
+// Table names the data set of the service.
+func (s ServiceType) Table() string {
+	_, options := s.DataSource()
+	switch {
+	case options["Table"] != "":
+		return options["Table"]
+	case options["Path"] != "":
+		return filepath.Base(options["Path"])
+	default:
+		return options["URL"]
+	}
 }
 
 type Config struct {
 	WebUIPath     string `json:"WebUIPath"`
+	CheckpointDir string `json:"CheckpointDir"`
+	DownloadDir   string `json:"DownloadDir"`
+	IndexPath     string `json:"IndexPath"`
 	QueryContract struct {
 		ChaincodeName string `json:"ChaincodeName"`
 		ChannelID     string `json:"ChannelID"`
@@ -46,22 +111,101 @@ type Config struct {
 		ChannelID     string `json:"ChannelID"`
 	} `json:"ServiceContract"`
 	Services map[string]ServiceType `json:"Services"`
+
+	// how often expired access tokens are burned, as a Go duration
+	GrantSweepInterval string `json:"GrantSweepInterval"`
+	// requests a minute each client may make to /describe_service
+	DescribeRate int `json:"DescribeRate"`
+	// how far the clock of a node signing a request may be off, as a Go
+	// duration, see signRequest
+	SignatureSkew string `json:"SignatureSkew"`
+	// LegacyCallback accepts requests from nodes that predate request
+	// signatures and certificates, on the identity they claim, verified by
+	// calling back their /receive_message. Services with row security or
+	// masking per recipient are never served to them.
+	LegacyCallback bool `json:"LegacyCallback,omitempty"`
+	// MSPDirs maps the MSP IDs of the channel to their MSP directories, whose
+	// CAs the certificates of initiators are validated against. My own MSP
+	// defaults to the msp directory under the crypto path of my org.
+	MSPDirs map[string]string `json:"MSPDirs,omitempty"`
+
+	// MasterKeyFile holds the key sealing the secrets of services, see
+	// package secrets. Without it the key is derived from the org key.
+	MasterKeyFile string       `json:"MasterKeyFile,omitempty"`
+	Vault         *VaultConfig `json:"Vault,omitempty"`
 }
 
+const defaultDescribeRate = 30
+
+func (c Config) describeRate() int {
+	if c.DescribeRate <= 0 {
+		return defaultDescribeRate
+	}
+	return c.DescribeRate
+}
+
+// service returns the config of one of my services.
+func (r *Routers) service(serviceID string) (ServiceType, bool) {
+	r.configMu.RLock()
+	defer r.configMu.RUnlock()
+	service, ok := r.Config.Services[serviceID]
+	return service, ok
+}
+
+// services returns a copy of the configs of my services, safe to range over
+// while they change.
+func (r *Routers) services() map[string]ServiceType {
+	r.configMu.RLock()
+	defer r.configMu.RUnlock()
+	services := make(map[string]ServiceType, len(r.Config.Services))
+	for serviceID, service := range r.Config.Services {
+		services[serviceID] = service
+	}
+	return services
+}
+
+// putService saves the config of one of my services, or removes it if
+// service is nil, and writes the config.
+func (r *Routers) putService(serviceID string, service *ServiceType) error {
+	r.configMu.Lock()
+	defer r.configMu.Unlock()
+	if service == nil {
+		delete(r.Config.Services, serviceID)
+	} else {
+		if r.Config.Services == nil {
+			r.Config.Services = make(map[string]ServiceType)
+		}
+		r.Config.Services[serviceID] = *service
+	}
+	return r.updateConfig()
+}
+
+// updateConfig seals the secrets of the config and writes it. r.configMu
+// must be held.
 func (r *Routers) updateConfig() error {
-	return writeConfig(r.configFile, r.Config)
+	config, _, err := r.sealSecrets(r.Config)
+	if err != nil {
+		return err
+	}
+	r.Config = config
+	return writeConfig(r.configFile, config)
 }
 
+// writeConfig refuses to write a config holding a secret in clear.
 func writeConfig(path string, config Config) error {
+	if name := plaintextSecret(config); name != "" {
+		return fmt.Errorf("refusing to write secret %s in clear", name)
+	}
 	data, err := json.MarshalIndent(config, "", "    ")
 	if err != nil {
 		return err
 	}
-	err = os.WriteFile(path, data, 0644)
+	err = os.WriteFile(path, data, 0600)
 	if err != nil {
 		return err
 	}
-	return nil
+	// WriteFile keeps the mode of a config written by earlier versions
+	return os.Chmod(path, 0600)
 }
 
 func loadPort() (string, error) {
@@ -73,6 +217,16 @@ func loadPort() (string, error) {
 	return port, nil
 }
 
+// "--rebuild-index" after the port replays the local index from genesis
+func loadRebuildIndex() bool {
+	for _, arg := range os.Args[2:] {
+		if arg == "--rebuild-index" {
+			return true
+		}
+	}
+	return false
+}
+
 func loadConfig(filePath string) (Config, error) {
 	var config Config
 	data, err := os.ReadFile(filePath)
@@ -94,7 +248,9 @@ func (r *Routers) ListenConfig() {
 				fmt.Println("Error reading config file:", err)
 				return
 			}
+			r.configMu.Lock()
 			r.Config = config
+			r.configMu.Unlock()
 			fmt.Println("Config file updated")
 		}
 	}, r.configFile)
@@ -0,0 +1,100 @@
+package routers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"service-client/chaincodeservice"
+	"service-client/datasource"
+)
+
+// parseQuery reads the optional Columns and Filters of a data request.
+func parseQuery(httpData map[string]interface{}) (datasource.Query, error) {
+	var q datasource.Query
+	raw, err := json.Marshal(map[string]interface{}{"Columns": httpData["Columns"], "Filters": httpData["Filters"]})
+	if err != nil {
+		return q, err
+	}
+	if err := json.Unmarshal(raw, &q); err != nil {
+		return q, fmt.Errorf("invalid Columns or Filters: %s", err)
+	}
+	if err := q.Check(); err != nil {
+		return q, fmt.Errorf("invalid query: %s", err)
+	}
+	return q, nil
+}
+
+// restrictQuery checks the query of recipient against the policies of a
+// service and adds the row filters of its row security, so that they run in
+// the data source itself. Policies that depend on who the recipient is are
+// only applied to recipients verified by certificate.
+func restrictQuery(service ServiceType, q datasource.Query, recipient initiator) (datasource.Query, error) {
+	if err := service.Masking.CheckQuery(q); err != nil {
+		return q, err
+	}
+	if !recipient.Verified && (len(service.RowSecurity) > 0 || service.Masking.KeyedOnRecipient()) {
+		return q, fmt.Errorf("the service has row security or masking per recipient, which needs an InitiatorCertificate")
+	}
+	if len(service.RowSecurity) == 0 {
+		return q, nil
+	}
+	attributes, err := chaincodeservice.ParseAccountID(recipient.Identity)
+	if err != nil {
+		return q, err
+	}
+	if recipient.MSPID != "" {
+		attributes[datasource.AttributeMSPID] = []string{recipient.MSPID}
+	}
+	filters, err := datasource.RowFilters(service.RowSecurity, attributes)
+	if err != nil {
+		return q, err
+	}
+	q.Filters = append(append([]datasource.Filter{}, q.Filters...), filters...)
+	return q, q.Check()
+}
+
+// errDataSource is returned for a data source that cannot be read; what went
+// wrong is logged, not told to the initiator.
+var errDataSource = errors.New("failed to read data source")
+
+// selectService runs q against the data set of a service and returns the
+// result as a JSON object of its schema and rows, masked for recipient, and
+// the number of rows. A query that does not fit the schema is rejected with
+// an error, a source that cannot be read with errDataSource.
+func (r *Routers) selectService(ctx context.Context, service ServiceType, q datasource.Query, recipient string) (string, int, error) {
+	typ, options := service.DataSource()
+	source, err := datasource.Open(typ, options)
+	if err != nil {
+		fmt.Printf("failed to open data source of %s: %v\n", service.Table(), err)
+		return "", 0, errDataSource
+	}
+	defer source.Close()
+
+	columns, err := source.Columns(ctx)
+	if err != nil {
+		fmt.Printf("failed to read schema of %s: %v\n", service.Table(), err)
+		return "", 0, errDataSource
+	}
+	if err := q.Validate(columns); err != nil {
+		return "", 0, fmt.Errorf("invalid query on %s: %s", service.Table(), err)
+	}
+
+	result, err := datasource.Select(ctx, source, q)
+	if err != nil {
+		fmt.Printf("failed to read %s: %v\n", service.Table(), err)
+		return "", 0, errDataSource
+	}
+	if result.Rows == nil {
+		result.Rows = []datasource.Row{}
+	}
+	service.Masking.Apply(&result, strings.ReplaceAll(recipient, " ", ""))
+	data, err := json.Marshal(result)
+	if err != nil {
+		fmt.Printf("failed to encode %s: %v\n", service.Table(), err)
+		return "", 0, errDataSource
+	}
+	return string(data), len(result.Rows), nil
+}
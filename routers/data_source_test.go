@@ -0,0 +1,123 @@
+package routers
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"service-client/chaincodeservice"
+	"service-client/datasource"
+)
+
+func TestRowSecurity(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 4), map[string]interface{}{
+		"RowSecurity": []map[string]interface{}{{"Field": "region", "Attribute": "MSPID"}},
+	})
+	n.grant(t, serviceID, nil)
+
+	status, respData := n.fetch(t, serviceID, nil)
+	if status != http.StatusOK {
+		t.Fatalf("fetch_data: %d %v", status, respData)
+	}
+	rows := rowsOf(t, respData)
+	if len(rows) != 2 {
+		t.Fatalf("fetched %v, want the 2 rows of Org2MSP", rows)
+	}
+	for _, row := range rows {
+		if row["region"] != "Org2MSP" {
+			t.Fatalf("fetched a row of another MSP: %v", row)
+		}
+	}
+
+	// filters of the consumer are added to, not replacing, those of the rules
+	status, respData = n.fetch(t, serviceID, map[string]interface{}{
+		"Filters": []map[string]interface{}{{"Field": "region", "Op": "=", "Value": "Org1MSP"}},
+	})
+	if status != http.StatusOK {
+		t.Fatalf("fetch_data with filters: %d %v", status, respData)
+	}
+	if rows := rowsOf(t, respData); len(rows) != 0 {
+		t.Fatalf("filters got around row security: %v", rows)
+	}
+}
+
+func TestMasking(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 2), map[string]interface{}{
+		"Masking": map[string]interface{}{"Rules": []map[string]interface{}{
+			{"Column": "name", "Action": "hash"},
+			{"Column": "region", "Action": "drop"},
+		}},
+	})
+	n.grant(t, serviceID, nil)
+
+	status, respData := n.fetch(t, serviceID, nil)
+	if status != http.StatusOK {
+		t.Fatalf("fetch_data: %d %v", status, respData)
+	}
+	rows := rowsOf(t, respData)
+	if len(rows) != 2 {
+		t.Fatalf("fetched %v", rows)
+	}
+	for _, row := range rows {
+		if _, ok := row["region"]; ok {
+			t.Fatalf("dropped column released: %v", row)
+		}
+		if name, _ := row["name"].(string); len(name) != 64 {
+			t.Fatalf("name not hashed: %v", row)
+		}
+	}
+
+	// filtering on a masked column would probe its values
+	status, respData = n.fetch(t, serviceID, map[string]interface{}{
+		"Filters": []map[string]interface{}{{"Field": "name", "Op": "=", "Value": "name-1"}},
+	})
+	if status == http.StatusOK {
+		t.Fatalf("filter on a masked column succeeded: %v", respData)
+	}
+}
+
+func TestRestrictQueryNeedsVerifiedRecipient(t *testing.T) {
+	recipient := initiator{Identity: "x509::CN=consumer,OU=client::CN=ca", MSPID: "Org2MSP"}
+	rowSecurity := ServiceType{RowSecurity: []datasource.RowRule{{Field: "region", Attribute: datasource.AttributeMSPID}}}
+	hashed := ServiceType{Masking: &datasource.MaskingPolicy{Version: 1, Secret: "s", Rules: []datasource.MaskRule{{Column: "name", Action: datasource.MaskHash}}}}
+	tokenized := ServiceType{Masking: &datasource.MaskingPolicy{Version: 1, Secret: "s", Rules: []datasource.MaskRule{{Column: "name", Action: datasource.MaskTokenize}}}}
+
+	for _, service := range []ServiceType{rowSecurity, hashed} {
+		if _, err := restrictQuery(service, datasource.Query{}, recipient); err == nil {
+			t.Errorf("policies of %+v applied to a claimed identity", service)
+		}
+	}
+	// the same for every recipient, so nothing to claim
+	if _, err := restrictQuery(tokenized, datasource.Query{}, recipient); err != nil {
+		t.Errorf("tokenized service refused: %v", err)
+	}
+
+	recipient.Verified = true
+	q, err := restrictQuery(rowSecurity, datasource.Query{}, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Filters) != 1 || q.Filters[0].Value != "Org2MSP" {
+		t.Fatalf("row filters %+v", q.Filters)
+	}
+}
+
+func TestUnreadableSourceIsNotGranted(t *testing.T) {
+	n := newTestNetwork(t)
+	path := newTestTable(t, 3)
+	serviceID := n.publish(t, path, nil)
+	n.grant(t, serviceID, nil)
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	status, respData := n.fetch(t, serviceID, nil)
+	if status == http.StatusOK {
+		t.Fatalf("fetch of an unreadable source succeeded: %v", respData)
+	}
+	if granted := n.queriesOf(t, serviceID, chaincodeservice.LegitimacyGranted); len(granted) != 0 {
+		t.Fatalf("failed fetch recorded as granted: %+v", granted)
+	}
+}
@@ -0,0 +1,161 @@
+package routers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"service-client/chaincodeservice"
+	"service-client/datasource"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxPreviewRows bounds the preview a service may allow
+const maxPreviewRows = 100
+
+// ServiceDescription tells an applicant what a service holds before it
+// applies: the columns as they are released, masking included, and a row
+// count that is an estimate for database tables.
+type ServiceDescription struct {
+	ServiceID string              `json:"ServiceID"`
+	Table     string              `json:"Table"`
+	Columns   []datasource.Column `json:"Columns"`
+	RowCount  int64               `json:"RowCount"`
+	// Preview holds up to the rows asked for and the service allows, masked
+	Preview []datasource.Row `json:"Preview,omitempty"`
+}
+
+// DescribeService answers without an access token, so it is rate limited
+// per client address, and a preview is only shown of services that allow
+// one and do not limit recipients to their own rows.
+//
+// Args: ServiceID, Preview (optional, number of rows)
+func (r *Routers) DescribeService() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if r.describeLimiter != nil && !r.describeLimiter.allow(c.ClientIP(), time.Now()) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many requests, try again later"})
+			return
+		}
+		serviceID := c.Query("ServiceID")
+		service, valid := r.service(serviceID)
+		if !valid {
+			err := fmt.Errorf("service not found in config: %s", serviceID)
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if service.state() == chaincodeservice.ServiceUnpublished {
+			err := fmt.Errorf("service %s is unpublished", serviceID)
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		service, err := r.resolveService(serviceID, service)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read credentials of service"})
+			return
+		}
+		preview := 0
+		if text := c.Query("Preview"); text != "" {
+			n, err := strconv.Atoi(text)
+			if err != nil || n < 0 {
+				err = fmt.Errorf("invalid Preview %q, expected a number of rows", text)
+				fmt.Printf("error: %v\n", err)
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			preview = n
+		}
+		preview = min(preview, service.PreviewRows)
+		// recipients limited to their own rows must not see anybody's
+		if len(service.RowSecurity) > 0 {
+			preview = 0
+		}
+
+		description, err := r.describeService(c, serviceID, service, preview)
+		if err != nil {
+			fmt.Printf("failed to describe %s: %v\n", serviceID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read data source"})
+			return
+		}
+		c.JSON(http.StatusOK, description)
+	}
+}
+
+func (r *Routers) describeService(c *gin.Context, serviceID string, service ServiceType, preview int) (ServiceDescription, error) {
+	ctx := c.Request.Context()
+	description := ServiceDescription{ServiceID: serviceID, Table: service.Table()}
+	typ, options := service.DataSource()
+	source, err := datasource.Open(typ, options)
+	if err != nil {
+		return description, err
+	}
+	defer source.Close()
+
+	columns, err := source.Columns(ctx)
+	if err != nil {
+		return description, err
+	}
+	description.RowCount, err = datasource.Count(ctx, source)
+	if err != nil {
+		return description, err
+	}
+
+	result := datasource.Result{Schema: columns}
+	if preview > 0 {
+		rows, err := source.Stream(ctx, datasource.Query{}, 0)
+		if err != nil {
+			return description, err
+		}
+		defer rows.Close()
+		for len(result.Rows) < preview && rows.Next() {
+			result.Rows = append(result.Rows, rows.Row())
+		}
+		if err := rows.Err(); err != nil {
+			return description, err
+		}
+	}
+	// nobody in particular is the recipient of a preview
+	service.Masking.Apply(&result, "")
+	description.Columns, description.Preview = result.Schema, result.Rows
+	return description, nil
+}
+
+// IDescribeService asks the publisher of a service to describe it.
+//
+// Args: ServiceID, PublisherURL, Preview (optional, number of rows)
+func (r *Routers) IDescribeService() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		query := url.Values{"ServiceID": {c.Query("ServiceID")}}
+		if preview := c.Query("Preview"); preview != "" {
+			query.Set("Preview", preview)
+		}
+		httpClient := &http.Client{Timeout: 30 * time.Second}
+		res, err := httpClient.Get(c.Query("PublisherURL") + "/describe_service?" + query.Encode())
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer res.Body.Close()
+		respBody, err := io.ReadAll(res.Body)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !json.Valid(respBody) {
+			err = fmt.Errorf("invalid response from publisher: %s", res.Status)
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(res.StatusCode, "application/json; charset=utf-8", respBody)
+	}
+}
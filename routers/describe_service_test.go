@@ -0,0 +1,121 @@
+package routers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"service-client/datasource"
+)
+
+// describe asks the publisher of n to describe serviceID with a preview of
+// the rows given, none if empty.
+func (n *testNetwork) describe(t *testing.T, serviceID string, preview string) (int, map[string]interface{}) {
+	t.Helper()
+	query := url.Values{"ServiceID": {serviceID}}
+	if preview != "" {
+		query.Set("Preview", preview)
+	}
+	return get(t, n.publisher.url+"/describe_service?"+query.Encode())
+}
+
+func TestDescribeService(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), map[string]interface{}{
+		"PreviewRows": 2,
+		"Masking": map[string]interface{}{"Rules": []map[string]interface{}{
+			{"Column": "name", "Action": "hash"},
+			{"Column": "region", "Action": "drop"},
+		}},
+	})
+
+	// the preview is bounded by the rows the service allows, and masked
+	// like the data it releases
+	status, respData := n.describe(t, serviceID, "5")
+	if status != http.StatusOK {
+		t.Fatalf("describe_service: %d %v", status, respData)
+	}
+	if respData["RowCount"] != float64(3) {
+		t.Fatalf("described %v rows, want 3", respData["RowCount"])
+	}
+	// the schema is discovered from the table, less the dropped column
+	columns, _ := respData["Columns"].([]interface{})
+	if len(columns) != 2 {
+		t.Fatalf("described columns %v, want id and name", columns)
+	}
+	for i, want := range [][2]string{{"id", datasource.TypeInteger}, {"name", datasource.TypeString}} {
+		column, _ := columns[i].(map[string]interface{})
+		if column["Name"] != want[0] || column["Type"] != want[1] {
+			t.Fatalf("column %d described as %v, want %s %s", i, column, want[0], want[1])
+		}
+	}
+	preview, _ := respData["Preview"].([]interface{})
+	if len(preview) != 2 {
+		t.Fatalf("previewed %v, want 2 rows", preview)
+	}
+	for _, item := range preview {
+		row, _ := item.(map[string]interface{})
+		if _, ok := row["region"]; ok {
+			t.Fatalf("dropped column previewed: %v", row)
+		}
+		if name, _ := row["name"].(string); len(name) != 64 {
+			t.Fatalf("name not hashed in preview: %v", row)
+		}
+	}
+
+	if status, respData := n.describe(t, serviceID, ""); status != http.StatusOK || respData["Preview"] != nil {
+		t.Fatalf("describe_service without preview: %d %v", status, respData)
+	}
+	if status, respData := n.describe(t, serviceID, "-1"); status != http.StatusBadRequest {
+		t.Fatalf("describe_service with a negative preview: %d %v", status, respData)
+	}
+	if status, respData := n.describe(t, "no-such-service", ""); status != http.StatusBadRequest {
+		t.Fatalf("describe_service of an unknown service: %d %v", status, respData)
+	}
+
+	// recipients limited to their own rows must not see anybody's
+	restricted := n.publish(t, newTestTable(t, 3), map[string]interface{}{
+		"PreviewRows": 2,
+		"RowSecurity": []map[string]interface{}{{"Field": "region", "Attribute": "MSPID"}},
+	})
+	if status, respData := n.describe(t, restricted, "2"); status != http.StatusOK || respData["Preview"] != nil {
+		t.Fatalf("describe_service of a service with row security: %d %v", status, respData)
+	}
+}
+
+func TestDescribeServiceRateLimit(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 1), nil)
+	n.publisher.r.describeLimiter = newRateLimiter(2)
+
+	for i := 0; i < 2; i++ {
+		if status, respData := n.describe(t, serviceID, ""); status != http.StatusOK {
+			t.Fatalf("describe_service %d: %d %v", i, status, respData)
+		}
+	}
+	if status, respData := n.describe(t, serviceID, ""); status != http.StatusTooManyRequests {
+		t.Fatalf("describe_service past the limit: %d %v", status, respData)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(60)
+	now := time.Now()
+	for i := 0; i < 60; i++ {
+		if !l.allow("a", now) {
+			t.Fatalf("request %d of a burst refused", i)
+		}
+	}
+	if l.allow("a", now) {
+		t.Fatal("request past the burst allowed")
+	}
+	// other clients have buckets of their own
+	if !l.allow("b", now) {
+		t.Fatal("request of another client refused")
+	}
+	// a bucket refills one token every interval, up to the burst
+	if !l.allow("a", now.Add(time.Second)) || l.allow("a", now.Add(time.Second)) {
+		t.Fatal("bucket did not refill one token a second")
+	}
+}
@@ -0,0 +1,195 @@
+package routers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"service-client/datasource"
+	"service-client/export"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A fetched data set is kept under Config.DownloadDir as
+// "<ServiceID>-<QueryID>.ndjson", one row per line, next to its schema in
+// "<ServiceID>-<QueryID>.schema.json", and converted on download.
+
+var downloadName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+func (r *Routers) downloadDir() (string, error) {
+	dir := r.Config.DownloadDir
+	if dir == "" {
+		dir = "downloads"
+	}
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// datasetPath names the files of a fetched data set, without extension.
+func (r *Routers) datasetPath(serviceID string, queryID string) (string, error) {
+	if !downloadName.MatchString(serviceID) {
+		return "", fmt.Errorf("invalid service ID %q", serviceID)
+	}
+	if !downloadName.MatchString(queryID) {
+		return "", fmt.Errorf("invalid query ID %q", queryID)
+	}
+	dir, err := r.downloadDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, serviceID+"-"+queryID), nil
+}
+
+// saveDataset stores a fetched result, its rows given as a JSON array. A
+// result without a schema, from a publisher that does not send one, gets
+// the schema inferred from its values.
+func (r *Routers) saveDataset(serviceID string, queryID string, rows string, schema []datasource.Column) (string, error) {
+	base, err := r.datasetPath(serviceID, queryID)
+	if err != nil {
+		return "", err
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal([]byte(rows), &raw); err != nil {
+		return "", fmt.Errorf("invalid rows: %w", err)
+	}
+	if schema == nil {
+		var decoded []datasource.Row
+		decoder := json.NewDecoder(bytes.NewReader([]byte(rows)))
+		decoder.UseNumber()
+		if err := decoder.Decode(&decoded); err != nil {
+			return "", fmt.Errorf("invalid rows: %w", err)
+		}
+		schema = datasource.InferColumns(decoded)
+	}
+
+	var data bytes.Buffer
+	for _, row := range raw {
+		data.Write(row)
+		data.WriteByte('\n')
+	}
+	if err := writeSchema(base, schema); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(base+".ndjson", data.Bytes(), 0600); err != nil {
+		return "", err
+	}
+	return base + ".ndjson", nil
+}
+
+func writeSchema(base string, schema []datasource.Column) error {
+	data, err := json.MarshalIndent(schema, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(base+".schema.json", data, 0600)
+}
+
+// openDataset returns the schema of a stored data set and a reader of its
+// rows, which must be closed.
+func (r *Routers) openDataset(serviceID string, queryID string) ([]datasource.Column, export.Reader, io.Closer, error) {
+	base, err := r.datasetPath(serviceID, queryID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var schema []datasource.Column
+	data, err := os.ReadFile(base + ".schema.json")
+	if err == nil {
+		err = json.Unmarshal(data, &schema)
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("no data set fetched for %s with query %s", serviceID, queryID)
+	}
+	file, err := os.Open(base + ".ndjson")
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("no data set fetched for %s with query %s", serviceID, queryID)
+	}
+
+	reader := bufio.NewReader(file)
+	line := 0
+	next := func() (datasource.Row, error) {
+		for {
+			data, err := reader.ReadBytes('\n')
+			if err == io.EOF && len(data) == 0 {
+				return nil, io.EOF
+			}
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			line++
+			if len(bytes.TrimSpace(data)) == 0 {
+				continue
+			}
+			var row datasource.Row
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.UseNumber()
+			if err := decoder.Decode(&row); err != nil {
+				return nil, fmt.Errorf("%s line %d: %w", file.Name(), line, err)
+			}
+			return row, nil
+		}
+	}
+	return schema, next, file, nil
+}
+
+// sendDataset sends a stored data set in format, as an attachment named
+// after the service and the query. The export is written to a temporary
+// file first, so that a value that does not convert fails the request
+// rather than truncating the download.
+func (r *Routers) sendDataset(c *gin.Context, serviceID string, queryID string, format string) {
+	if err := export.Check(format); err != nil {
+		fmt.Printf("error: %v\n", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	schema, next, closer, err := r.openDataset(serviceID, queryID)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	defer closer.Close()
+
+	dir, err := r.downloadDir()
+	var file *os.File
+	if err == nil {
+		file, err = os.CreateTemp(dir, "export-*")
+	}
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer os.Remove(file.Name())
+	writer := bufio.NewWriter(file)
+	err = export.Write(writer, format, schema, next)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to export as %s: %w", format, err)
+		fmt.Printf("error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", export.ContentType(format))
+	c.FileAttachment(file.Name(), serviceID+"-"+queryID+export.Extension(format))
+}
+
+// Args: ServiceID, QueryID, format (optional, one of json ndjson csv arrow
+// parquet, defaults to json)
+func (r *Routers) IDownload() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", export.FormatJSON)
+		r.sendDataset(c, c.Query("ServiceID"), c.Query("QueryID"), format)
+	}
+}
@@ -0,0 +1,88 @@
+package routers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"service-client/export"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+)
+
+func TestDownload(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	n.grant(t, serviceID, nil)
+	status, respData := n.fetch(t, serviceID, nil)
+	if status != http.StatusOK {
+		t.Fatalf("fetch_data: %d %v", status, respData)
+	}
+	queryID, _ := respData["queryID"].(string)
+	download := func(serviceID, queryID, format string) (*http.Response, []byte) {
+		t.Helper()
+		query := url.Values{"ServiceID": {serviceID}, "QueryID": {queryID}}
+		if format != "" {
+			query.Set("format", format)
+		}
+		res, err := http.Get(n.consumer.url + "/download?" + query.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, body
+	}
+
+	for _, format := range []string{"", export.FormatJSON, export.FormatNDJSON, export.FormatCSV, export.FormatArrow, export.FormatParquet} {
+		res, body := download(serviceID, queryID, format)
+		if format == "" {
+			format = export.FormatJSON
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("download as %s: %d %s", format, res.StatusCode, body)
+		}
+		if contentType := res.Header.Get("Content-Type"); contentType != export.ContentType(format) {
+			t.Errorf("%s downloaded as %s", format, contentType)
+		}
+		_, params, err := mime.ParseMediaType(res.Header.Get("Content-Disposition"))
+		if want := serviceID + "-" + queryID + export.Extension(format); err != nil || params["filename"] != want {
+			t.Errorf("%s downloaded as file %q, want %q: %v", format, params["filename"], want, err)
+		}
+		if format == export.FormatCSV {
+			records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+			if err != nil || len(records) != 4 || records[0][0] != "id" || records[1][1] != "name-1" {
+				t.Errorf("CSV download %q, %v", records, err)
+			}
+		}
+		// columns are typed by the schema the publisher released
+		if format == export.FormatArrow {
+			reader, err := ipc.NewReader(bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fields := reader.Schema().Fields(); len(fields) != 3 || fields[0].Type.ID() != arrow.INT64 || fields[1].Type.ID() != arrow.STRING {
+				t.Errorf("Arrow download typed as %v", reader.Schema())
+			}
+			reader.Release()
+		}
+	}
+
+	for name, args := range map[string][3]string{
+		"an unknown format":  {serviceID, queryID, "xlsx"},
+		"an unknown query":   {serviceID, "Org2MSP-0", ""},
+		"a path as query ID": {serviceID, "../" + queryID, ""},
+	} {
+		if res, body := download(args[0], args[1], args[2]); res.StatusCode == http.StatusOK {
+			t.Errorf("download of %s succeeded: %s", name, body)
+		}
+	}
+}
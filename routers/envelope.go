@@ -0,0 +1,158 @@
+package routers
+
+import (
+	"crypto"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"service-client/envelope"
+	"service-client/keys"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Data is delivered in an envelope, see package envelope, to initiators that
+// ask for one in the Envelope of their request. The envelope is bound to the
+// QueryID, the ServiceID, the identity of the initiator and the digest of the
+// data. In base64 framing it is the data of the JSON response, in binary
+// framing the body of the response, with the rest in headers. Initiators
+// that predate envelopes get the data in hex ECIES, see EnCryptByEcies.
+// Streamed results are encrypted chunk by chunk instead, see sealChunk.
+
+const (
+	envelopeHeader = "X-Envelope"
+	queryIDHeader  = "X-Query-ID"
+	digestHeader   = "X-Data-Digest"
+	queryHeader    = "X-Query"
+	binaryType     = "application/octet-stream"
+)
+
+// envelopeOptions are what an initiator asks of its envelope
+type envelopeOptions struct {
+	Cipher  string
+	Framing string
+}
+
+// parseEnvelopeOptions reads the Envelope of a request, {Cipher, Framing}
+// with both optional, nil for requests without one.
+func parseEnvelopeOptions(httpData map[string]interface{}) (*envelopeOptions, error) {
+	raw, ok := httpData["Envelope"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	fields, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid Envelope, expected {Cipher, Framing}")
+	}
+	options := &envelopeOptions{Cipher: envelope.AES256GCM, Framing: envelope.Base64}
+	if cipher, ok := fields["Cipher"].(string); ok && cipher != "" {
+		options.Cipher = cipher
+	}
+	if framing, ok := fields["Framing"].(string); ok && framing != "" {
+		options.Framing = framing
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+func (o envelopeOptions) validate() error {
+	if !envelope.ValidCipher(o.Cipher) {
+		return fmt.Errorf("unsupported Cipher %q, expected %s or %s", o.Cipher, envelope.AES256GCM, envelope.ChaCha20Poly1305)
+	}
+	if o.Framing != envelope.Binary && o.Framing != envelope.Base64 {
+		return fmt.Errorf("unsupported Framing %q, expected %s or %s", o.Framing, envelope.Binary, envelope.Base64)
+	}
+	return nil
+}
+
+// sendEnvelope delivers data to recipient, holding publicKey, as the result
+// of a query.
+func sendEnvelope(c *gin.Context, options envelopeOptions, data string, publicKey crypto.PublicKey, context envelope.Context, queryText string) {
+	sealed, err := envelope.Seal(publicKey, []byte(data), options.Cipher, context)
+	if err == nil {
+		sealed, err = envelope.Encode(sealed, options.Framing)
+	}
+	if err != nil {
+		fmt.Printf("failed to seal data of %s: %v\n", context.QueryID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt data", "queryID": context.QueryID})
+		return
+	}
+	if options.Framing == envelope.Binary {
+		c.Header(envelopeHeader, fmt.Sprint(envelope.Version))
+		c.Header(queryIDHeader, context.QueryID)
+		c.Header(digestHeader, context.Digest)
+		c.Header(queryHeader, url.QueryEscape(queryText))
+		c.Data(http.StatusOK, binaryType, sealed)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("data requested: %s", context.Digest), "queryID": context.QueryID, "query": queryText,
+		"data": string(sealed), "digest": context.Digest, "Envelope": envelope.Version})
+}
+
+// openDelivery decrypts the data of a response to a query of serviceID. I
+// always ask for an envelope, so a response without one is refused rather
+// than read as from a publisher that predates them: it may have been
+// stripped. It returns the data and the response, from its headers in binary
+// framing.
+func (r *Routers) openDelivery(res *http.Response, body []byte, serviceID string) (string, map[string]interface{}, error) {
+	respData := map[string]interface{}{}
+	framing := envelope.Base64
+	var sealed []byte
+	if strings.HasPrefix(res.Header.Get("Content-Type"), binaryType) {
+		query, _ := url.QueryUnescape(res.Header.Get(queryHeader))
+		respData["queryID"] = res.Header.Get(queryIDHeader)
+		respData["digest"] = res.Header.Get(digestHeader)
+		respData["query"] = query
+		framing, sealed = envelope.Binary, body
+	} else {
+		if err := json.Unmarshal(body, &respData); err != nil {
+			return "", nil, err
+		}
+		data, ok := respData["data"].(string)
+		if !ok {
+			return "", nil, fmt.Errorf("no data in response")
+		}
+		if respData["Envelope"] == nil {
+			return "", nil, fmt.Errorf("response is not in the envelope asked for")
+		}
+		sealed = []byte(data)
+	}
+
+	sealed, err := envelope.Decode(sealed, framing)
+	if err != nil {
+		return "", nil, err
+	}
+	queryID, _ := respData["queryID"].(string)
+	digest, _ := respData["digest"].(string)
+	plaintext, err := envelope.Open(r.OrgSetup.PrivateKey, sealed, envelope.Context{
+		QueryID:   queryID,
+		ServiceID: serviceID,
+		Recipient: strings.ReplaceAll(r.OrgSetup.Identity, " ", ""),
+		Digest:    digest,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	// the digest is what the query was recorded with
+	if envelope.Digest(plaintext) != digest {
+		return "", nil, fmt.Errorf("data does not match its digest %s", digest)
+	}
+	return string(plaintext), respData, nil
+}
+
+// encryptData encrypts data in hex for initiators that predate envelopes,
+// whatever the algorithm of their key, see keys.Encrypt. For ECDSA keys it is
+// EnCryptByEcies.
+func (r *Routers) encryptData(srcData string, publicKey crypto.PublicKey) (string, error) {
+	encryptBytes, err := keys.Encrypt(publicKey, []byte(srcData), nil)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(encryptBytes), nil
+}
@@ -0,0 +1,56 @@
+package routers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"service-client/envelope"
+)
+
+func TestEnvelopes(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	n.grant(t, serviceID, nil)
+
+	for _, cipher := range []string{envelope.AES256GCM, envelope.ChaCha20Poly1305} {
+		for _, framing := range []string{envelope.Binary, envelope.Base64} {
+			status, respData := n.fetch(t, serviceID, map[string]interface{}{"Cipher": cipher, "Framing": framing})
+			if status != http.StatusOK {
+				t.Fatalf("fetch in %s/%s: %d %v", cipher, framing, status, respData)
+			}
+			if rows := rowsOf(t, respData); len(rows) != 3 {
+				t.Fatalf("fetched %v in %s/%s", rows, cipher, framing)
+			}
+		}
+	}
+
+	if status, respData := n.fetch(t, serviceID, map[string]interface{}{"Cipher": "rot13"}); status == http.StatusOK {
+		t.Fatalf("fetch with an unknown cipher succeeded: %v", respData)
+	}
+}
+
+// A publisher that answers in the clear, or in the legacy encryption, is
+// refused once an envelope was asked for.
+func TestUnenvelopedResponse(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 1), nil)
+
+	publisher := gin.New()
+	publisher.POST("/request_data", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"data": `[{"id":1}]`, "queryID": "Org1MSP-q"})
+	})
+	server := httptest.NewServer(publisher)
+	defer server.Close()
+
+	status, respData := post(t, n.consumer.url+"/fetch_data", map[string]interface{}{
+		"ServiceID":    serviceID,
+		"PublisherURL": server.URL,
+	})
+	if message, _ := respData["error"].(string); status == http.StatusOK || !strings.Contains(message, "envelope") {
+		t.Fatalf("unenveloped response accepted: %d %v", status, respData)
+	}
+}
@@ -6,10 +6,23 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+
+	"service-client/datasource"
+	"service-client/envelope"
+	"service-client/export"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Args: ServiceID, PublisherURL, Columns (optional), Filters (optional,
+// [{Field, Op, Value}] with Op one of = != < <= > >= in contains), Stream
+// (optional, deliver in chunks to a file under DownloadDir), PageSize
+// (optional, rows per chunk), Cursor (optional, row to continue a broken
+// stream from), format (optional, one of json ndjson csv arrow parquet, to
+// receive the data set as a file instead of JSON; it can also be downloaded
+// later from /download), Cipher and Framing (optional, of the envelope the
+// data is delivered in, see sendEnvelope)
 func (r *Routers) FetchData() func(c *gin.Context) {
 	return func(c *gin.Context) {
 		var data map[string]interface{}
@@ -19,18 +32,48 @@ func (r *Routers) FetchData() func(c *gin.Context) {
 			return
 		}
 
+		stream, _ := data["Stream"].(bool)
+		format, _ := data["format"].(string)
+		delete(data, "format")
+		cursor, _, err := parseStreamArgs(data)
+		if err == nil && format != "" {
+			err = export.Check(format)
+		}
+		delivery := envelopeOptions{Cipher: envelope.AES256GCM, Framing: envelope.Base64}
+		if cipher, ok := data["Cipher"].(string); ok && cipher != "" {
+			delivery.Cipher = cipher
+		}
+		if framing, ok := data["Framing"].(string); ok && framing != "" {
+			delivery.Framing = framing
+		}
+		delete(data, "Cipher")
+		delete(data, "Framing")
+		if err == nil {
+			err = delivery.validate()
+		}
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
 		data["InitiatorID"] = r.OrgSetup.Identity
 		data["InitiatorURL"] = r.MyURL
-		MyPubKey := r.QueryContract.OrgSetup.PublicKey
-		data["InitiatorPublicKeyX"] = MyPubKey.X.Text(10)
-		data["InitiatorPublicKeyY"] = MyPubKey.Y.Text(10)
+		if err := r.presentInitiator(data); err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		data["Envelope"] = map[string]interface{}{"Cipher": delivery.Cipher, "Framing": delivery.Framing}
 		data["InitiatorIdentity"] = r.OrgSetup.Identity
+		data["InitiatorMSPID"] = r.OrgSetup.MSPID
 		PublisherURL := data["PublisherURL"].(string)
 
 		sendData, err := json.Marshal(data)
 		if err != nil {
 			fmt.Printf("error: %v\n", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
 
 		req, err := http.NewRequest("POST", PublisherURL+"/request_data", bytes.NewBuffer(sendData))
@@ -41,7 +84,14 @@ func (r *Routers) FetchData() func(c *gin.Context) {
 		}
 		refererURL := r.MyURL
 		req.Header.Set("Referer", refererURL)
+		if err := r.signRequest(req, sendData); err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 
+		// the publisher may challenge me before it answers
+		defer r.pending.begin(PublisherURL)()
 		httpClient := &http.Client{}
 		res, err := httpClient.Do(req)
 		if err != nil {
@@ -63,7 +113,7 @@ func (r *Routers) FetchData() func(c *gin.Context) {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
-			fmt.Printf("error: %v\n", err)
+			fmt.Printf("error: publisher answered %d: %v\n", res.StatusCode, respData["error"])
 			c.JSON(http.StatusBadRequest, gin.H{"error": respData["error"]})
 			return
 		}
@@ -71,28 +121,73 @@ func (r *Routers) FetchData() func(c *gin.Context) {
 
 		fmt.Println("======================== fetch_data receive response ========================")
 
+		if stream {
+			query, err := parseQuery(data)
+			if err != nil {
+				fmt.Printf("error: %v\n", err)
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			r.receiveStream(c, res, fmt.Sprint(data["ServiceID"]), query, cursor, format)
+			return
+		}
+
 		respBody, err := io.ReadAll(res.Body)
 		if err != nil {
 			fmt.Println("fetch_data err on ioutil.ReadAll()", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		var respData map[string]interface{}
-		err = json.Unmarshal(respBody, &respData)
+		serviceID := fmt.Sprint(data["ServiceID"])
+		responsdata, respData, err := r.openDelivery(res, respBody, serviceID)
 		if err != nil {
-			fmt.Println("fetch_data err on json.Unmarshal()", err)
+			fmt.Println("fetch_data failed in decrypt the data:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		rows, schema, err := decodeResult(responsdata)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		retdata := respData["data"].(string)
-		// TODO: decrypt data
-		responsdata, err := r.DeCryptByEcies(retdata, r.QueryContract.OrgSetup.PrivateKey)
+
+		queryID, _ := respData["queryID"].(string)
+		file, err := r.saveDataset(serviceID, queryID, rows, schema)
 		if err != nil {
-			fmt.Println("fetch_data failed in decrypt the data.")
-			panic(err)
+			fmt.Printf("failed to store data set of %s: %v\n", queryID, err)
+		}
+		if format != "" {
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "queryID": queryID})
+				return
+			}
+			r.sendDataset(c, serviceID, queryID, format)
+			return
 		}
 
-		c.JSON(200, gin.H{"data": responsdata})
+		c.JSON(200, gin.H{"data": rows, "schema": schema, "queryID": respData["queryID"], "query": respData["query"], "file": file})
 
 	}
 }
+
+// decodeResult splits a decrypted result into the JSON text of its rows and
+// its schema. Numbers are kept as written so that large integers survive.
+// Publishers that predate typed results send a bare array of rows, which is
+// passed through without a schema.
+func decodeResult(plaintext string) (string, []datasource.Column, error) {
+	if strings.HasPrefix(strings.TrimSpace(plaintext), "[") {
+		return plaintext, nil, nil
+	}
+	var result struct {
+		Schema []datasource.Column `json:"Schema"`
+		Rows   json.RawMessage     `json:"Rows"`
+	}
+	decoder := json.NewDecoder(strings.NewReader(plaintext))
+	decoder.UseNumber()
+	if err := decoder.Decode(&result); err != nil {
+		return "", nil, fmt.Errorf("invalid result: %w", err)
+	}
+	return string(result.Rows), result.Schema, nil
+}
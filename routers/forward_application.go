@@ -10,7 +10,8 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// Args: PublisherURL
+// Args: ServiceID, ServiceName, PublisherURL, Renewal (optional, asks to
+// extend a time-limited grant)
 func (r *Routers) ForwardApplication() func(c *gin.Context) {
 	return func(c *gin.Context) {
 		var data map[string]interface{}
@@ -19,6 +20,7 @@ func (r *Routers) ForwardApplication() func(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		renewal, _ := data["Renewal"].(bool)
 		newApplication := ApplicationAnswer{
 			InitiatorID:     r.OrgSetup.Identity,
 			InitiatorURL:    r.MyURL,
@@ -26,20 +28,17 @@ func (r *Routers) ForwardApplication() func(c *gin.Context) {
 			ServiceName:     data["ServiceName"].(string),
 			PublisherURL:    data["PublisherURL"].(string),
 			Status:          0,
+			Renewal:         renewal,
 			ApplicationTime: time.Now().Format("2006-01-02 15:04:05")}
 		r.MyApplication = append(r.MyApplication, newApplication)
 		PublisherURL := data["PublisherURL"].(string)
 		data["InitiatorID"] = r.OrgSetup.Identity
 		data["InitiatorURL"] = r.MyURL
-		// encode pubKey
-		// encodedPubKey, err := encodePublicKey(MyPubKey)
-		// if err != nil {
-		// 	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		// 	return
-		// }
-		//data["InitiatorPublicKeyCurve"] = MyPubKey.Curve.Params()
-		data["InitiatorPublicKeyX"] = r.QueryContract.OrgSetup.PublicKey.X.Text(10)
-		data["InitiatorPublicKeyY"] = r.QueryContract.OrgSetup.PublicKey.Y.Text(10)
+		if err := r.presentInitiator(data); err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 
 		sendData, err := json.Marshal(data)
 		if err != nil {
@@ -64,8 +63,15 @@ func (r *Routers) ForwardApplication() func(c *gin.Context) {
 		// 	return
 		// }
 		req.Header.Set("Referer", refererURL)
+		if err := r.signRequest(req, sendData); err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 		fmt.Println("forward_application set header:", refererURL)
 
+		// the publisher may challenge me before it answers
+		defer r.pending.begin(PublisherURL)()
 		httpClient := &http.Client{}
 		res, err := httpClient.Do(req)
 		if err != nil {
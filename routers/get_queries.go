@@ -0,0 +1,70 @@
+package routers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"service-client/chaincodeservice"
+	"service-client/indexer"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultQueryPageSize = 20
+	maxQueryPageSize     = 200
+)
+
+// List query records page by page, from the index. Args (query string):
+// ServiceID, InitiatorID, Legitimacy, From, To (Unix seconds), PageSize,
+// Bookmark
+func (r *Routers) IGetQueries() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		// pages cut from separate reads of the ledger would not add up
+		if r.Index == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"queries": nil, "error": "listing queries needs the index, set IndexPath in the config"})
+			return
+		}
+		filter := chaincodeservice.QueryFilter{
+			ServiceID:   c.Query("ServiceID"),
+			InitiatorID: c.Query("InitiatorID"),
+			Legitimacy:  c.Query("Legitimacy"),
+		}
+		var err error
+		if from := c.Query("From"); from != "" {
+			if filter.From, err = strconv.ParseInt(from, 10, 64); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid From: %s", from)})
+				return
+			}
+		}
+		if to := c.Query("To"); to != "" {
+			if filter.To, err = strconv.ParseInt(to, 10, 64); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid To: %s", to)})
+				return
+			}
+		}
+		pageSize := defaultQueryPageSize
+		if size := c.Query("PageSize"); size != "" {
+			pageSize, err = strconv.Atoi(size)
+			if err != nil || pageSize <= 0 || pageSize > maxQueryPageSize {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("PageSize must be between 1 and %d", maxQueryPageSize)})
+				return
+			}
+		}
+
+		page, err := r.Index.FindQueries(filter, pageSize, c.Query("Bookmark"))
+		if errors.Is(err, indexer.ErrInvalidBookmark) {
+			c.JSON(http.StatusBadRequest, gin.H{"queries": nil, "error": err.Error()})
+			return
+		}
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"queries": nil, "error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"queries": page.Queries, "bookmark": page.Bookmark, "index": r.indexStatus()})
+	}
+}
@@ -0,0 +1,54 @@
+package routers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestGetQueries(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	n.grant(t, serviceID, nil)
+	for i := 0; i < 3; i++ {
+		if status, respData := n.fetch(t, serviceID, nil); status != http.StatusOK {
+			t.Fatalf("fetch_data: %d %v", status, respData)
+		}
+	}
+	n.settle(t)
+
+	seen := make(map[string]bool)
+	bookmark := ""
+	for pages := 1; ; pages++ {
+		query := url.Values{"ServiceID": {serviceID}, "PageSize": {"2"}, "Bookmark": {bookmark}}
+		status, respData := get(t, n.publisher.url+"/get_queries?"+query.Encode())
+		if status != http.StatusOK || respData["index"] == nil {
+			t.Fatalf("get_queries: %d %v", status, respData)
+		}
+		queries, _ := respData["queries"].([]interface{})
+		for _, item := range queries {
+			record, _ := item.(map[string]interface{})
+			queryID, _ := record["QueryID"].(string)
+			if seen[queryID] || record["ServiceID"] != serviceID {
+				t.Fatalf("query listed as %v", record)
+			}
+			seen[queryID] = true
+		}
+		bookmark, _ = respData["bookmark"].(string)
+		if bookmark == "" {
+			if len(seen) != 3 || pages != 2 {
+				t.Fatalf("listed %d queries in %d pages, want 3 in 2", len(seen), pages)
+			}
+			break
+		}
+	}
+
+	if status, respData := get(t, n.publisher.url+"/get_queries?Bookmark=7"); status != http.StatusBadRequest {
+		t.Fatalf("get_queries with a malformed bookmark: %d %v", status, respData)
+	}
+	n.publisher.r.Index.Close()
+	n.publisher.r.Index = nil
+	if status, respData := get(t, n.publisher.url+"/get_queries"); status != http.StatusBadRequest {
+		t.Fatalf("get_queries without the index: %d %v", status, respData)
+	}
+}
@@ -3,7 +3,9 @@ package routers
 import (
 	"fmt"
 	"net/http"
-	"strings"
+	"time"
+
+	"service-client/chaincodeservice"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,45 +19,112 @@ type ViewService struct {
 	Table        string `json:"Table"`
 	Approved     bool   `json:"Approved"`
 	NoAccess     bool   `json:"NoAccess"`
+	ExpiresAt    int64  `json:"ExpiresAt"` // Unix seconds, 0 if access does not expire
+	Quota        *Quota `json:"Quota"`     // nil if access is not limited
+	State        string `json:"State"`     // active or deprecated
+}
+
+// Quota is the usage quota of my grant on a service. A remaining count of -1
+// means the grant does not limit it.
+type Quota struct {
+	MaxFetches       int   `json:"MaxFetches"`
+	MaxRows          int   `json:"MaxRows"`
+	QuotaPeriod      int64 `json:"QuotaPeriod"`
+	RemainingFetches int   `json:"RemainingFetches"`
+	RemainingRows    int   `json:"RemainingRows"`
 }
 
 func (r *Routers) IGetServices() func(c *gin.Context) {
 	return func(c *gin.Context) {
-		serviceURIs, err := r.ServiceContract.GetServices()
+		serviceURIs, err := r.getServices()
 		if err != nil {
 			fmt.Printf("Error: %s\n", err.Error())
 			c.JSON(http.StatusInternalServerError, gin.H{"services": nil, "error": err.Error()})
 			return
 		}
 
-		var services []ViewService
+		// a service with two registry tokens is in the state of the later
+		records := make(map[string]chaincodeservice.ServiceRecord, len(serviceURIs))
+		var order []string
 		for _, serviceURI := range serviceURIs {
-			splitted := strings.Split(serviceURI, "|")
-			serviceID, serviceURL := splitted[0], splitted[1]
+			record, err := chaincodeservice.ParseService(serviceURI)
+			if err != nil {
+				fmt.Printf("Error: %s\n", err.Error())
+				continue
+			}
+			if _, seen := records[record.ServiceID]; !seen {
+				order = append(order, record.ServiceID)
+			}
+			records[record.ServiceID] = record
+		}
+
+		// my queries are read once for the quotas of all services
+		var myQueries []chaincodeservice.Query
+		myQueriesRead := false
 
-			access, err := r.ServiceContract.HasAccessToService(serviceID)
+		var services []ViewService
+		for _, serviceID := range order {
+			record := records[serviceID]
+			if !record.Listed() {
+				continue
+			}
+			serviceURL := record.PublisherURL
+
+			access, err := r.hasAccessToService(serviceID)
 			if err != nil {
 				access = false
 			}
 
-			publisher, err := r.ServiceContract.OwnerMSPID()
+			var expiresAt int64
+			var quota *Quota
+			if grant, ok, err := r.accessGrantOf(r.OrgSetup.Identity, serviceID); err == nil && ok {
+				expiresAt = grant.ExpiresAt
+				if grant.Limited() && !myQueriesRead {
+					myQueries, err = r.grantedQueries(r.OrgSetup.Identity, chaincodeservice.QueryFilter{})
+					if err != nil {
+						fmt.Printf("Error: %s\n", err.Error())
+					}
+					myQueriesRead = err == nil
+				}
+				if myQueriesRead {
+					quota = quotaOf(grant, myQueries)
+				}
+			}
+
+			publisher, err := r.ownerMSPID()
 			if err != nil {
 				publisher = "Not available"
 			}
 
+			config, _ := r.service(serviceID)
 			s := ViewService{
-				ServiceName:  r.Config.Services[serviceID].Information.DisplayName,
+				ServiceName:  config.Information.DisplayName,
 				ServiceID:    serviceID,
-				Comment:      r.Config.Services[serviceID].Information.Description,
+				Comment:      config.Information.Description,
 				PublisherURL: serviceURL,
 				Publisher:    publisher,
-				Table:        r.Config.Services[serviceID].Credentials.DatabaseTable,
+				Table:        config.Table(),
 				Approved:     access,
 				NoAccess:     !access,
+				ExpiresAt:    expiresAt,
+				Quota:        quota,
+				State:        record.State,
 			}
 			services = append(services, s)
 		}
 
-		c.JSON(http.StatusOK, gin.H{"services": services})
+		c.JSON(http.StatusOK, gin.H{"services": services, "index": r.indexStatus()})
+	}
+}
+
+// quotaOf returns the quota left on grant after queries, nil if the grant is
+// not limited.
+func quotaOf(grant chaincodeservice.AccessGrant, queries []chaincodeservice.Query) *Quota {
+	if !grant.Limited() {
+		return nil
 	}
+	usage := usageIn(queries, grant.ServiceID, grant.QuotaSince(time.Now()))
+	quota := &Quota{MaxFetches: grant.MaxFetches, MaxRows: grant.MaxRows, QuotaPeriod: grant.QuotaPeriod}
+	quota.RemainingFetches, quota.RemainingRows = grant.Remaining(usage)
+	return quota
 }
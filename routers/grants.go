@@ -0,0 +1,145 @@
+package routers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"service-client/chaincodeservice"
+)
+
+const defaultGrantSweepInterval = 10 * time.Minute
+
+// SweepGrants takes back and burns the expired access tokens of my services
+// every Config.GrantSweepInterval for as long as the process runs.
+func (r *Routers) SweepGrants() {
+	interval := defaultGrantSweepInterval
+	if r.Config.GrantSweepInterval != "" {
+		parsed, err := time.ParseDuration(r.Config.GrantSweepInterval)
+		if err != nil || parsed <= 0 {
+			fmt.Printf("invalid GrantSweepInterval %q, sweeping every %s\n", r.Config.GrantSweepInterval, interval)
+		} else {
+			interval = parsed
+		}
+	}
+	go func() {
+		for {
+			r.sweepExpiredGrants(time.Now())
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// sweepExpiredGrants takes back and burns the access tokens of my services
+// that expired at now and records each of them as a query with
+// LegitimacyExpired. Tokens that cannot be taken back stay on chain, but
+// count as no access all the same.
+func (r *Routers) sweepExpiredGrants(now time.Time) {
+	for serviceID, service := range r.services() {
+		burned, err := r.ServiceContract.BurnExpired(serviceID, now)
+		if err != nil {
+			fmt.Printf("failed to sweep access tokens of %s: %s\n", serviceID, err)
+		}
+		for _, grant := range burned {
+			// the MSP of the owner is not kept with the grant
+			_, err := r.QueryContract.CreateQuery("", "", 0, grant.Owner, "", chaincodeservice.LegitimacyExpired, service.RecordedTable(), "", serviceID)
+			if err != nil {
+				fmt.Printf("failed to record expiry of access token %s: %s\n", grant.TokenID, err)
+			}
+		}
+		if len(burned) > 0 {
+			fmt.Printf("Burned %d expired access tokens of %s\n", len(burned), serviceID)
+		}
+	}
+}
+
+// renewGrants takes back and burns the time-limited grants of identity for
+// serviceID that a renewal until expiresAt supersedes. The renewed token is
+// left alone.
+func (r *Routers) renewGrants(identity string, serviceID string, renewedTokenID string, expiresAt time.Time) ([]string, error) {
+	grants, err := r.ServiceContract.AccessGrantsOf(identity, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	var burned []string
+	for _, grant := range grants {
+		if grant.TokenID == renewedTokenID || grant.ExpiresAt == 0 || grant.ExpiresAt > expiresAt.Unix() {
+			continue
+		}
+		if err := r.ServiceContract.ReclaimGrant(grant); err != nil {
+			return burned, fmt.Errorf("failed to retire superseded access token %s: %w", grant.TokenID, err)
+		}
+		burned = append(burned, grant.TokenID)
+	}
+	return burned, nil
+}
+
+// approveGrant approves the publisher that transferred the access token
+// tokenID to me for that one token, so that it can take it back when it
+// revokes the grant or the grant expires. Any other token, or one that did
+// not come from the publisher of its service, is left alone.
+func (r *Routers) approveGrant(from string, tokenID string) error {
+	tokenURI, err := r.ServiceContract.TokenURI(tokenID)
+	if err != nil {
+		return err
+	}
+	grant, ok, err := chaincodeservice.ParseAccessGrant(tokenID, r.OrgSetup.Identity, tokenURI)
+	if err != nil || !ok {
+		return err
+	}
+	publisher, err := r.publisherOf(grant.ServiceID)
+	if err != nil {
+		return fmt.Errorf("failed to find publisher of %s: %w", grant.ServiceID, err)
+	}
+	from = strings.ReplaceAll(from, " ", "")
+	if strings.ReplaceAll(publisher, " ", "") != from {
+		return nil
+	}
+	approved, err := r.ServiceContract.GetApproved(tokenID)
+	if err != nil || strings.ReplaceAll(approved, " ", "") == from {
+		return err
+	}
+	return r.ServiceContract.Approve(from, tokenID)
+}
+
+func (r *Routers) accessGrantOf(identity string, serviceID string) (chaincodeservice.AccessGrant, bool, error) {
+	if r.Index != nil {
+		return r.Index.AccessGrantOf(identity, serviceID, time.Now())
+	}
+	return r.ServiceContract.AccessGrantOf(identity, serviceID, time.Now())
+}
+
+// quotaUsage adds up the successful queries identity made on serviceID since
+// the given Unix time, 0 for all time.
+func (r *Routers) quotaUsage(identity string, serviceID string, since int64) (chaincodeservice.QuotaUsage, error) {
+	queries, err := r.grantedQueries(identity, chaincodeservice.QueryFilter{ServiceID: serviceID, From: since})
+	if err != nil {
+		return chaincodeservice.QuotaUsage{}, err
+	}
+	return usageIn(queries, serviceID, since), nil
+}
+
+// grantedQueries lists the successful queries identity made that match
+// filter.
+func (r *Routers) grantedQueries(identity string, filter chaincodeservice.QueryFilter) ([]chaincodeservice.Query, error) {
+	filter.InitiatorID = identity
+	filter.Legitimacy = chaincodeservice.LegitimacyGranted
+	queries, err := r.filterQueries(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list queries of %s: %w", identity, err)
+	}
+	return queries, nil
+}
+
+// usageIn adds up the queries on serviceID since the given Unix time.
+func usageIn(queries []chaincodeservice.Query, serviceID string, since int64) chaincodeservice.QuotaUsage {
+	var usage chaincodeservice.QuotaUsage
+	for _, query := range queries {
+		if query.ServiceID != serviceID || int64(query.Timestamp) < since {
+			continue
+		}
+		usage.Fetches++
+		usage.Rows += query.DataRows
+	}
+	return usage
+}
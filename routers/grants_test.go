@@ -0,0 +1,169 @@
+package routers
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"service-client/chaincodeservice"
+)
+
+func TestSweepExpiredGrants(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	n.grant(t, serviceID, map[string]interface{}{"ExpiresAt": expiresAt})
+	if status, respData := n.fetch(t, serviceID, nil); status != http.StatusOK {
+		t.Fatalf("fetch before expiry: %d %v", status, respData)
+	}
+
+	// services change while the sweeper ranges over them
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		service, _ := n.publisher.r.service(serviceID)
+		for i := 0; i < 20; i++ {
+			if err := n.publisher.r.putService("Service-x", &service); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	n.publisher.r.sweepExpiredGrants(time.Unix(expiresAt, 0).Add(time.Minute))
+	wg.Wait()
+
+	consumer := strings.ReplaceAll(n.consumer.r.OrgSetup.Identity, " ", "")
+	if balance, err := n.publisher.r.ServiceContract.BalanceOfByURIPrefix(consumer, serviceID); err != nil || balance != 0 {
+		t.Fatalf("consumer holds %d tokens after the sweep: %v", balance, err)
+	}
+	expired := n.queriesOf(t, serviceID, chaincodeservice.LegitimacyExpired)
+	if len(expired) != 1 || expired[0].InitiatorID != consumer {
+		t.Fatalf("expiry records %+v", expired)
+	}
+}
+
+func TestRenewalRetiresSupersededGrant(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	first := n.grant(t, serviceID, map[string]interface{}{"ExpiresAt": time.Now().Add(time.Hour).Unix()})
+	status, respData := post(t, n.publisher.url+"/approve_application", map[string]interface{}{
+		"ServiceID":   serviceID,
+		"InitiatorID": n.consumer.r.OrgSetup.Identity,
+		"ExpiresAt":   time.Now().Add(2 * time.Hour).Unix(),
+	})
+	if status != http.StatusOK {
+		t.Fatalf("renewal: %d %v", status, respData)
+	}
+	renewed, _ := respData["renewed"].([]interface{})
+	if len(renewed) != 1 || renewed[0] != first {
+		t.Fatalf("renewal retired %v, want [%s]", renewed, first)
+	}
+}
+
+func TestFetchQuota(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	n.grant(t, serviceID, map[string]interface{}{"MaxFetches": 1})
+	if status, respData := n.fetch(t, serviceID, nil); status != http.StatusOK {
+		t.Fatalf("first fetch: %d %v", status, respData)
+	}
+	if status, respData := n.fetch(t, serviceID, nil); status == http.StatusOK {
+		t.Fatalf("fetch beyond the quota succeeded: %v", respData)
+	}
+	if refused := n.queriesOf(t, serviceID, chaincodeservice.LegitimacyQuota); len(refused) != 1 {
+		t.Fatalf("quota refusals recorded: %+v", refused)
+	}
+
+	n.settle(t)
+	status, respData := get(t, n.consumer.url+"/get_services")
+	if status != http.StatusOK {
+		t.Fatalf("get_services: %d %v", status, respData)
+	}
+	services, _ := respData["services"].([]interface{})
+	quota, _ := services[0].(map[string]interface{})["Quota"].(map[string]interface{})
+	if quota["MaxFetches"] != float64(1) || quota["RemainingFetches"] != float64(0) {
+		t.Fatalf("quota shown to the consumer: %v", quota)
+	}
+}
+
+func TestRowQuota(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	n.grant(t, serviceID, map[string]interface{}{"MaxRows": 2})
+	// a result larger than the rows left is refused whole
+	if status, respData := n.fetch(t, serviceID, nil); status == http.StatusOK {
+		t.Fatalf("fetch of 3 rows on a quota of 2 succeeded: %v", respData)
+	}
+	status, respData := n.fetch(t, serviceID, map[string]interface{}{
+		"Filters": []map[string]interface{}{{"Field": "id", "Op": "<=", "Value": 2}},
+	})
+	if status != http.StatusOK {
+		t.Fatalf("fetch of 2 rows: %d %v", status, respData)
+	}
+	if rows := rowsOf(t, respData); len(rows) != 2 {
+		t.Fatalf("fetched %v", rows)
+	}
+}
+
+func TestLongestGrantIsEnforced(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	n.grant(t, serviceID, map[string]interface{}{"ExpiresAt": time.Now().Add(time.Hour).Unix(), "MaxFetches": 1})
+	// a grant without expiry outlasts the one above
+	status, respData := post(t, n.publisher.url+"/approve_application", map[string]interface{}{
+		"ServiceID":   serviceID,
+		"InitiatorID": n.consumer.r.OrgSetup.Identity,
+		"MaxFetches":  2,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("second grant: %d %v", status, respData)
+	}
+	for i := 0; i < 2; i++ {
+		if status, respData := n.fetch(t, serviceID, nil); status != http.StatusOK {
+			t.Fatalf("fetch %d: %d %v", i+1, status, respData)
+		}
+	}
+}
+
+func TestTermsWithoutIndex(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	n.publisher.r.Index = nil
+	n.consumer.r.Index = nil
+	n.grant(t, serviceID, map[string]interface{}{"MaxFetches": 1, "ExpiresAt": time.Now().Add(time.Hour).Unix()})
+
+	if status, respData := n.fetch(t, serviceID, nil); status != http.StatusOK {
+		t.Fatalf("fetch within quota: %d %v", status, respData)
+	}
+	if status, respData := n.fetch(t, serviceID, nil); status == http.StatusOK {
+		t.Fatalf("fetch beyond quota succeeded: %v", respData)
+	}
+	if len(n.queriesOf(t, serviceID, chaincodeservice.LegitimacyQuota)) != 1 {
+		t.Fatal("refusal for quota was not recorded")
+	}
+}
+
+func TestApproveApplicationArguments(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	res, err := http.Post(n.publisher.url+"/approve_application", "application/json", strings.NewReader("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, respData := decodeResponse(t, res); status != http.StatusBadRequest {
+		t.Fatalf("approval with a broken body: %d %v", status, respData)
+	}
+	res.Body.Close()
+	for _, args := range []map[string]interface{}{
+		{"ServiceID": 3, "InitiatorID": n.consumer.r.OrgSetup.Identity},
+		{"ServiceID": serviceID},
+		{"ServiceID": serviceID, "InitiatorID": []string{"x"}},
+	} {
+		if status, respData := post(t, n.publisher.url+"/approve_application", args); status != http.StatusBadRequest {
+			t.Errorf("approval with %v: %d %v", args, status, respData)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package routers
+
+import (
+	"fmt"
+	"net/http"
+
+	"service-client/chaincodeservice"
+	"service-client/indexer"
+
+	"github.com/gin-gonic/gin"
+)
+
+// startIndex opens the local ledger index when Config.IndexPath is set and
+// starts following both contracts, replaying from genesis if rebuild is set.
+func (r *Routers) startIndex(source chaincodeservice.EventSource, rebuild bool) error {
+	if r.Config.IndexPath == "" {
+		return nil
+	}
+	index, err := indexer.Open(r.Config.IndexPath, &r.QueryContract, &r.ServiceContract, source)
+	if err != nil {
+		return err
+	}
+	if rebuild {
+		err = index.Rebuild()
+	} else {
+		err = index.Start()
+	}
+	if err != nil {
+		index.Close()
+		return fmt.Errorf("error starting index: %s", err)
+	}
+	r.Index = index
+	return nil
+}
+
+// indexStatus is added to responses answered from the index, nil otherwise
+func (r *Routers) indexStatus() *indexer.Status {
+	if r.Index == nil {
+		return nil
+	}
+	status := r.Index.Status()
+	return &status
+}
+
+func (r *Routers) getServices() ([]string, error) {
+	if r.Index != nil {
+		return r.Index.GetServices()
+	}
+	return r.ServiceContract.GetServices()
+}
+
+func (r *Routers) hasAccessToService(serviceID string) (bool, error) {
+	if r.Index != nil {
+		return r.Index.HasAccessToService(serviceID)
+	}
+	return r.ServiceContract.HasAccessToService(serviceID)
+}
+
+func (r *Routers) publisherOf(serviceID string) (string, error) {
+	if r.Index != nil {
+		return r.Index.PublisherOf(serviceID)
+	}
+	return r.ServiceContract.PublisherOf(serviceID)
+}
+
+func (r *Routers) ownerMSPID() (string, error) {
+	if r.Index != nil {
+		return r.Index.OwnerMSPID()
+	}
+	return r.ServiceContract.OwnerMSPID()
+}
+
+// filterQueries returns every query record matching filter, reading the
+// ledger at most once.
+func (r *Routers) filterQueries(filter chaincodeservice.QueryFilter) ([]chaincodeservice.Query, error) {
+	if r.Index == nil {
+		return r.QueryContract.FilterQueries(filter)
+	}
+	var queries []chaincodeservice.Query
+	bookmark := ""
+	for {
+		page, err := r.Index.FindQueries(filter, maxQueryPageSize, bookmark)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, page.Queries...)
+		if page.Bookmark == "" {
+			return queries, nil
+		}
+		bookmark = page.Bookmark
+	}
+}
+
+// Drop the local index and replay the ledger from the genesis block.
+func (r *Routers) IRebuildIndex() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if r.Index == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "index is not enabled, set IndexPath in the config"})
+			return
+		}
+		if err := r.Index.Rebuild(); err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"index": r.indexStatus()})
+	}
+}
@@ -0,0 +1,177 @@
+package routers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"service-client/chaincodeservice"
+	"service-client/keys"
+)
+
+// initiator is who a request from another node comes from, as proven by
+// its certificate.
+type initiator struct {
+	Identity  string // the account ID, as ClientAccountID derives it
+	MSPID     string // the MSP whose CA issued the certificate
+	PublicKey crypto.PublicKey
+	// Certificate is the PEM certificate the identity is taken from
+	Certificate string
+	// Verified is false for nodes that predate certificates, whose identity
+	// and MSP are only claimed
+	Verified bool
+}
+
+// newMSPVerifier trusts the CAs of the MSPs in Config.MSPDirs, and those of
+// my own MSP under the crypto path of my org unless it is listed.
+func newMSPVerifier(config Config, orgSetup *chaincodeservice.OrgSetup) (*chaincodeservice.MSPVerifier, error) {
+	verifier := chaincodeservice.NewMSPVerifier()
+	for mspID, dir := range config.MSPDirs {
+		if err := verifier.LoadMSPDir(mspID, dir); err != nil {
+			return nil, fmt.Errorf("failed to load MSP %s: %w", mspID, err)
+		}
+	}
+	if _, ok := config.MSPDirs[orgSetup.MSPID]; !ok && orgSetup.CryptoPath != "" {
+		if err := verifier.LoadMSPDir(orgSetup.MSPID, filepath.Join(orgSetup.CryptoPath, "msp")); err != nil {
+			return nil, fmt.Errorf("failed to load MSP %s: %w", orgSetup.MSPID, err)
+		}
+	}
+	fmt.Printf("Initializing MSPs - Trusted: %s\n", strings.Join(verifier.MSPIDs(), ", "))
+	if len(verifier.MSPIDs()) <= 1 {
+		fmt.Printf("Initializing MSPs - Warning: requests from other orgs are refused until their MSP directories are added to MSPDirs in the config\n")
+	}
+	return verifier, nil
+}
+
+// certificatePEM encodes my certificate to present to other nodes.
+func (r *Routers) certificatePEM() string {
+	if r.OrgSetup.Certificate == nil {
+		return ""
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: r.OrgSetup.Certificate.Raw}))
+}
+
+// presentInitiator adds who I am to a request to another node: my
+// certificate, and my key with its algorithm, see package keys. Nodes that
+// predate other algorithms only read the InitiatorPublicKeyX/Y of P-256
+// keys, so those are sent for ECDSA keys as well.
+func (r *Routers) presentInitiator(data map[string]interface{}) error {
+	publicKey := r.OrgSetup.PublicKey
+	algorithm, err := keys.Algorithm(publicKey)
+	if err != nil {
+		return err
+	}
+	encoded, err := keys.MarshalPublic(publicKey)
+	if err != nil {
+		return err
+	}
+	data["InitiatorKeyAlgorithm"] = algorithm
+	data["InitiatorPublicKey"] = encoded
+	if key, ok := publicKey.(*ecdsa.PublicKey); ok {
+		data["InitiatorPublicKeyX"] = key.X.Text(10)
+		data["InitiatorPublicKeyY"] = key.Y.Text(10)
+	}
+	data["InitiatorCertificate"] = r.certificatePEM()
+	return nil
+}
+
+// verifyInitiator validates the InitiatorCertificate of a request against
+// the CAs of the MSPs of the channel and derives the identity, MSP and key
+// of the initiator from it. What the request claims besides, as
+// claimedIdentity, InitiatorMSPID, InitiatorKeyAlgorithm, InitiatorPublicKey
+// and InitiatorPublicKeyX/Y, has to match. Requests from nodes that predate
+// certificates are only taken, on their claims, if Config.LegacyCallback
+// allows it.
+func (r *Routers) verifyInitiator(httpData map[string]interface{}, claimedIdentity string) (initiator, error) {
+	claimedIdentity = strings.ReplaceAll(claimedIdentity, " ", "")
+	claimedMSPID, _ := httpData["InitiatorMSPID"].(string)
+	claimedAlgorithm, _ := httpData["InitiatorKeyAlgorithm"].(string)
+	encodedKey, _ := httpData["InitiatorPublicKey"].(string)
+	X, _ := httpData["InitiatorPublicKeyX"].(string)
+	Y, _ := httpData["InitiatorPublicKeyY"].(string)
+
+	var claimedKey crypto.PublicKey
+	if encodedKey != "" {
+		key, err := keys.ParsePublic(encodedKey)
+		if err != nil {
+			return initiator{}, err
+		}
+		claimedKey = key
+	} else if X != "" || Y != "" {
+		// nodes that predate other algorithms have P-256 keys
+		key := GetPublicKey(X, Y)
+		if key.X == nil || key.Y == nil {
+			return initiator{}, fmt.Errorf("invalid InitiatorPublicKeyX/Y")
+		}
+		claimedKey = key
+	}
+	if claimedAlgorithm != "" && claimedKey != nil {
+		if algorithm, _ := keys.Algorithm(claimedKey); algorithm != claimedAlgorithm {
+			return initiator{}, fmt.Errorf("key algorithm %s does not match the %s public key", claimedAlgorithm, algorithm)
+		}
+	}
+
+	certificatePEM, _ := httpData["InitiatorCertificate"].(string)
+	if certificatePEM == "" {
+		if !r.Config.LegacyCallback {
+			return initiator{}, fmt.Errorf("no InitiatorCertificate")
+		}
+		if claimedKey == nil {
+			return initiator{}, fmt.Errorf("no public key")
+		}
+		return initiator{Identity: claimedIdentity, MSPID: claimedMSPID, PublicKey: claimedKey}, nil
+	}
+	certificates, err := chaincodeservice.ParseCertificates([]byte(certificatePEM))
+	if err != nil {
+		return initiator{}, fmt.Errorf("invalid InitiatorCertificate: %w", err)
+	}
+	certificate := certificates[0]
+	if r.msps == nil {
+		return initiator{}, fmt.Errorf("no MSP to verify certificates against")
+	}
+	mspID, err := r.msps.Verify(certificate, time.Now())
+	if errors.Is(err, chaincodeservice.ErrUnknownMSP) {
+		return initiator{}, fmt.Errorf("%w; add the MSP directory of its org to MSPDirs in the config", err)
+	}
+	if err != nil {
+		return initiator{}, err
+	}
+	publicKey := certificate.PublicKey
+	algorithm, err := keys.Algorithm(publicKey)
+	if err != nil {
+		return initiator{}, fmt.Errorf("unsupported public key in InitiatorCertificate: %w", err)
+	}
+	verified := initiator{
+		Identity:    chaincodeservice.AccountIDFromCertificate(certificate),
+		MSPID:       mspID,
+		PublicKey:   publicKey,
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})),
+		Verified:    true,
+	}
+
+	if claimedIdentity != "" && claimedIdentity != strings.ReplaceAll(verified.Identity, " ", "") {
+		return initiator{}, fmt.Errorf("identity %s does not match the certificate of %s", claimedIdentity, verified.Identity)
+	}
+	if claimedMSPID != "" && claimedMSPID != mspID {
+		return initiator{}, fmt.Errorf("MSP %s does not match the certificate issued by %s", claimedMSPID, mspID)
+	}
+	if claimedAlgorithm != "" && claimedAlgorithm != algorithm {
+		return initiator{}, fmt.Errorf("key algorithm %s does not match the %s key of the certificate", claimedAlgorithm, algorithm)
+	}
+	if claimedKey != nil && !keys.Equal(claimedKey, publicKey) {
+		return initiator{}, fmt.Errorf("public key does not match the certificate")
+	}
+	// the coordinates sent along for older nodes have to match as well
+	if encodedKey != "" && (X != "" || Y != "") {
+		key, ok := publicKey.(*ecdsa.PublicKey)
+		if !ok || key.X.Text(10) != X || key.Y.Text(10) != Y {
+			return initiator{}, fmt.Errorf("public key does not match the certificate")
+		}
+	}
+	return verified, nil
+}
@@ -0,0 +1,87 @@
+package routers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"service-client/keys"
+)
+
+func TestInitiatorOfUnknownMSP(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	// the publisher has no MSP directory of Org3
+	stranger := newTestNode(t, n.emulator, "Org3", "Org3MSP", "stranger", keys.P256)
+
+	status, respData := post(t, stranger.url+"/fetch_data", map[string]interface{}{
+		"ServiceID":    serviceID,
+		"PublisherURL": n.publisher.url,
+	})
+	if status == http.StatusOK {
+		t.Fatalf("fetch of an initiator of an unknown MSP succeeded: %v", respData)
+	}
+	if message, _ := respData["error"].(string); !strings.Contains(message, "MSPDirs") {
+		t.Fatalf("refusal does not say how to trust the MSP: %v", respData)
+	}
+}
+
+func TestInitiatorAlgorithms(t *testing.T) {
+	for _, algorithms := range [][2]string{{keys.P384, keys.P384}, {keys.Ed25519, keys.Ed25519}, {keys.P256, keys.Ed25519}, {keys.Ed25519, keys.P384}} {
+		t.Run(algorithms[0]+"-"+algorithms[1], func(t *testing.T) {
+			n := newTestNetworkOf(t, algorithms[0], algorithms[1])
+			serviceID := n.publish(t, newTestTable(t, 3), nil)
+			n.grant(t, serviceID, nil)
+
+			status, respData := n.fetch(t, serviceID, nil)
+			if status != http.StatusOK {
+				t.Fatalf("fetch_data: %d %v", status, respData)
+			}
+			if rows := rowsOf(t, respData); len(rows) != 3 {
+				t.Fatalf("fetched %v", rows)
+			}
+			status, respData = n.fetch(t, serviceID, map[string]interface{}{"Stream": true, "PageSize": 2})
+			if status != http.StatusOK || respData["rows"] != float64(3) {
+				t.Fatalf("streamed fetch: %d %v", status, respData)
+			}
+		})
+	}
+}
+
+func TestInitiatorClaimsMatchCertificate(t *testing.T) {
+	n := newTestNetworkOf(t, keys.P256, keys.Ed25519)
+	consumer := n.consumer.r
+	present := func() map[string]interface{} {
+		data := map[string]interface{}{"InitiatorMSPID": consumer.OrgSetup.MSPID}
+		if err := consumer.presentInitiator(data); err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	verified, err := n.publisher.r.verifyInitiator(present(), consumer.OrgSetup.Identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verified.Verified || verified.MSPID != "Org2MSP" || !keys.Equal(verified.PublicKey, consumer.OrgSetup.PublicKey) {
+		t.Fatalf("initiator %+v", verified)
+	}
+
+	publisherKey, _ := keys.MarshalPublic(n.publisher.r.OrgSetup.PublicKey)
+	claims := map[string]map[string]interface{}{
+		"another algorithm": {"InitiatorKeyAlgorithm": keys.P384},
+		"another key":       {"InitiatorPublicKey": publisherKey, "InitiatorKeyAlgorithm": keys.P256},
+		"another MSP":       {"InitiatorMSPID": "Org1MSP"},
+	}
+	for name, claim := range claims {
+		data := present()
+		for key, value := range claim {
+			data[key] = value
+		}
+		if _, err := n.publisher.r.verifyInitiator(data, consumer.OrgSetup.Identity); err == nil {
+			t.Errorf("initiator claiming %s verified", name)
+		}
+	}
+	if _, err := n.publisher.r.verifyInitiator(present(), n.publisher.r.OrgSetup.Identity); err == nil {
+		t.Error("initiator claiming another identity verified")
+	}
+}
@@ -0,0 +1,49 @@
+package routers
+
+import (
+	"sync"
+	"time"
+)
+
+// maxNonces bounds the nonces a nonceCache remembers; past it, new ones are
+// refused until old ones expire, rather than letting a flood evict nonces
+// that could then be replayed.
+const maxNonces = 100000
+
+// nonceCache remembers the nonces of signed requests for as long as their
+// timestamp can be within the clock skew window, twice the skew, so that
+// each is accepted once.
+type nonceCache struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	seen map[string]time.Time
+}
+
+func newNonceCache(ttl time.Duration) *nonceCache {
+	return &nonceCache{ttl: ttl, seen: make(map[string]time.Time)}
+}
+
+// use records key at now and reports whether it was not seen before.
+func (n *nonceCache) use(key string, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.seen) >= maxNonces {
+		n.prune(now)
+		if len(n.seen) >= maxNonces {
+			return false
+		}
+	}
+	if expires, ok := n.seen[key]; ok && now.Before(expires) {
+		return false
+	}
+	n.seen[key] = now.Add(n.ttl)
+	return true
+}
+
+func (n *nonceCache) prune(now time.Time) {
+	for key, expires := range n.seen {
+		if !now.Before(expires) {
+			delete(n.seen, key)
+		}
+	}
+}
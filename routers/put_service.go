@@ -1,13 +1,30 @@
 package routers
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
+
+	"service-client/datasource"
 
 	"github.com/gin-gonic/gin"
 )
 
-// Args: DisplayName, Description, IP, Port, User, Password, Database, Table
+// Args: serviceName, comment, Type, Options (driver specific, see package
+// datasource), Masking (optional, {Rules: [{Column, Action, ...}]}),
+// RowSecurity (optional, [{Field, Attribute}]), PreviewRows (optional, rows
+// /describe_service may show), DryRun (optional, only probe the data source).
+// Without a Type, IP, Port, User, Password, Database and Table describe a
+// MySQL table.
+//
+// The data source is probed before the service is minted, and the columns
+// found are returned. A failed probe answers with the stage it failed at, see
+// datasource.Probe, and leaves the ledger untouched.
 func (r *Routers) IPutService() func(c *gin.Context) {
 	return func(c *gin.Context) {
 		// 1. 接受前端传来的httpData
@@ -17,34 +34,39 @@ func (r *Routers) IPutService() func(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		// 2. 生成唯一的ServiceID
-		serviceID, err := r.ServiceContract.NewService(r.MyURL)
+		service, err := parseService(httpData, ServiceType{})
 		if err != nil {
-			err = fmt.Errorf("failed to generate new service ID: %v", err)
 			fmt.Printf("error: %v\n", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		information := ServiceInformation{
-			DisplayName: httpData["serviceName"].(string),
-			Description: httpData["comment"].(string),
+		// 2. 连接数据源，确认表存在且可读，之后才上链
+		schema, err := r.probeService(c.Request.Context(), "", service)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, probeFailure(err))
+			return
 		}
-		var credentials ServiceCredentials
-		if _, ok := httpData["Password"]; ok {
-			credentials = ServiceCredentials{
-				DatabaseIP:       httpData["IP"].(string),
-				DatabasePort:     httpData["Port"].(string),
-				DatabaseUser:     httpData["User"].(string),
-				DatabasePassword: httpData["Password"].(string),
-				DatabaseName:     httpData["Database"].(string),
-				DatabaseTable:    httpData["Table"].(string),
-			}
+		if dryRun, _ := httpData["DryRun"].(bool); dryRun {
+			c.JSON(http.StatusOK, gin.H{
+				"schema":    schema,
+				"error_msg": "None",
+			})
+			return
 		}
 
-		// 3. 将httpData存入数据库
-		r.Config.Services[serviceID] = ServiceType{Information: information, Credentials: credentials}
-		err = r.updateConfig()
+		// 3. 生成唯一的ServiceID
+		serviceID, err := r.ServiceContract.NewService(r.MyURL)
+		if err != nil {
+			err = fmt.Errorf("failed to generate new service ID: %v", err)
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// 4. 将httpData存入数据库
+		err = r.putService(serviceID, &service)
 		if err != nil {
 			err = fmt.Errorf("failed to update config: %v", err)
 			fmt.Printf("error: %v\n", err)
@@ -54,7 +76,188 @@ func (r *Routers) IPutService() func(c *gin.Context) {
 
 		c.JSON(http.StatusOK, gin.H{
 			"serviceID": serviceID,
+			"schema":    schema,
 			"error_msg": "None",
 		})
 	}
 }
+
+// probeTimeout bounds connecting to and reading from a data source on publish
+const probeTimeout = 30 * time.Second
+
+// probeService probes the data source of a service, with its secrets
+// resolved. A service not yet minted has no serviceID and can only refer to
+// secrets, not hold sealed ones.
+func (r *Routers) probeService(ctx context.Context, serviceID string, service ServiceType) ([]datasource.Column, error) {
+	if serviceID == "" {
+		serviceID = "new service"
+	}
+	service, err := r.resolveService(serviceID, service)
+	if err != nil {
+		return nil, &datasource.ProbeError{Stage: stageCredentials, Err: err}
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	typ, options := service.DataSource()
+	return datasource.Probe(ctx, typ, options)
+}
+
+// stageCredentials is the probe stage of secret references that do not
+// resolve
+const stageCredentials = "credentials"
+
+// probeFailure is the response to a failed probe.
+func probeFailure(err error) gin.H {
+	failure := gin.H{"error": err.Error(), "stage": datasource.StageOptions}
+	var probeErr *datasource.ProbeError
+	if errors.As(err, &probeErr) {
+		failure["error"], failure["stage"] = probeErr.Err.Error(), probeErr.Stage
+	}
+	if failure["stage"] == datasource.StageOptions {
+		failure["types"] = datasource.Types()
+	}
+	return failure
+}
+
+// parseOptions reads driver options from a JSON object, accepting numbers and
+// booleans for convenience.
+func parseOptions(raw interface{}) (datasource.Options, error) {
+	object, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Options must be an object")
+	}
+	options := make(datasource.Options, len(object))
+	for key, value := range object {
+		switch value := value.(type) {
+		case string:
+			options[key] = value
+		case float64, bool:
+			options[key] = fmt.Sprint(value)
+		default:
+			return nil, fmt.Errorf("option %s must be a string", key)
+		}
+	}
+	return options, nil
+}
+
+// parseService applies the arguments of /put_service found in httpData to
+// service. Options given for the Type service already has are merged into
+// its options, so that an update need not repeat the password.
+func parseService(httpData map[string]interface{}, service ServiceType) (ServiceType, error) {
+	service = service.clone()
+	if name, ok := httpData["serviceName"].(string); ok {
+		service.Information.DisplayName = name
+	}
+	if comment, ok := httpData["comment"].(string); ok {
+		service.Information.Description = comment
+	}
+	var err error
+	typ, _ := httpData["Type"].(string)
+	if _, ok := httpData["Options"]; ok || typ != "" {
+		options, err := parseOptions(httpData["Options"])
+		if err != nil {
+			return service, err
+		}
+		if typ == "" || typ == service.Type {
+			for key, value := range options {
+				if value == "" {
+					delete(service.Options, key)
+				} else if service.Options != nil {
+					service.Options[key] = value
+				}
+			}
+			if service.Options == nil {
+				service.Options = options
+			}
+		} else {
+			service.Options = options
+		}
+		if typ != "" {
+			service.Type = typ
+		}
+	} else {
+		for key, value := range map[string]*string{
+			"IP":       &service.Credentials.DatabaseIP,
+			"Port":     &service.Credentials.DatabasePort,
+			"User":     &service.Credentials.DatabaseUser,
+			"Password": &service.Credentials.DatabasePassword,
+			"Database": &service.Credentials.DatabaseName,
+			"Table":    &service.Credentials.DatabaseTable,
+		} {
+			if text, ok := httpData[key].(string); ok {
+				*value = text
+			}
+		}
+	}
+	if raw, ok := httpData["Masking"]; ok && raw != nil {
+		service.Masking, err = parseMasking(raw, service.Masking)
+		if err != nil {
+			return service, err
+		}
+	}
+	if raw, ok := httpData["RowSecurity"]; ok && raw != nil {
+		service.RowSecurity, err = parseRowSecurity(raw)
+		if err != nil {
+			return service, err
+		}
+	}
+	if raw, ok := httpData["PreviewRows"]; ok && raw != nil {
+		rows, ok := raw.(float64)
+		if !ok || rows < 0 || rows > maxPreviewRows || rows != float64(int(rows)) {
+			return service, fmt.Errorf("PreviewRows must be a number of rows up to %d", maxPreviewRows)
+		}
+		service.PreviewRows = int(rows)
+	}
+	return service, nil
+}
+
+// parseMasking reads a masking policy. A new policy starts at version 1 and
+// gets a random secret for its keyed rules; one replacing previous takes
+// the next version and keeps its secret, so that hashed values still match.
+func parseMasking(raw interface{}, previous *datasource.MaskingPolicy) (*datasource.MaskingPolicy, error) {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var policy datasource.MaskingPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("invalid Masking: %s", err)
+	}
+	if previous != nil {
+		if policy.Version <= previous.Version {
+			policy.Version = previous.Version + 1
+		}
+		if policy.Secret == "" {
+			policy.Secret = previous.Secret
+		}
+	}
+	if policy.Version == 0 {
+		policy.Version = 1
+	}
+	if policy.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		policy.Secret = hex.EncodeToString(secret)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Masking: %s", err)
+	}
+	return &policy, nil
+}
+
+func parseRowSecurity(raw interface{}) ([]datasource.RowRule, error) {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var rules []datasource.RowRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid RowSecurity: %s", err)
+	}
+	if err := datasource.ValidateRowRules(rules); err != nil {
+		return nil, fmt.Errorf("invalid RowSecurity: %s", err)
+	}
+	return rules, nil
+}
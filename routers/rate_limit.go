@@ -0,0 +1,67 @@
+package routers
+
+import (
+	"sync"
+	"time"
+)
+
+// maxRateLimitKeys bounds the clients a rateLimiter remembers; past it,
+// clients whose bucket has refilled are forgotten.
+const maxRateLimitKeys = 10000
+
+// rateLimiter keeps a token bucket per client: a client may send burst
+// requests at once, and one more every interval after that.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    int
+	buckets  map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter allows perMinute requests a minute to each client, in
+// bursts of up to perMinute.
+func newRateLimiter(perMinute int) *rateLimiter {
+	if perMinute <= 0 {
+		perMinute = 1
+	}
+	return &rateLimiter{interval: time.Minute / time.Duration(perMinute), burst: perMinute, buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token from the bucket of key at now, and reports whether
+// there was one.
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.buckets) >= maxRateLimitKeys {
+		l.prune(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += float64(now.Sub(b.last)) / float64(l.interval)
+	if b.tokens > float64(l.burst) {
+		b.tokens = float64(l.burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (l *rateLimiter) prune(now time.Time) {
+	full := time.Duration(l.burst) * l.interval
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -3,38 +3,54 @@ package routers
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// ReceiveMessage answers the challenge of publishers that predate request
+// signatures and still verify their initiators by calling back, see
+// authenticate. Only a Challenge to my own identity that has not expired,
+// from a publisher I am sending a request to, is signed; anything else is
+// refused, so that the endpoint cannot be used to have my key sign arbitrary
+// data or answer a challenge relayed by another node.
+//
+// Args: Challenge
 func (r *Routers) ReceiveMessage() func(c *gin.Context) {
 	return func(c *gin.Context) {
-		// 1. receive raw message
-		var data map[string]interface{}
+		// 1. receive the challenge
+		var data struct {
+			Challenge *Challenge `json:"Challenge"`
+		}
 		if err := c.ShouldBindJSON(&data); err != nil {
 			fmt.Printf("error: %v\n", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		fmt.Println("============= receive_message start debug =============")
-		fmt.Println("receive_message receive message", data)
-		// 2. generate the signature
-		message := data["message"]
-		signature, err := SignMessage(message.(string), r.OrgSetup.PrivateKeySigner)
+		if data.Challenge == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "only a Challenge is signed"})
+			return
+		}
+		challenge := *data.Challenge
+		if err := challenge.Check(r.OrgSetup.Identity, time.Now()); err != nil {
+			fmt.Printf("receive_message refused challenge: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !r.pending.waitingOn(challenge.Publisher) {
+			err := fmt.Errorf("no request of mine is waiting on %s", challenge.Publisher)
+			fmt.Printf("receive_message refused challenge: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// 2. sign its canonical encoding
+		signature, err := SignMessage(challenge.Encode(), r.OrgSetup.PrivateKeySigner)
 		if err != nil {
-			fmt.Println("receive_message SignMessage() err", err)
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
-		fmt.Println("receive_message generate signature", signature)
-		fmt.Println("receive_message 's signer ", r.OrgSetup.PrivateKeySigner)
-		// content := map[string]interface{}{
-		// 	"message": message,
-		// 	"sign":    signature,
-		// }
 		// 3. send in response
-		// sendData, _ := json.Marshal(content)
-		// res, err := http.Post(service["sellerurl"]+"/receive_sign",
-		// "application/json", bytes.NewBuffer([]byte(sendData)))
-		//返回值
-		c.JSON(http.StatusOK, gin.H{"message": message, "signature": signature})
+		c.JSON(http.StatusOK, gin.H{"Challenge": challenge, "signature": signature})
 	}
 }
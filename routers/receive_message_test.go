@@ -0,0 +1,124 @@
+package routers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReceiveMessage(t *testing.T) {
+	n := newTestNetwork(t)
+	consumer := n.consumer.r
+	challenge, err := newChallenge(n.publisher.url, consumer.OrgSetup.Identity, purposeAuthenticate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as while a request of the consumer waits on the publisher
+	end := consumer.pending.begin(n.publisher.url + "/")
+	defer end()
+
+	status, respData := post(t, n.consumer.url+"/receive_message", map[string]interface{}{"Challenge": challenge})
+	if status != http.StatusOK {
+		t.Fatalf("receive_message: %d %v", status, respData)
+	}
+	signature, _ := respData["signature"].(string)
+	if err := verifySignature(challenge.Encode(), signature, consumer.OrgSetup.PublicKey); err != nil {
+		t.Fatalf("signature does not verify over the challenge: %v", err)
+	}
+	// only the domain separated encoding is signed
+	if verifySignature(challenge.Nonce, signature, consumer.OrgSetup.PublicKey) == nil {
+		t.Fatal("signature verifies over the bare nonce")
+	}
+
+	refused := map[string]func(ch *Challenge){
+		"another identity": func(ch *Challenge) { ch.Identity = n.publisher.r.OrgSetup.Identity },
+		"another purpose":  func(ch *Challenge) { ch.Purpose = "login" },
+		"another version":  func(ch *Challenge) { ch.Version = 2 },
+		"expired":          func(ch *Challenge) { ch.Expires = time.Now().Add(-time.Second).Unix() },
+		"long lived":       func(ch *Challenge) { ch.Expires = time.Now().Add(time.Hour).Unix() },
+		"short nonce":      func(ch *Challenge) { ch.Nonce = "00" },
+		"no publisher URL": func(ch *Challenge) { ch.Publisher = "publisher" },
+		"line in a field":  func(ch *Challenge) { ch.Publisher += "\nIdentity: x" },
+	}
+	for name, alter := range refused {
+		ch := challenge
+		alter(&ch)
+		if status, respData := post(t, n.consumer.url+"/receive_message", map[string]interface{}{"Challenge": ch}); status != http.StatusBadRequest {
+			t.Errorf("challenge with %s: %d %v", name, status, respData)
+		}
+	}
+	// what the endpoint signed before challenges
+	if status, respData := post(t, n.consumer.url+"/receive_message", map[string]interface{}{"message": "pay 100"}); status != http.StatusBadRequest {
+		t.Errorf("arbitrary message: %d %v", status, respData)
+	}
+}
+
+// Unsigned requests are taken from nodes that answer the challenge, where
+// LegacyCallback allows it.
+func TestLegacyCallback(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	n.grant(t, serviceID, nil)
+	n.settle(t)
+
+	req, body := n.newDataRequest(t, serviceID)
+	for _, header := range []string{"Signature", "Signature-Input", "Content-Digest"} {
+		req.Header.Del(header)
+	}
+	n.publisher.r.Config.LegacyCallback = true
+	end := n.consumer.r.pending.begin(n.publisher.url)
+	defer end()
+	if status, respData := send(t, req, body); status != http.StatusOK {
+		t.Fatalf("unsigned request answering the challenge: %d %v", status, respData)
+	}
+
+	// a node that cannot sign for the identity does not answer for it
+	n.consumer.r.MyURL = n.publisher.url
+	req, body = n.newDataRequest(t, serviceID)
+	for _, header := range []string{"Signature", "Signature-Input", "Content-Digest"} {
+		req.Header.Del(header)
+	}
+	if status, respData := send(t, req, body); status == http.StatusOK {
+		t.Fatalf("unsigned request whose callback is another node: %d %v", status, respData)
+	}
+}
+
+// A challenge is only answered while a request to its publisher is in
+// flight, so that another node cannot relay it.
+func TestRelayedChallenge(t *testing.T) {
+	n := newTestNetwork(t)
+	consumer := n.consumer.r
+	// a node the consumer never sent a request to asks it to answer the
+	// challenge it got from the publisher for the consumer's identity
+	challenge, err := newChallenge(n.publisher.url, consumer.OrgSetup.Identity, purposeAuthenticate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, respData := post(t, n.consumer.url+"/receive_message", map[string]interface{}{"Challenge": challenge}); status != http.StatusBadRequest {
+		t.Fatalf("relayed challenge: %d %v", status, respData)
+	}
+
+	// waiting on one publisher does not answer for another
+	end := consumer.pending.begin("http://other.example")
+	if status, respData := post(t, n.consumer.url+"/receive_message", map[string]interface{}{"Challenge": challenge}); status != http.StatusBadRequest {
+		t.Fatalf("challenge of a publisher not waited on: %d %v", status, respData)
+	}
+	end()
+	end = consumer.pending.begin(n.publisher.url)
+	end()
+	if status, respData := post(t, n.consumer.url+"/receive_message", map[string]interface{}{"Challenge": challenge}); status != http.StatusBadRequest {
+		t.Fatalf("challenge after the request ended: %d %v", status, respData)
+	}
+
+	// a publisher challenging a relayed request cannot get it answered
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	n.grant(t, serviceID, nil)
+	n.publisher.r.Config.LegacyCallback = true
+	req, body := n.newDataRequest(t, serviceID)
+	for _, header := range []string{"Signature", "Signature-Input", "Content-Digest"} {
+		req.Header.Del(header)
+	}
+	if status, respData := send(t, req, body); status == http.StatusOK {
+		t.Fatalf("unsigned request the consumer did not send: %d %v", status, respData)
+	}
+}
@@ -2,8 +2,16 @@ package routers
 
 import (
 	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
+
+	"service-client/chaincodeservice"
+	"service-client/envelope"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,39 +20,89 @@ func (r *Routers) CRequestData() func(*gin.Context) {
 	return func(c *gin.Context) {
 		// 接收从Initiator发来的数据申请，验证其权限后返回
 		// 1. 解析申请数据包
-		// 2. 调用链码 TODO 或许需要一个由InitiatorID+ServiceID共同索引的GetQuery
+		// 2. 调用链码 (按InitiatorID+ServiceID检索见 Indexer.FindQueries)
 		// 3. 根据链码结果决定向Initiator的fetch_data发什么内容
 		// 3.1 从数据库获取data
 
+		// the body is kept as sent, its digest is signed
 		var httpData map[string]interface{}
-		if err := c.ShouldBindJSON(&httpData); err != nil {
+		body, err := io.ReadAll(c.Request.Body)
+		if err == nil {
+			err = json.Unmarshal(body, &httpData)
+		}
+		if err != nil {
 			err = fmt.Errorf("failed to parse request data: %s", err)
 			fmt.Printf("error: %v\n", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		// data: ServiceID, InitiatorURL, InitiatorPublicKeyX, InitiatorPublicKeyY, InitiatorIdentity
+		// data: ServiceID, InitiatorURL, InitiatorCertificate, InitiatorKeyAlgorithm, InitiatorPublicKey,
+		// InitiatorPublicKeyX (optional), InitiatorPublicKeyY (optional), InitiatorIdentity,
+		// InitiatorMSPID, Columns (optional), Filters (optional, [{Field, Op, Value}]),
+		// Stream (optional), Cursor (optional), PageSize (optional),
+		// Envelope (optional, {Cipher, Framing}, see sendEnvelope)
 
 		// serviceID format: Service-123
-		serviceID := httpData["ServiceID"].(string)
-		X := httpData["InitiatorPublicKeyX"].(string)
-		Y := httpData["InitiatorPublicKeyY"].(string)
-		identity := httpData["InitiatorIdentity"].(string)
-		initiatorURL := httpData["InitiatorURL"].(string)
-		publicKey := GetPublicKey(X, Y)
-		certificate := fmt.Sprint(publicKey)
-		service, valid := r.Config.Services[serviceID]
+		serviceID, _ := httpData["ServiceID"].(string)
+		initiatorURL, _ := httpData["InitiatorURL"].(string)
+		claimedIdentity, _ := httpData["InitiatorIdentity"].(string)
+		// the initiator is who its certificate says, whatever else it claims
+		initiator, err := r.verifyInitiator(httpData, claimedIdentity)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		// the record keeps the certificate the identity is taken from, none
+		// for nodes that predate certificates
+		identity, initiatorMSPID, publicKey, certificate := initiator.Identity, initiator.MSPID, initiator.PublicKey, initiator.Certificate
+		service, valid := r.service(serviceID)
 		if !valid {
 			err := fmt.Errorf("service not found in config: %s", serviceID)
 			fmt.Printf("error: %v\n", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if service.state() == chaincodeservice.ServiceUnpublished {
+			err := fmt.Errorf("service %s is unpublished", serviceID)
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		service, err = r.resolveService(serviceID, service)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read credentials of service"})
+			return
+		}
+		stream, _ := httpData["Stream"].(bool)
+		cursor, pageSize, err := parseStreamArgs(httpData)
+		var delivery *envelopeOptions
+		if err == nil {
+			delivery, err = parseEnvelopeOptions(httpData)
+		}
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		query, err := parseQuery(httpData)
+		if err == nil {
+			query, err = restrictQuery(service, query, initiator)
+		}
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// the record holds the digest of what was asked for, refused or not
+		queryText := query.Normalize(service.Table())
+		queryDigest := query.Digest(service.Table())
 
-		createQuery := func(hashStr string, legitimacy string) string {
-			queryID, err := r.QueryContract.CreateQuery(certificate, hashStr, 1, identity, "initiatorMSPID", legitimacy, service.Credentials.DatabaseTable, "SELECT * FROM crfm", serviceID)
+		createQuery := func(hashStr string, dataRows int, legitimacy string) string {
+			queryID, err := r.QueryContract.CreateQuery(certificate, hashStr, dataRows, identity, initiatorMSPID, legitimacy, service.RecordedTable(), queryDigest, serviceID)
 			if err != nil {
-				fmt.Printf("failed to create query: %s", err)
+				fmt.Printf("failed to create query: %s\n", err)
 				return ""
 			}
 			return queryID
@@ -52,22 +110,28 @@ func (r *Routers) CRequestData() func(*gin.Context) {
 
 		// verify signature
 		fmt.Println("request_data get publicKey ", publicKey)
-		verified, err := r.execVerify(initiatorURL, publicKey)
+		verified, err := r.authenticate(c, body, identity, publicKey, initiatorURL)
 		if err != nil {
-			fmt.Println("send_application verify signature err: ", err)
 			fmt.Printf("error: %v\n", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			return
 		}
 		if !verified {
 			err := fmt.Errorf("failed to verify signature")
-			queryID := createQuery("", "unkown user")
+			queryID := createQuery("", 0, chaincodeservice.LegitimacyUnknownUser)
 			fmt.Printf("error: %v\n", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "queryID": queryID})
 			return
 		}
 
-		access, err := r.ServiceContract.HasAccessToService(serviceID)
+		// the initiator has to hold an access token itself; a revoked one was
+		// burned, or is named in the revocation record if it could not be.
+		var grant chaincodeservice.AccessGrant
+		self := strings.ReplaceAll(identity, " ", "") == strings.ReplaceAll(r.OrgSetup.Identity, " ", "")
+		access := self
+		if !self {
+			grant, access, err = r.accessGrantOf(identity, serviceID)
+		}
 		if err != nil {
 			err = fmt.Errorf("failed to get balance: %s", err)
 			fmt.Printf("error: %v\n", err)
@@ -76,25 +140,87 @@ func (r *Routers) CRequestData() func(*gin.Context) {
 		}
 		if !access {
 			err = fmt.Errorf("insufficient balance")
-			queryID := createQuery("", "no access")
+			queryID := createQuery("", 0, chaincodeservice.LegitimacyNoAccess)
 			fmt.Printf("error: %v\n", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "queryID": queryID})
 			return
 		}
 
+		var usage chaincodeservice.QuotaUsage
+		if grant.Limited() {
+			usage, err = r.quotaUsage(identity, serviceID, grant.QuotaSince(time.Now()))
+			if err != nil {
+				fmt.Printf("error: %v\n", err)
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if grant.Exhausted(usage) {
+				err = fmt.Errorf("quota exhausted: %d fetches and %d rows used", usage.Fetches, usage.Rows)
+				queryID := createQuery("", 0, chaincodeservice.LegitimacyQuota)
+				fmt.Printf("error: %v\n", err)
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "queryID": queryID})
+				return
+			}
+		}
+
+		if stream {
+			_, rowsLeft := grant.Remaining(usage)
+			r.streamService(c, serviceID, service, query, queryText, identity, publicKey, cursor, pageSize, rowsLeft, createQuery)
+			return
+		}
+
 		// 获取数据
 		// data := "[{'apple': 10}, {'apple': 20}, {'apple': 30}]"
-		data := r.dataBase(service.Credentials.DatabaseUser, service.Credentials.DatabasePassword, service.Credentials.DatabaseIP, service.Credentials.DatabasePort, service.Credentials.DatabaseName, service.Credentials.DatabaseTable)
-		cryData, err := r.EnCryptByEcies(data, publicKey)
+		data, dataRows, err := r.selectService(c.Request.Context(), service, query, identity)
+		if errors.Is(err, errDataSource) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
-			panic(err)
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// a result larger than the rows left is refused whole, not truncated
+		if _, rowsLeft := grant.Remaining(usage); rowsLeft >= 0 && dataRows > rowsLeft {
+			err = fmt.Errorf("quota exceeded: %d rows requested, %d left", dataRows, rowsLeft)
+			queryID := createQuery("", 0, chaincodeservice.LegitimacyQuota)
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "queryID": queryID})
+			return
 		}
-
 		hash := sha256.Sum256([]byte(data))
 		hashStr := fmt.Sprintf("%x", hash)
 
-		queryID := createQuery(hashStr, "true")
+		// the envelope is bound to the query, which has to be recorded first
+		if delivery != nil {
+			queryID := createQuery(hashStr, dataRows, chaincodeservice.LegitimacyGranted)
+			if queryID == "" {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record query"})
+				return
+			}
+			sendEnvelope(c, *delivery, data, publicKey, envelope.Context{
+				QueryID:   queryID,
+				ServiceID: serviceID,
+				Recipient: strings.ReplaceAll(identity, " ", ""),
+				Digest:    hashStr,
+			}, queryText)
+			return
+		}
+
+		cryData, err := r.encryptData(data, publicKey)
+		if err != nil {
+			fmt.Printf("failed to encrypt data for %s: %v\n", identity, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt data"})
+			return
+		}
+
+		queryID := createQuery(hashStr, dataRows, chaincodeservice.LegitimacyGranted)
+		if queryID == "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record query"})
+			return
+		}
 
-		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("data requested: %s", hashStr), "queryID": queryID, "data": cryData})
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("data requested: %s", hashStr), "queryID": queryID, "query": queryText, "data": cryData})
 	}
 }
@@ -0,0 +1,250 @@
+package routers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"service-client/keys"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Requests from one node to another are signed by the sender with its Fabric
+// key, as HTTP Message Signatures (RFC 9421) over one fixed set of
+// components:
+//
+//	Content-Digest: sha-256=:<base64 SHA-256 of the body>:
+//	Signature-Input: sig1=("@method" "@path" "content-digest");created=<unix seconds>;nonce="<hex>";alg="<algorithm>"
+//	Signature: sig1=:<base64 signature>:
+//
+// The algorithm follows the key of the sender: ecdsa-p256-sha256 and
+// ecdsa-p384-sha384 signatures are r||s, ed25519 ones as Ed25519 makes them.
+// The receiver checks the digest, that created is within the clock skew
+// window and that the nonce was not used before, and the signature with the
+// key the body names. Nothing is fetched from the sender to do so.
+
+const (
+	signatureLabel      = "sig1"
+	signatureComponents = `("@method" "@path" "content-digest")`
+	// defaultSignatureSkew is how far the clock of a sender may be off
+	defaultSignatureSkew = 5 * time.Minute
+)
+
+// signatureSkew is Config.SignatureSkew, or the default if it is not set
+// or invalid.
+func (c Config) signatureSkew() time.Duration {
+	if c.SignatureSkew != "" {
+		skew, err := time.ParseDuration(c.SignatureSkew)
+		if err == nil && skew > 0 {
+			return skew
+		}
+		fmt.Printf("invalid SignatureSkew %q, allowing %s\n", c.SignatureSkew, defaultSignatureSkew)
+	}
+	return defaultSignatureSkew
+}
+
+// signatureAlgorithms are the RFC 9421 names of the signatures of keys
+var signatureAlgorithms = map[string]string{
+	keys.P256:    "ecdsa-p256-sha256",
+	keys.P384:    "ecdsa-p384-sha384",
+	keys.Ed25519: "ed25519",
+}
+
+// signatureAlgorithm names the algorithm publicKey signs requests with.
+func signatureAlgorithm(publicKey crypto.PublicKey) (string, error) {
+	algorithm, err := keys.Algorithm(publicKey)
+	if err != nil {
+		return "", err
+	}
+	return signatureAlgorithms[algorithm], nil
+}
+
+func contentDigest(body []byte) string {
+	digest := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(digest[:]) + ":"
+}
+
+// signatureBase is the text signed for a request, see RFC 9421 section 2.5.
+func signatureBase(method string, path string, digest string, params string) string {
+	return fmt.Sprintf("\"@method\": %s\n\"@path\": %s\n\"content-digest\": %s\n\"@signature-params\": %s",
+		strings.ToUpper(method), path, digest, params)
+}
+
+// signRequest signs req, whose body is body, as me.
+func (r *Routers) signRequest(req *http.Request, body []byte) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	signer := r.OrgSetup.PrivateKeySigner
+	alg, err := signatureAlgorithm(signer.Public())
+	if err != nil {
+		return err
+	}
+	digest := contentDigest(body)
+	params := fmt.Sprintf("%s;created=%d;nonce=\"%s\";alg=\"%s\"", signatureComponents, time.Now().Unix(), hex.EncodeToString(nonce), alg)
+	signature, err := keys.Sign(signer, []byte(signatureBase(req.Method, req.URL.EscapedPath(), digest, params)))
+	if err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+	if publicKey, ok := signer.Public().(*ecdsa.PublicKey); ok {
+		signature, err = rawSignature(signature, publicKey.Curve)
+		if err != nil {
+			return err
+		}
+	}
+	req.Header.Set("Content-Digest", digest)
+	req.Header.Set("Signature-Input", signatureLabel+"="+params)
+	req.Header.Set("Signature", signatureLabel+"=:"+base64.StdEncoding.EncodeToString(signature)+":")
+	return nil
+}
+
+// rawSignature turns an ASN.1 ECDSA signature into the fixed size r||s
+// encoding RFC 9421 uses.
+func rawSignature(der []byte, curve elliptic.Curve) ([]byte, error) {
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("invalid ECDSA signature: %w", err)
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	raw := make([]byte, 2*size)
+	sig.R.FillBytes(raw[:size])
+	sig.S.FillBytes(raw[size:])
+	return raw, nil
+}
+
+// asn1Signature reverses rawSignature.
+func asn1Signature(raw []byte, curve elliptic.Curve) ([]byte, error) {
+	size := (curve.Params().BitSize + 7) / 8
+	if len(raw) != 2*size {
+		return nil, fmt.Errorf("invalid ECDSA signature")
+	}
+	return asn1.Marshal(struct{ R, S *big.Int }{
+		new(big.Int).SetBytes(raw[:size]),
+		new(big.Int).SetBytes(raw[size:]),
+	})
+}
+
+// signatureParams are the parameters of a Signature-Input
+type signatureParams struct {
+	raw     string
+	created time.Time
+	nonce   string
+	alg     string
+}
+
+func parseSignatureInput(header string) (signatureParams, error) {
+	params, ok := strings.CutPrefix(header, signatureLabel+"=")
+	if !ok {
+		return signatureParams{}, fmt.Errorf("no signature labelled %s", signatureLabel)
+	}
+	rest, ok := strings.CutPrefix(params, signatureComponents)
+	if !ok {
+		return signatureParams{}, fmt.Errorf("signature must cover %s", signatureComponents)
+	}
+	parsed := signatureParams{raw: params}
+	for _, param := range strings.Split(strings.TrimPrefix(rest, ";"), ";") {
+		key, value, _ := strings.Cut(param, "=")
+		switch key {
+		case "created":
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return signatureParams{}, fmt.Errorf("invalid created %q", value)
+			}
+			parsed.created = time.Unix(seconds, 0)
+		case "nonce":
+			parsed.nonce = strings.Trim(value, `"`)
+		case "alg":
+			parsed.alg = strings.Trim(value, `"`)
+		}
+	}
+	if parsed.created.IsZero() || parsed.nonce == "" {
+		return signatureParams{}, fmt.Errorf("signature must have created and nonce")
+	}
+	return parsed, nil
+}
+
+// verifyRequest checks the signature of a request to me, whose body is
+// body, against publicKey. It fails for requests that are unsigned, altered,
+// outside the clock skew window or replayed, and returns false for
+// signatures by another key.
+func (r *Routers) verifyRequest(c *gin.Context, body []byte, publicKey crypto.PublicKey) (bool, error) {
+	if key, ok := publicKey.(*ecdsa.PublicKey); publicKey == nil || ok && (key.X == nil || key.Y == nil) {
+		return false, fmt.Errorf("no public key to verify the request with")
+	}
+	alg, err := signatureAlgorithm(publicKey)
+	if err != nil {
+		return false, err
+	}
+	digest := c.GetHeader("Content-Digest")
+	if digest != contentDigest(body) {
+		return false, fmt.Errorf("Content-Digest does not match the body")
+	}
+	params, err := parseSignatureInput(c.GetHeader("Signature-Input"))
+	if err != nil {
+		return false, err
+	}
+	// the algorithm is that of the key, a request cannot choose another
+	if params.alg != alg {
+		return false, fmt.Errorf("signature algorithm %q does not match the %s key of the initiator", params.alg, alg)
+	}
+	encoded, ok := strings.CutPrefix(c.GetHeader("Signature"), signatureLabel+"=:")
+	if !ok || !strings.HasSuffix(encoded, ":") {
+		return false, fmt.Errorf("invalid Signature header")
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(encoded, ":"))
+	if err == nil {
+		if key, ok := publicKey.(*ecdsa.PublicKey); ok {
+			signature, err = asn1Signature(signature, key.Curve)
+		}
+	}
+	if err != nil {
+		return false, fmt.Errorf("invalid Signature header")
+	}
+
+	skew := r.Config.signatureSkew()
+	now := time.Now()
+	if params.created.Before(now.Add(-skew)) || params.created.After(now.Add(skew)) {
+		return false, fmt.Errorf("request signed at %s, outside the allowed clock skew of %s", params.created.UTC().Format(time.RFC3339), skew)
+	}
+	base := signatureBase(c.Request.Method, c.Request.URL.EscapedPath(), digest, params.raw)
+	if keys.Verify(publicKey, []byte(base), signature) != nil {
+		return false, nil
+	}
+	// a nonce is spent by a valid signature only, so that nobody else can
+	// spend it first
+	sender, err := keys.MarshalPublic(publicKey)
+	if err != nil {
+		return false, err
+	}
+	key := sender + "|" + params.nonce
+	if r.nonces == nil || !r.nonces.use(key, now) {
+		return false, fmt.Errorf("request replayed")
+	}
+	return true, nil
+}
+
+// authenticate verifies a request to me from identity holding publicKey, by
+// its signature, or for unsigned requests from nodes that predate signing
+// and if Config.LegacyCallback allows it, by a challenge to callbackURL.
+func (r *Routers) authenticate(c *gin.Context, body []byte, identity string, publicKey crypto.PublicKey, callbackURL string) (bool, error) {
+	if c.GetHeader("Signature") != "" {
+		return r.verifyRequest(c, body, publicKey)
+	}
+	if !r.Config.LegacyCallback {
+		return false, fmt.Errorf("request is not signed")
+	}
+	return r.execVerify(callbackURL, identity, publicKey)
+}
@@ -0,0 +1,130 @@
+package routers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"service-client/chaincodeservice"
+)
+
+// newDataRequest builds the /request_data request the consumer of n sends
+// for serviceID, signed.
+func (n *testNetwork) newDataRequest(t *testing.T, serviceID string) (*http.Request, []byte) {
+	t.Helper()
+	consumer := n.consumer.r
+	data := map[string]interface{}{
+		"ServiceID":         serviceID,
+		"InitiatorID":       consumer.OrgSetup.Identity,
+		"InitiatorURL":      consumer.MyURL,
+		"InitiatorIdentity": consumer.OrgSetup.Identity,
+		"InitiatorMSPID":    consumer.OrgSetup.MSPID,
+	}
+	if err := consumer.presentInitiator(data); err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, n.publisher.url+"/request_data", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := consumer.signRequest(req, body); err != nil {
+		t.Fatal(err)
+	}
+	return req, body
+}
+
+func send(t *testing.T, req *http.Request, body []byte) (int, map[string]interface{}) {
+	t.Helper()
+	req.Body, req.GetBody, req.ContentLength = io.NopCloser(bytes.NewReader(body)), nil, int64(len(body))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	return decodeResponse(t, res)
+}
+
+func TestSignedRequest(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	n.grant(t, serviceID, nil)
+	n.settle(t)
+
+	req, body := n.newDataRequest(t, serviceID)
+	if status, respData := send(t, req, body); status != http.StatusOK {
+		t.Fatalf("signed request: %d %v", status, respData)
+	}
+	// the same request again
+	if status, respData := send(t, req, body); status != http.StatusUnauthorized {
+		t.Fatalf("replayed request: %d %v", status, respData)
+	}
+}
+
+func TestUnsignedRequest(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	n.grant(t, serviceID, nil)
+	n.settle(t)
+
+	req, body := n.newDataRequest(t, serviceID)
+	for _, header := range []string{"Signature", "Signature-Input", "Content-Digest"} {
+		req.Header.Del(header)
+	}
+	if status, respData := send(t, req, body); status != http.StatusUnauthorized {
+		t.Fatalf("unsigned request: %d %v", status, respData)
+	}
+}
+
+func TestAlteredRequest(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	n.grant(t, serviceID, nil)
+	n.settle(t)
+
+	// a body other than the one signed
+	req, body := n.newDataRequest(t, serviceID)
+	altered := bytes.Replace(body, []byte(`"ServiceID"`), []byte(`"Columns":["name"],"ServiceID"`), 1)
+	if status, respData := send(t, req, altered); status != http.StatusUnauthorized {
+		t.Fatalf("request with another body: %d %v", status, respData)
+	}
+
+	// a body with its digest, under the signature of the one signed
+	req, body = n.newDataRequest(t, serviceID)
+	altered = bytes.Replace(body, []byte(`"ServiceID"`), []byte(`"Stream":true,"ServiceID"`), 1)
+	req.Header.Set("Content-Digest", contentDigest(altered))
+	if status, respData := send(t, req, altered); status == http.StatusOK {
+		t.Fatalf("request with another body and digest: %d %v", status, respData)
+	}
+
+	// signed long ago
+	req, body = n.newDataRequest(t, serviceID)
+	created := regexp.MustCompile(`created=\d+`)
+	stale := fmt.Sprintf("created=%d", time.Now().Add(-time.Hour).Unix())
+	req.Header.Set("Signature-Input", created.ReplaceAllString(req.Header.Get("Signature-Input"), stale))
+	if status, respData := send(t, req, body); status != http.StatusUnauthorized {
+		t.Fatalf("stale request: %d %v", status, respData)
+	}
+
+	// signed by a key other than that of the certificate presented
+	req, body = n.newDataRequest(t, serviceID)
+	if err := n.publisher.r.signRequest(req, body); err != nil {
+		t.Fatal(err)
+	}
+	if status, respData := send(t, req, body); status == http.StatusOK {
+		t.Fatalf("request signed by another key: %d %v", status, respData)
+	}
+	// signatures that do not verify are recorded, requests refused before
+	// are not
+	if refused := n.queriesOf(t, serviceID, chaincodeservice.LegitimacyUnknownUser); len(refused) != 2 {
+		t.Fatalf("refusals recorded: %+v", refused)
+	}
+}
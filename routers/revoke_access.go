@@ -0,0 +1,156 @@
+package routers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"service-client/chaincodeservice"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Take back access to one of my services in the front-end. Takes back and
+// burns every access token the initiator holds for it, keeps the reason in a
+// revocation record and notifies the initiator's node. Tokens the initiator
+// did not approve me for are named in the record as held, which counts as no
+// access.
+// Args: ServiceID, InitiatorID, Reason, InitiatorURL (optional)
+func (r *Routers) IRevokeAccess() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var httpData map[string]interface{}
+		if err := c.ShouldBindJSON(&httpData); err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		serviceID, _ := httpData["ServiceID"].(string)
+		recipientIdentity, _ := httpData["InitiatorID"].(string)
+		reason, _ := httpData["Reason"].(string)
+		initiatorURL, _ := httpData["InitiatorURL"].(string)
+		recipientIdentity = strings.ReplaceAll(recipientIdentity, " ", "")
+
+		service, valid := r.service(serviceID)
+		if !valid {
+			err := fmt.Errorf("service not found in config: %s", serviceID)
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if reason == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a reason is required to revoke access"})
+			return
+		}
+
+		burned, held, err := r.ServiceContract.RevokeAccess(recipientIdentity, serviceID)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "burned": burned, "held": held})
+			return
+		}
+		if len(burned)+len(held) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s holds no access token for %s", recipientIdentity, serviceID)})
+			return
+		}
+
+		revocation := chaincodeservice.Revocation{
+			ServiceID: serviceID,
+			Owner:     recipientIdentity,
+			TokenIDs:  burned,
+			Held:      held,
+			RevokedAt: time.Now().Unix(),
+			Reason:    reason,
+		}
+		revocation.TokenID, err = r.ServiceContract.RecordRevocation(revocation)
+		if err != nil {
+			fmt.Printf("failed to record revocation: %s\n", err)
+		}
+		queryID, err := r.QueryContract.CreateQuery("", "", 0, recipientIdentity, "", chaincodeservice.LegitimacyRevoked, service.RecordedTable(), "", serviceID)
+		if err != nil {
+			fmt.Printf("failed to record revocation: %s\n", err)
+		}
+
+		for i := range r.ApplicationToMe {
+			application := &r.ApplicationToMe[i]
+			if application.ServiceID == serviceID && strings.ReplaceAll(application.InitiatorID, " ", "") == recipientIdentity {
+				application.Status = 3
+				if initiatorURL == "" {
+					initiatorURL = application.InitiatorURL
+				}
+			}
+		}
+
+		notified := false
+		if initiatorURL != "" {
+			err = r.notifyRevocation(initiatorURL, serviceID, reason, append(burned, held...))
+			if err != nil {
+				fmt.Printf("failed to notify %s of revocation: %s\n", initiatorURL, err)
+			}
+			notified = err == nil
+		}
+
+		c.JSON(http.StatusOK, gin.H{"burned": burned, "held": held, "revocation": revocation, "queryID": queryID, "notified": notified})
+	}
+}
+
+func (r *Routers) notifyRevocation(initiatorURL, serviceID, reason string, tokenIDs []string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"ServiceID":    serviceID,
+		"Reason":       reason,
+		"TokenIDs":     tokenIDs,
+		"PublisherURL": r.MyURL,
+	})
+	if err != nil {
+		return err
+	}
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	res, err := httpClient.Post(initiatorURL+"/receive_revocation", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("receive_revocation returned %s", res.Status)
+	}
+	return nil
+}
+
+// Called by a publisher after it revoked my access. The notice is only
+// trusted once the ledger confirms the access is gone.
+// Args: ServiceID, Reason, TokenIDs, PublisherURL
+func (r *Routers) ReceiveRevocation() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var data map[string]interface{}
+		if err := c.ShouldBindJSON(&data); err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		serviceID, _ := data["ServiceID"].(string)
+		reason, _ := data["Reason"].(string)
+
+		access, err := r.hasAccessToService(serviceID)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if access {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("access to %s is not revoked on chain", serviceID)})
+			return
+		}
+
+		fmt.Printf("Access to %s revoked: %s\n", serviceID, reason)
+		for i := range r.MyApplication {
+			if r.MyApplication[i].ServiceID == serviceID {
+				r.MyApplication[i].Status = 3
+				r.MyApplication[i].Reason = reason
+				r.MyApplication[i].ProcessTime = time.Now().Format("2006-01-02 15:04:05")
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"success": "success"})
+	}
+}
@@ -0,0 +1,102 @@
+package routers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"service-client/chaincodeservice"
+)
+
+func TestRevokeAccess(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	tokenID := n.grant(t, serviceID, nil)
+	if status, respData := n.fetch(t, serviceID, nil); status != http.StatusOK {
+		t.Fatalf("fetch before revocation: %d %v", status, respData)
+	}
+
+	status, respData := post(t, n.publisher.url+"/revoke_access", map[string]interface{}{
+		"ServiceID":    serviceID,
+		"InitiatorID":  n.consumer.r.OrgSetup.Identity,
+		"Reason":       "contract ended",
+		"InitiatorURL": n.consumer.url,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("revoke_access: %d %v", status, respData)
+	}
+	burned, _ := respData["burned"].([]interface{})
+	if len(burned) != 1 || burned[0] != tokenID || respData["notified"] != true {
+		t.Fatalf("revoke_access answered %v", respData)
+	}
+	if status, respData := n.fetch(t, serviceID, nil); status == http.StatusOK {
+		t.Fatalf("fetch after revocation succeeded: %v", respData)
+	}
+	if application := n.consumer.r.MyApplication[0]; application.Status != 3 || application.Reason != "contract ended" {
+		t.Fatalf("consumer application after revocation: %+v", application)
+	}
+
+	// the reason is kept in a revocation record of its own
+	revocation, _ := respData["revocation"].(map[string]interface{})
+	recordID, _ := revocation["TokenID"].(string)
+	uri, err := n.publisher.r.ServiceContract.TokenURI(recordID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, ok, err := chaincodeservice.ParseRevocation(recordID, uri)
+	if err != nil || !ok || record.Reason != "contract ended" || record.ServiceID != serviceID {
+		t.Fatalf("revocation record %q: %+v, %v, %v", uri, record, ok, err)
+	}
+	revoked := n.queriesOf(t, serviceID, chaincodeservice.LegitimacyRevoked)
+	if len(revoked) != 1 || revoked[0].QueryDigest != "" {
+		t.Fatalf("revocation queries %+v", revoked)
+	}
+}
+
+func TestConsumerApprovesEachGrant(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	// grant returns once the consumer approved the publisher for the token
+	n.grant(t, serviceID, nil)
+	operator, err := n.consumer.r.ServiceContract.IsApprovedForAll(n.consumer.r.OrgSetup.Identity, n.publisher.r.OrgSetup.Identity)
+	if err != nil || operator {
+		t.Fatalf("publisher is operator of all tokens of the consumer: %v, %v", operator, err)
+	}
+}
+
+func TestRevokeGrantNotApproved(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	tokenID := n.grant(t, serviceID, nil)
+	// as for a grant made before consumers approved them one by one
+	if err := n.consumer.r.ServiceContract.Approve(n.consumer.r.OrgSetup.Identity, tokenID); err != nil {
+		t.Fatal(err)
+	}
+	if status, respData := n.fetch(t, serviceID, nil); status != http.StatusOK {
+		t.Fatalf("fetch with a grant not approved: %d %v", status, respData)
+	}
+
+	status, respData := post(t, n.publisher.url+"/revoke_access", map[string]interface{}{
+		"ServiceID":    serviceID,
+		"InitiatorID":  n.consumer.r.OrgSetup.Identity,
+		"Reason":       "contract ended",
+		"InitiatorURL": n.consumer.url,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("revoke_access: %d %v", status, respData)
+	}
+	burned, _ := respData["burned"].([]interface{})
+	held, _ := respData["held"].([]interface{})
+	if len(burned) != 0 || len(held) != 1 || held[0] != tokenID {
+		t.Fatalf("revoke_access answered %v", respData)
+	}
+	if owner, err := n.publisher.r.ServiceContract.OwnerOf(tokenID); err != nil || owner != strings.ReplaceAll(n.consumer.r.OrgSetup.Identity, " ", "") {
+		t.Fatalf("owner of the held token: %q, %v", owner, err)
+	}
+	if status, respData := n.fetch(t, serviceID, nil); status == http.StatusOK {
+		t.Fatalf("fetch with a held token succeeded: %v", respData)
+	}
+	if respData["notified"] != true {
+		t.Fatalf("consumer did not confirm the revocation: %v", respData)
+	}
+}
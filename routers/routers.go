@@ -1,11 +1,15 @@
 package routers
 
 import (
+	"context"
 	_ "crypto/ecdsa"
 	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"service-client/chaincodeservice"
-	"time"
+	"service-client/indexer"
+	"service-client/secrets"
+	"sync"
 )
 
 type Routers struct {
@@ -16,8 +20,18 @@ type Routers struct {
 	MyApplication   []ApplicationAnswer
 	Config          Config
 	configFile      string
+	// configMu guards Config against the writes of handlers and of
+	// ListenConfig. Services are read through service and services.
+	configMu        sync.RWMutex
 	OrgSetup        *chaincodeservice.OrgSetup
 	MyURL           string
+	Index           *indexer.Indexer
+	describeLimiter *rateLimiter
+	secrets         *secrets.Store
+	nonces          *nonceCache
+	msps            *chaincodeservice.MSPVerifier
+	// pending holds the publishers my requests wait on, see ReceiveMessage
+	pending *pendingPublishers
 }
 
 func Default(configFile string, getOrgSetup func(string) chaincodeservice.OrgSetup) *Routers {
@@ -44,33 +58,65 @@ func Default(configFile string, getOrgSetup func(string) chaincodeservice.OrgSet
 	bytes, _ := json.Marshal(orgSetup)
 	fmt.Printf("Initializing OrgSetup - OrgSetup %s\n", string(bytes))
 
+	r, err := New(port, myURL, configFile, config, orgSetup, orgSetup)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := r.sealConfig(); err != nil {
+		panic(fmt.Errorf("error sealing config secrets: %s", err))
+	}
+	r.ListenConfig()
+	r.ListenEvents(context.Background(), orgSetup)
+	r.SweepGrants()
+	if err := r.startIndex(orgSetup, loadRebuildIndex()); err != nil {
+		panic(err)
+	}
+
+	return r
+}
+
+// New builds the routers on top of an initialized OrgSetup, sending every
+// contract call through ledger. It does not start any listener.
+func New(port, myURL, configFile string, config Config, orgSetup *chaincodeservice.OrgSetup, ledger chaincodeservice.Ledger) (*Routers, error) {
+	if config.Services == nil {
+		config.Services = make(map[string]ServiceType)
+	}
+
 	// get contracts
-	queryContract := chaincodeservice.QueryContract{OrgSetup: orgSetup, ChaincodeName: config.QueryContract.ChaincodeName, ChannelID: config.QueryContract.ChannelID}
-	serviceContract := chaincodeservice.ServiceContract{OrgSetup: orgSetup, ChaincodeName: config.ServiceContract.ChaincodeName, ChannelID: config.ServiceContract.ChannelID}
+	queryContract := chaincodeservice.QueryContract{OrgSetup: orgSetup, Ledger: ledger, ChaincodeName: config.QueryContract.ChaincodeName, ChannelID: config.QueryContract.ChannelID}
+	serviceContract := chaincodeservice.ServiceContract{OrgSetup: orgSetup, Ledger: ledger, ChaincodeName: config.ServiceContract.ChaincodeName, ChannelID: config.ServiceContract.ChannelID}
 
 	// test contract
 	queries, err := queryContract.GetAllQuerys()
 	if err != nil {
-		panic(fmt.Errorf("error initializing QueryContract: %s, check network status", err))
+		return nil, fmt.Errorf("error initializing QueryContract: %s, check network status", err)
 	}
 	fmt.Printf("Initializing QueryContract - Queries: %d\n", len(queries))
 
 	// initialize service contract if needed
 	_ = serviceContract.Initialize("DataAccessCard", "dac", orgSetup.MSPID)
-	time.Sleep(5 * time.Second)
 	myIdentity, err := serviceContract.ClientAccountID()
 	if err != nil {
-		panic(fmt.Errorf("error initializing ServiceContract: %s, check service contract", err))
+		return nil, fmt.Errorf("error initializing ServiceContract: %s, check service contract", err)
 	}
 	services, err := serviceContract.GetServices()
 	if err != nil {
-		panic(fmt.Errorf("error initializing ServiceContract: %s, check service contract initialization", err))
+		return nil, fmt.Errorf("error initializing ServiceContract: %s, check service contract initialization", err)
+	}
+	secretStore, err := newSecretStore(config, orgSetup)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing secrets: %s", err)
+	}
+	msps, err := newMSPVerifier(config, orgSetup)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing MSPs: %s", err)
 	}
 	orgSetup.Identity = myIdentity
 	fmt.Printf("Initializing ServiceContract - My Identity: %s\n", myIdentity)
 	fmt.Printf("Initializing ServiceContract - Services: %d\n", len(services))
 
-	r := Routers{
+	return &Routers{
 		Port:            port,
 		QueryContract:   queryContract,
 		ServiceContract: serviceContract,
@@ -78,11 +124,40 @@ func Default(configFile string, getOrgSetup func(string) chaincodeservice.OrgSet
 		configFile:      configFile,
 		OrgSetup:        orgSetup,
 		MyURL:           myURL,
-	}
+		describeLimiter: newRateLimiter(config.describeRate()),
+		secrets:         secretStore,
+		nonces:          newNonceCache(2 * config.signatureSkew()),
+		msps:            msps,
+		pending:         newPendingPublishers(),
+	}, nil
+}
 
-	r.ListenConfig()
-	queryContract.StartListen(nil)
-	serviceContract.StartListen([]chaincodeservice.EventListener{r.ListenTransfer})
+// ListenEvents subscribes to the events of both contracts and dispatches them
+// until ctx is done. Checkpoints are kept per MSP under Config.CheckpointDir
+// so a restarted node resumes where it stopped. The returned wait blocks
+// until both subscriptions have stopped.
+func (r *Routers) ListenEvents(ctx context.Context, source chaincodeservice.EventSource) (wait func()) {
+	querySub := r.QueryContract.Subscribe(source, r.checkpointFile(r.QueryContract.ChaincodeName))
+	serviceSub := r.ServiceContract.Subscribe(source, r.checkpointFile(r.ServiceContract.ChaincodeName))
+	chaincodeservice.HandleEvent(serviceSub, "Transfer", r.ListenTransfer)
 
-	return &r
+	var done sync.WaitGroup
+	for _, sub := range []*chaincodeservice.EventSubscription{querySub, serviceSub} {
+		done.Add(1)
+		go func(sub *chaincodeservice.EventSubscription) {
+			defer done.Done()
+			if err := sub.Run(ctx); err != nil && err != context.Canceled {
+				fmt.Printf("Event subscription for %s stopped: %s\n", sub.ChaincodeName, err)
+			}
+		}(sub)
+	}
+	return done.Wait
+}
+
+func (r *Routers) checkpointFile(chaincodeName string) string {
+	dir := r.Config.CheckpointDir
+	if dir == "" {
+		dir = "checkpoints"
+	}
+	return filepath.Join(dir, r.OrgSetup.MSPID+"-"+chaincodeName+".json")
 }
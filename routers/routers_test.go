@@ -0,0 +1,385 @@
+package routers
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"service-client/chaincodeservice"
+	"service-client/keys"
+
+	"github.com/gin-gonic/gin"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// testNode is one node of a test network, served over HTTP and talking to
+// the emulator.
+type testNode struct {
+	r   *Routers
+	url string
+}
+
+// testNetwork is a publisher in the MSP owning ds_service and a consumer of
+// another MSP, trusting each other's CAs.
+type testNetwork struct {
+	emulator  *chaincodeservice.Emulator
+	publisher *testNode
+	consumer  *testNode
+}
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func newTestNetwork(t *testing.T) *testNetwork {
+	t.Helper()
+	return newTestNetworkOf(t, keys.P256, keys.P256)
+}
+
+// newTestNetworkOf is newTestNetwork with nodes holding keys of the
+// algorithms given.
+func newTestNetworkOf(t *testing.T, publisherAlgorithm string, consumerAlgorithm string) *testNetwork {
+	t.Helper()
+	e := chaincodeservice.NewEmulator()
+	// the first node initializes ds_service, which makes its MSP the owner
+	publisher := newTestNode(t, e, "Org1", "Org1MSP", "publisher", publisherAlgorithm)
+	consumer := newTestNode(t, e, "Org2", "Org2MSP", "consumer", consumerAlgorithm)
+	n := &testNetwork{emulator: e, publisher: publisher, consumer: consumer}
+	n.trust("Org1MSP", "Org2MSP")
+	return n
+}
+
+// trust makes every node of n accept the certificates issued for mspIDs.
+func (n *testNetwork) trust(mspIDs ...string) {
+	for _, mspID := range mspIDs {
+		ca, _ := n.emulator.CACertificate(mspID)
+		for _, node := range []*testNode{n.publisher, n.consumer} {
+			node.r.msps.AddCAs(mspID, []*x509.Certificate{ca}, nil)
+		}
+	}
+}
+
+func newTestNode(t *testing.T, e *chaincodeservice.Emulator, orgName, mspID, commonName, algorithm string) *testNode {
+	t.Helper()
+	orgSetup, ledger, err := e.EnrollAlgorithm(orgName, mspID, commonName, algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	var config Config
+	config.QueryContract.ChaincodeName, config.QueryContract.ChannelID = "ds_query", "ch"
+	config.ServiceContract.ChaincodeName, config.ServiceContract.ChannelID = "ds_service", "ch"
+	config.DownloadDir = filepath.Join(dir, "downloads")
+	config.CheckpointDir = filepath.Join(dir, "checkpoints")
+	config.IndexPath = filepath.Join(dir, "index.db")
+	configFile := filepath.Join(dir, "config.json")
+	if err := writeConfig(configFile, config); err != nil {
+		t.Fatal(err)
+	}
+
+	app := gin.New()
+	server := httptest.NewServer(app)
+	t.Cleanup(server.Close)
+	r, err := New("0", server.URL, configFile, config, orgSetup, ledger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.startIndex(e, false); err != nil {
+		t.Fatal(err)
+	}
+	index := r.Index
+	t.Cleanup(func() { index.Close() })
+	ctx, cancel := context.WithCancel(context.Background())
+	wait := r.ListenEvents(ctx, e)
+	t.Cleanup(func() {
+		cancel()
+		wait()
+	})
+	routeTestNode(app, r)
+	return &testNode{r: r, url: server.URL}
+}
+
+// settle waits until the index of every node has caught up with the tokens
+// and queries on the ledger. Nodes without an index are skipped.
+func (n *testNetwork) settle(t *testing.T) {
+	t.Helper()
+	nodes := []*testNode{n.publisher, n.consumer}
+	caughtUp := func() bool {
+		queries, err := n.publisher.r.QueryContract.GetAllQuerys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, node := range nodes {
+			if node.r.Index == nil {
+				continue
+			}
+			page, err := node.r.Index.FindQueries(chaincodeservice.QueryFilter{}, len(queries)+1, "")
+			if err != nil || len(page.Queries) != len(queries) {
+				return false
+			}
+			for _, owner := range nodes {
+				onLedger, err := node.r.ServiceContract.BalanceOf(owner.r.OrgSetup.Identity)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if indexed, err := node.r.Index.BalanceOf(owner.r.OrgSetup.Identity); err != nil || indexed != onLedger {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	for deadline := time.Now().Add(5 * time.Second); !caughtUp(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("index did not catch up with the ledger")
+		}
+	}
+}
+
+// routeTestNode serves the APIs of main.go, without the restriction of
+// internal ones to local clients.
+func routeTestNode(app *gin.Engine, r *Routers) {
+	app.POST("/send_application", r.SendApplication())
+	app.POST("/request_data", r.CRequestData())
+	app.POST("/put_service", r.IPutService())
+	app.POST("/update_service", r.IUpdateService())
+	app.POST("/set_service_state", r.ISetServiceState())
+	app.POST("/delete_service", r.IDeleteService())
+	app.POST("/forward_application", r.ForwardApplication())
+	app.POST("/receive_message", r.ReceiveMessage())
+	app.POST("/fetch_data", r.FetchData())
+	app.GET("/download", r.IDownload())
+	app.POST("/approve_application", r.IApproveApplication())
+	app.POST("/revoke_access", r.IRevokeAccess())
+	app.POST("/receive_revocation", r.ReceiveRevocation())
+	app.GET("/get_services", r.IGetServices())
+	app.GET("/get_queries", r.IGetQueries())
+	app.GET("/describe_service", r.DescribeService())
+}
+
+// post sends body as JSON and decodes the JSON response.
+func post(t *testing.T, url string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	return decodeResponse(t, res)
+}
+
+func get(t *testing.T, url string) (int, map[string]interface{}) {
+	t.Helper()
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	return decodeResponse(t, res)
+}
+
+func decodeResponse(t *testing.T, res *http.Response) (int, map[string]interface{}) {
+	t.Helper()
+	respBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var respData map[string]interface{}
+	if len(respBody) > 0 && json.Unmarshal(respBody, &respData) != nil {
+		t.Fatalf("response of %s is not JSON: %s", res.Request.URL, respBody)
+	}
+	return res.StatusCode, respData
+}
+
+// newTestTable creates a SQLite database holding table t with n rows of
+// id, name and region, where region alternates between Org1MSP and Org2MSP.
+func newTestTable(t *testing.T, n int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT, region TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= n; i++ {
+		region := []string{"Org1MSP", "Org2MSP"}[i%2]
+		if _, err := db.Exec("INSERT INTO t (id, name, region) VALUES (?, ?, ?)", i, fmt.Sprintf("name-%d", i), region); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+// publish puts a service reading table t of the SQLite database at path on
+// the publisher, with any further arguments of /put_service in extra.
+func (n *testNetwork) publish(t *testing.T, path string, extra map[string]interface{}) string {
+	t.Helper()
+	args := map[string]interface{}{
+		"serviceName": "test service",
+		"comment":     "rows of t",
+		"Type":        "sqlite",
+		"Options":     map[string]interface{}{"Path": path, "Table": "t"},
+	}
+	for key, value := range extra {
+		args[key] = value
+	}
+	status, respData := post(t, n.publisher.url+"/put_service", args)
+	if status != http.StatusOK {
+		t.Fatalf("put_service: %d %v", status, respData)
+	}
+	serviceID, _ := respData["serviceID"].(string)
+	return serviceID
+}
+
+// grant has the consumer apply for serviceID and the publisher approve it
+// with the terms in extra, see IApproveApplication. It returns once the
+// consumer approved the publisher for the access token, see ListenTransfer.
+func (n *testNetwork) grant(t *testing.T, serviceID string, extra map[string]interface{}) string {
+	t.Helper()
+	status, respData := post(t, n.consumer.url+"/forward_application", map[string]interface{}{
+		"ServiceID":    serviceID,
+		"ServiceName":  "test service",
+		"PublisherURL": n.publisher.url,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("forward_application: %d %v", status, respData)
+	}
+	args := map[string]interface{}{"ServiceID": serviceID, "InitiatorID": n.consumer.r.OrgSetup.Identity}
+	for key, value := range extra {
+		args[key] = value
+	}
+	status, respData = post(t, n.publisher.url+"/approve_application", args)
+	if status != http.StatusOK {
+		t.Fatalf("approve_application: %d %v", status, respData)
+	}
+	tokenID, _ := respData["tokenId"].(string)
+	n.awaitApproval(t, tokenID)
+	n.settle(t)
+	return tokenID
+}
+
+// awaitApproval waits until the consumer approved the publisher for the
+// access token tokenID.
+func (n *testNetwork) awaitApproval(t *testing.T, tokenID string) {
+	t.Helper()
+	publisher := strings.ReplaceAll(n.publisher.r.OrgSetup.Identity, " ", "")
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		approved, err := n.consumer.r.ServiceContract.GetApproved(tokenID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.ReplaceAll(approved, " ", "") == publisher {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("consumer did not approve the publisher for access token %s", tokenID)
+		}
+	}
+}
+
+// fetch has the consumer fetch serviceID with the arguments of /fetch_data
+// in extra.
+func (n *testNetwork) fetch(t *testing.T, serviceID string, extra map[string]interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	n.settle(t)
+	args := map[string]interface{}{"ServiceID": serviceID, "PublisherURL": n.publisher.url}
+	for key, value := range extra {
+		args[key] = value
+	}
+	return post(t, n.consumer.url+"/fetch_data", args)
+}
+
+// rowsOf decodes the rows of a /fetch_data response.
+func rowsOf(t *testing.T, respData map[string]interface{}) []map[string]interface{} {
+	t.Helper()
+	text, ok := respData["data"].(string)
+	if !ok {
+		t.Fatalf("no data in %v", respData)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal([]byte(text), &rows); err != nil {
+		t.Fatalf("invalid rows %q: %v", text, err)
+	}
+	return rows
+}
+
+// queriesOf lists the query records of serviceID with legitimacy.
+func (n *testNetwork) queriesOf(t *testing.T, serviceID string, legitimacy string) []chaincodeservice.Query {
+	t.Helper()
+	queries, err := n.publisher.r.QueryContract.FilterQueries(chaincodeservice.QueryFilter{ServiceID: serviceID, Legitimacy: legitimacy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queries
+}
+
+func TestPublishApplyApproveFetch(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	if serviceID == "" {
+		t.Fatal("no service ID")
+	}
+
+	// the consumer sees the service but has no access yet
+	status, respData := get(t, n.consumer.url+"/get_services")
+	if status != http.StatusOK {
+		t.Fatalf("get_services: %d %v", status, respData)
+	}
+	services, _ := respData["services"].([]interface{})
+	if len(services) != 1 || services[0].(map[string]interface{})["Approved"] != false {
+		t.Fatalf("services before approval: %v", services)
+	}
+	status, respData = n.fetch(t, serviceID, nil)
+	if status == http.StatusOK {
+		t.Fatalf("fetch without access succeeded: %v", respData)
+	}
+	if len(n.queriesOf(t, serviceID, chaincodeservice.LegitimacyNoAccess)) != 1 {
+		t.Fatal("refused fetch was not recorded")
+	}
+
+	if tokenID := n.grant(t, serviceID, nil); tokenID == "" {
+		t.Fatal("no access token minted")
+	}
+	if len(n.publisher.r.ApplicationToMe) != 1 {
+		t.Fatalf("publisher holds %d applications, want 1", len(n.publisher.r.ApplicationToMe))
+	}
+
+	status, respData = n.fetch(t, serviceID, nil)
+	if status != http.StatusOK {
+		t.Fatalf("fetch_data: %d %v", status, respData)
+	}
+	rows := rowsOf(t, respData)
+	if len(rows) != 3 || rows[0]["name"] != "name-1" {
+		t.Fatalf("fetched %v", rows)
+	}
+	granted := n.queriesOf(t, serviceID, chaincodeservice.LegitimacyGranted)
+	if len(granted) != 1 || granted[0].QueryID != respData["queryID"] || granted[0].DataRows != 3 {
+		t.Fatalf("granted queries %+v for response %v", granted, respData["queryID"])
+	}
+	if granted[0].InitiatorID != n.consumer.r.OrgSetup.Identity || granted[0].InitiatorMSPID != "Org2MSP" {
+		t.Fatalf("query recorded for %s of %s", granted[0].InitiatorID, granted[0].InitiatorMSPID)
+	}
+	// the record keeps the certificate the initiator was identified by
+	block, _ := pem.Decode([]byte(granted[0].Certificate))
+	if block == nil || !bytes.Equal(block.Bytes, n.consumer.r.OrgSetup.Certificate.Raw) {
+		t.Fatalf("query recorded with certificate %q", granted[0].Certificate)
+	}
+}
@@ -0,0 +1,182 @@
+package routers
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"service-client/chaincodeservice"
+	"service-client/keys"
+	"service-client/secrets"
+)
+
+// VaultConfig locates the Vault that "vault:" secret references are read
+// from. Token is a secret itself and is usually given as a reference.
+type VaultConfig struct {
+	Address string `json:"Address"`
+	Token   string `json:"Token"`
+}
+
+// secretOptions are the data source options that hold credentials
+var secretOptions = []string{"Password", "Token"}
+
+// vaultTokenName is the place of the Vault token among the secrets
+const vaultTokenName = "Vault/Token"
+
+// newSecretStore derives the key sealing the secrets of the config from
+// Config.MasterKeyFile, or from the private key of the org without one.
+// Secrets sealed under the org key have to be sealed again when the key of
+// the org changes, which a master key file avoids.
+func newSecretStore(config Config, orgSetup *chaincodeservice.OrgSetup) (*secrets.Store, error) {
+	var material []byte
+	if config.MasterKeyFile != "" {
+		data, err := os.ReadFile(config.MasterKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master key: %w", err)
+		}
+		material = bytes.TrimSpace(data)
+	} else if orgSetup.PrivateKey != nil {
+		key, err := keys.Material(orgSetup.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		material = key
+	} else {
+		return nil, fmt.Errorf("no master key file and no private key to seal secrets with")
+	}
+	key, err := secrets.DeriveKey(material)
+	if err != nil {
+		return nil, err
+	}
+	store, err := secrets.NewStore(key, nil)
+	if err != nil || config.Vault == nil {
+		return store, err
+	}
+	token, err := store.Resolve(vaultTokenName, config.Vault.Token)
+	if err != nil {
+		return nil, err
+	}
+	return secrets.NewStore(key, secrets.NewVault(config.Vault.Address, token))
+}
+
+// clone copies the options and the masking policy of a service, so that
+// its secrets can be rewritten without touching the config.
+func (s ServiceType) clone() ServiceType {
+	if s.Options != nil {
+		options := make(map[string]string, len(s.Options))
+		for key, value := range s.Options {
+			options[key] = value
+		}
+		s.Options = options
+	}
+	if s.Masking != nil {
+		masking := *s.Masking
+		s.Masking = &masking
+	}
+	return s
+}
+
+// eachSecret replaces every secret of a service, named by its place in the
+// config, by what fn returns for it. The service must be a clone.
+func eachSecret(serviceID string, service *ServiceType, fn func(name string, value string) (string, error)) error {
+	replace := func(name string, value *string) error {
+		if *value == "" {
+			return nil
+		}
+		replaced, err := fn(serviceID+"/"+name, *value)
+		if err != nil {
+			return err
+		}
+		*value = replaced
+		return nil
+	}
+	if err := replace("Credentials/DatabasePassword", &service.Credentials.DatabasePassword); err != nil {
+		return err
+	}
+	for _, key := range secretOptions {
+		if value, ok := service.Options[key]; ok {
+			if err := replace("Options/"+key, &value); err != nil {
+				return err
+			}
+			service.Options[key] = value
+		}
+	}
+	if service.Masking != nil {
+		return replace("Masking/Secret", &service.Masking.Secret)
+	}
+	return nil
+}
+
+// resolveService returns a service with its secrets in clear, to be used
+// and never stored.
+func (r *Routers) resolveService(serviceID string, service ServiceType) (ServiceType, error) {
+	service = service.clone()
+	if err := eachSecret(serviceID, &service, r.secrets.Resolve); err != nil {
+		return service, fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+	return service, nil
+}
+
+// sealSecrets returns config with every secret in clear sealed, and
+// whether there was any.
+func (r *Routers) sealSecrets(config Config) (Config, bool, error) {
+	sealed := false
+	seal := func(name string, value string) (string, error) {
+		if !secrets.IsPlain(value) {
+			return value, nil
+		}
+		sealed = true
+		return r.secrets.Seal(name, value)
+	}
+	services := make(map[string]ServiceType, len(config.Services))
+	for serviceID, service := range config.Services {
+		service = service.clone()
+		if err := eachSecret(serviceID, &service, seal); err != nil {
+			return config, false, err
+		}
+		services[serviceID] = service
+	}
+	config.Services = services
+	if config.Vault != nil && secrets.IsPlain(config.Vault.Token) {
+		vault := *config.Vault
+		token, err := seal(vaultTokenName, vault.Token)
+		if err != nil {
+			return config, false, err
+		}
+		vault.Token = token
+		config.Vault = &vault
+	}
+	return config, sealed, nil
+}
+
+// sealConfig seals the secrets a config file still holds in clear, as
+// written by earlier versions.
+func (r *Routers) sealConfig() error {
+	r.configMu.Lock()
+	defer r.configMu.Unlock()
+	if _, sealed, err := r.sealSecrets(r.Config); err != nil || !sealed {
+		return err
+	}
+	fmt.Println("Sealing plaintext secrets of the config")
+	return r.updateConfig()
+}
+
+// plaintextSecret names the first secret of config in clear, "" if there is
+// none.
+func plaintextSecret(config Config) string {
+	found := ""
+	find := func(name string, value string) (string, error) {
+		if found == "" && secrets.IsPlain(value) {
+			found = name
+		}
+		return value, nil
+	}
+	for serviceID, service := range config.Services {
+		service = service.clone()
+		eachSecret(serviceID, &service, find)
+	}
+	if found == "" && config.Vault != nil && secrets.IsPlain(config.Vault.Token) {
+		found = vaultTokenName
+	}
+	return found
+}
@@ -27,32 +27,43 @@ func (r *Routers) SendApplication() func(c *gin.Context) {
 			return
 		}
 		fmt.Println("send_application receive application:", application)
-		X := application["InitiatorPublicKeyX"].(string)
-		Y := application["InitiatorPublicKeyY"].(string)
-		InitiatorPublicKey := GetPublicKey(X, Y)
-		fmt.Println("initiatorpublickeyX: ", InitiatorPublicKey.X, "y:  ", InitiatorPublicKey.Y)
-		// InitiatorPublicKey, err := decodePublicKey(encodedPubKey.([]byte))
+		claimedID, _ := application["InitiatorID"].(string)
+		initiator, err := r.verifyInitiator(application, claimedID)
+		if err != nil {
+			fmt.Println("send_application verify certificate err: ", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		InitiatorPublicKey, initiatorID := initiator.PublicKey, initiator.Identity
 		fmt.Println("send_application decode public Key", InitiatorPublicKey)
-		// InitiatorPublicKey := application["InitiatorPublicKey"]
 		referer := c.Request.Referer()
 		newUrl := referer
 		// 验签
-		verified, err := r.execVerify(referer, InitiatorPublicKey)
+		verified, err := r.authenticate(c, body, initiatorID, InitiatorPublicKey, referer)
 		if err != nil {
 			fmt.Println("send_application verify signature err: ", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			return
 		}
 
 		if verified {
 			application["InitiatorURL"] = newUrl
+			application["InitiatorID"] = initiatorID
+			renewal, _ := application["Renewal"].(bool)
+			serviceID, _ := application["ServiceID"].(string)
+			if err := r.acceptsApplication(serviceID, renewal); err != nil {
+				fmt.Println("send_application refused: ", err)
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 			newApplication := Application{
 				InitiatorPublicKey: InitiatorPublicKey,
 				ApplicationTime:    time.Now().Format("2006-01-02 15:04:05"),
 				InitiatorURL:       newUrl,
-				InitiatorID:        application["InitiatorID"].(string),
+				InitiatorID:        initiatorID,
 				ServiceID:          application["ServiceID"].(string),
 				ServiceName:        application["ServiceName"].(string),
+				Renewal:            renewal,
 			}
 			fmt.Println("send_application successfully verify a signature.")
 			r.ApplicationToMe = append(r.ApplicationToMe, newApplication)
@@ -0,0 +1,194 @@
+package routers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"service-client/chaincodeservice"
+
+	"github.com/gin-gonic/gin"
+)
+
+// lookupService finds one of my services for a lifecycle request, answering
+// the request itself when there is none.
+func (r *Routers) lookupService(c *gin.Context, httpData map[string]interface{}) (string, ServiceType, bool) {
+	serviceID, _ := httpData["ServiceID"].(string)
+	service, valid := r.service(serviceID)
+	if !valid {
+		err := fmt.Errorf("service not found in config: %s", serviceID)
+		fmt.Printf("error: %v\n", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return serviceID, service, false
+	}
+	return serviceID, service, true
+}
+
+// acceptsApplication reports why an application for serviceID is refused,
+// nil if it is not. Deprecated services only take renewals.
+func (r *Routers) acceptsApplication(serviceID string, renewal bool) error {
+	service, valid := r.service(serviceID)
+	if !valid {
+		return fmt.Errorf("service not found in config: %s", serviceID)
+	}
+	switch service.state() {
+	case chaincodeservice.ServiceActive:
+		return nil
+	case chaincodeservice.ServiceDeprecated:
+		if renewal {
+			return nil
+		}
+		return fmt.Errorf("service %s is deprecated and takes no new applications", serviceID)
+	default:
+		return fmt.Errorf("service %s is %s", serviceID, service.state())
+	}
+}
+
+// Update one of my services in the front-end. The data source is probed
+// again before the update is saved, as on publish.
+//
+// Args: ServiceID, and any of the arguments of /put_service. Options are
+// merged into those of the service unless the Type changes; an option given
+// as "" is removed.
+func (r *Routers) IUpdateService() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var httpData map[string]interface{}
+		if err := c.ShouldBindJSON(&httpData); err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		serviceID, service, ok := r.lookupService(c, httpData)
+		if !ok {
+			return
+		}
+		service, err := parseService(httpData, service)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		schema, err := r.probeService(c.Request.Context(), serviceID, service)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, probeFailure(err))
+			return
+		}
+
+		if err := r.putService(serviceID, &service); err != nil {
+			err = fmt.Errorf("failed to update config: %v", err)
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"serviceID": serviceID,
+			"schema":    schema,
+			"error_msg": "None",
+		})
+	}
+}
+
+// Move one of my services to another lifecycle state in the front-end. The
+// registry token is minted again with the state, and with my current URL
+// should it have changed. Deprecated services keep serving the holders of
+// access tokens but take no new applications, unpublished ones are neither
+// listed nor served until they are made active again.
+//
+// Args: ServiceID, State (active, deprecated or unpublished)
+func (r *Routers) ISetServiceState() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var httpData map[string]interface{}
+		if err := c.ShouldBindJSON(&httpData); err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		serviceID, service, ok := r.lookupService(c, httpData)
+		if !ok {
+			return
+		}
+		state, _ := httpData["State"].(string)
+		switch state {
+		case chaincodeservice.ServiceActive, chaincodeservice.ServiceDeprecated, chaincodeservice.ServiceUnpublished:
+		default:
+			err := fmt.Errorf("invalid State %q, expected active, deprecated or unpublished", state)
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		record, err := r.ServiceContract.SetServiceState(serviceID, state, r.MyURL)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		service.State = state
+		if err := r.putService(serviceID, &service); err != nil {
+			err = fmt.Errorf("failed to update config: %v", err)
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"service": record})
+	}
+}
+
+// Delete one of my services in the front-end, for good. Every access token
+// of it is taken back and burned first, then its registry token is left as
+// a tombstone so that the service ID is never reused, and its config,
+// credentials included, is removed. A deletion cut short can be run again;
+// access tokens their holders did not approve me for are left with them and
+// answered as held.
+//
+// Args: ServiceID
+func (r *Routers) IDeleteService() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		var httpData map[string]interface{}
+		if err := c.ShouldBindJSON(&httpData); err != nil {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		serviceID, _, ok := r.lookupService(c, httpData)
+		if !ok {
+			return
+		}
+
+		var burned, held []string
+		grants, err := r.ServiceContract.AccessGrants(serviceID)
+		for _, grant := range grants {
+			err = r.ServiceContract.ReclaimGrant(grant)
+			if errors.Is(err, chaincodeservice.ErrNotApproved) {
+				held, err = append(held, grant.TokenID), nil
+				continue
+			}
+			if err != nil {
+				break
+			}
+			burned = append(burned, grant.TokenID)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to burn access tokens, service is not deleted: %v", err)
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "burned": burned, "held": held})
+			return
+		}
+
+		// a deletion cut short after the tombstone finds it in place
+		record, err := r.ServiceContract.SetServiceState(serviceID, chaincodeservice.ServiceDeleted, "")
+		if err != nil && record.State != chaincodeservice.ServiceDeleted {
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "burned": burned, "held": held})
+			return
+		}
+		if err := r.putService(serviceID, nil); err != nil {
+			err = fmt.Errorf("failed to update config: %v", err)
+			fmt.Printf("error: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "burned": burned, "held": held})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"serviceID": serviceID, "burned": burned, "held": held})
+	}
+}
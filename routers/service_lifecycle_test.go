@@ -0,0 +1,206 @@
+package routers
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"service-client/chaincodeservice"
+)
+
+// listedServices lists the services get_services shows node, by ID.
+func listedServices(t *testing.T, node *testNode) map[string]map[string]interface{} {
+	t.Helper()
+	status, respData := get(t, node.url+"/get_services")
+	if status != http.StatusOK {
+		t.Fatalf("get_services: %d %v", status, respData)
+	}
+	services := make(map[string]map[string]interface{})
+	list, _ := respData["services"].([]interface{})
+	for _, item := range list {
+		service, _ := item.(map[string]interface{})
+		serviceID, _ := service["ServiceID"].(string)
+		services[serviceID] = service
+	}
+	return services
+}
+
+// apply has the consumer apply for serviceID and reports whether the
+// publisher took the application.
+func (n *testNetwork) apply(t *testing.T, serviceID string, renewal bool) bool {
+	t.Helper()
+	before := len(n.publisher.r.ApplicationToMe)
+	status, respData := post(t, n.consumer.url+"/forward_application", map[string]interface{}{
+		"ServiceID":    serviceID,
+		"ServiceName":  "test service",
+		"PublisherURL": n.publisher.url,
+		"Renewal":      renewal,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("forward_application: %d %v", status, respData)
+	}
+	return len(n.publisher.r.ApplicationToMe) > before
+}
+
+func TestUpdateService(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+
+	status, respData := post(t, n.publisher.url+"/update_service", map[string]interface{}{
+		"ServiceID":   serviceID,
+		"serviceName": "renamed",
+		"Options":     map[string]interface{}{"Path": newTestTable(t, 5)},
+	})
+	if status != http.StatusOK {
+		t.Fatalf("update_service: %d %v", status, respData)
+	}
+	service, _ := n.publisher.r.service(serviceID)
+	if service.Information.DisplayName != "renamed" || service.Options["Table"] != "t" {
+		t.Fatalf("service after update: %+v", service)
+	}
+	n.grant(t, serviceID, nil)
+	status, respData = n.fetch(t, serviceID, nil)
+	if status != http.StatusOK || len(rowsOf(t, respData)) != 5 {
+		t.Fatalf("fetch after update: %d %v", status, respData)
+	}
+
+	// an update whose data source fails the probe is not saved
+	status, respData = post(t, n.publisher.url+"/update_service", map[string]interface{}{
+		"ServiceID": serviceID,
+		"Options":   map[string]interface{}{"Path": filepath.Join(t.TempDir(), "missing.db")},
+	})
+	if status != http.StatusBadRequest {
+		t.Fatalf("update to a missing data source: %d %v", status, respData)
+	}
+	if after, _ := n.publisher.r.service(serviceID); after.Options["Path"] != service.Options["Path"] {
+		t.Fatalf("failed update was saved: %+v", after)
+	}
+	if status, respData := post(t, n.publisher.url+"/update_service", map[string]interface{}{"ServiceID": "Service-99"}); status != http.StatusNotFound {
+		t.Fatalf("update of an unknown service: %d %v", status, respData)
+	}
+}
+
+func TestServiceStates(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	n.grant(t, serviceID, nil)
+	setState := func(state string) (int, map[string]interface{}) {
+		t.Helper()
+		status, respData := post(t, n.publisher.url+"/set_service_state", map[string]interface{}{"ServiceID": serviceID, "State": state})
+		n.settle(t)
+		return status, respData
+	}
+
+	// deprecated services serve holders and take renewals only
+	if status, respData := setState(chaincodeservice.ServiceDeprecated); status != http.StatusOK {
+		t.Fatalf("set_service_state deprecated: %d %v", status, respData)
+	}
+	if service, ok := listedServices(t, n.consumer)[serviceID]; !ok || service["State"] != chaincodeservice.ServiceDeprecated {
+		t.Fatalf("deprecated service listed as %v", service)
+	}
+	if status, respData := n.fetch(t, serviceID, nil); status != http.StatusOK {
+		t.Fatalf("fetch of a deprecated service: %d %v", status, respData)
+	}
+	if n.apply(t, serviceID, false) {
+		t.Fatal("deprecated service took a new application")
+	}
+	if !n.apply(t, serviceID, true) {
+		t.Fatal("deprecated service refused a renewal")
+	}
+
+	// unpublished services are neither listed nor served nor granted
+	if status, respData := setState(chaincodeservice.ServiceUnpublished); status != http.StatusOK {
+		t.Fatalf("set_service_state unpublished: %d %v", status, respData)
+	}
+	if service, ok := listedServices(t, n.consumer)[serviceID]; ok {
+		t.Fatalf("unpublished service listed as %v", service)
+	}
+	if status, respData := n.fetch(t, serviceID, nil); status == http.StatusOK {
+		t.Fatalf("fetch of an unpublished service succeeded: %v", respData)
+	}
+	if n.apply(t, serviceID, true) {
+		t.Fatal("unpublished service took an application")
+	}
+	status, respData := post(t, n.publisher.url+"/approve_application", map[string]interface{}{"ServiceID": serviceID, "InitiatorID": n.consumer.r.OrgSetup.Identity})
+	if status != http.StatusBadRequest {
+		t.Fatalf("grant of an unpublished service: %d %v", status, respData)
+	}
+
+	if status, respData := setState(chaincodeservice.ServiceActive); status != http.StatusOK {
+		t.Fatalf("set_service_state active: %d %v", status, respData)
+	}
+	if service, ok := listedServices(t, n.consumer)[serviceID]; !ok || service["State"] != chaincodeservice.ServiceActive {
+		t.Fatalf("active service listed as %v", service)
+	}
+	if status, respData := n.fetch(t, serviceID, nil); status != http.StatusOK {
+		t.Fatalf("fetch after publishing again: %d %v", status, respData)
+	}
+
+	for _, state := range []string{"", chaincodeservice.ServiceDeleted, "retired"} {
+		if status, respData := setState(state); status != http.StatusBadRequest {
+			t.Errorf("set_service_state %q: %d %v", state, status, respData)
+		}
+	}
+}
+
+func TestDeleteService(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	burnedID := n.grant(t, serviceID, nil)
+	heldID := n.grant(t, serviceID, nil)
+	// as for a grant made before consumers approved them one by one
+	if err := n.consumer.r.ServiceContract.Approve(n.consumer.r.OrgSetup.Identity, heldID); err != nil {
+		t.Fatal(err)
+	}
+
+	status, respData := post(t, n.publisher.url+"/delete_service", map[string]interface{}{"ServiceID": serviceID})
+	if status != http.StatusOK {
+		t.Fatalf("delete_service: %d %v", status, respData)
+	}
+	burned, _ := respData["burned"].([]interface{})
+	held, _ := respData["held"].([]interface{})
+	if len(burned) != 1 || burned[0] != burnedID || len(held) != 1 || held[0] != heldID {
+		t.Fatalf("delete_service answered %v", respData)
+	}
+	if _, err := n.publisher.r.ServiceContract.OwnerOf(burnedID); err == nil {
+		t.Fatalf("access token %s of the deleted service was not burned", burnedID)
+	}
+	if _, ok := n.publisher.r.service(serviceID); ok {
+		t.Fatal("config of the deleted service was kept")
+	}
+	n.settle(t)
+	if service, ok := listedServices(t, n.consumer)[serviceID]; ok {
+		t.Fatalf("deleted service listed as %v", service)
+	}
+	if status, respData := n.fetch(t, serviceID, nil); status == http.StatusOK {
+		t.Fatalf("fetch of a deleted service succeeded: %v", respData)
+	}
+	// the tombstone keeps the ID from being registered again
+	if _, err := n.publisher.r.ServiceContract.SetServiceState(serviceID, chaincodeservice.ServiceActive, ""); err == nil {
+		t.Fatal("deleted service was published again")
+	}
+	if status, respData := post(t, n.publisher.url+"/delete_service", map[string]interface{}{"ServiceID": serviceID}); status != http.StatusNotFound {
+		t.Fatalf("second delete_service: %d %v", status, respData)
+	}
+}
+
+func TestResumeDeleteService(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 3), nil)
+	tokenID := n.grant(t, serviceID, nil)
+	// a deletion cut short after the tombstone, with the config left
+	if _, err := n.publisher.r.ServiceContract.SetServiceState(serviceID, chaincodeservice.ServiceDeleted, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	status, respData := post(t, n.publisher.url+"/delete_service", map[string]interface{}{"ServiceID": serviceID})
+	if status != http.StatusOK {
+		t.Fatalf("delete_service after a tombstone: %d %v", status, respData)
+	}
+	if burned, _ := respData["burned"].([]interface{}); len(burned) != 1 || burned[0] != tokenID {
+		t.Fatalf("delete_service answered %v", respData)
+	}
+	if _, ok := n.publisher.r.service(serviceID); ok {
+		t.Fatal("config of the deleted service was kept")
+	}
+}
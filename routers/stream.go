@@ -0,0 +1,464 @@
+package routers
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"service-client/chaincodeservice"
+	"service-client/datasource"
+	"service-client/keys"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A streamed result is sent as newline-delimited JSON frames, one chunk of
+// rows per frame. Every chunk is encrypted for the initiator on its own, and
+// the MAC of the encryption covers the service, the initiator, the cursor the
+// stream starts at and the position of the chunk, so chunks cannot be
+// dropped, reordered or mixed between streams unnoticed. The first chunk
+// holds the schema and the query, the last one the outcome; a stream without
+// its final chunk was cut short. Anyone can encrypt for the initiator, so the
+// final chunk names the query record, whose digest and rows the initiator
+// checks on the ledger.
+
+const (
+	defaultPageSize = 1000
+	maxPageSize     = 10000
+	ndjsonType      = "application/x-ndjson"
+)
+
+type streamFrame struct {
+	Seq  int    `json:"Seq"`
+	Data string `json:"Data"` // hex ciphertext of a streamChunk, see keys.Encrypt
+}
+
+type streamChunk struct {
+	Schema []datasource.Column `json:"Schema,omitempty"`
+	Query  string              `json:"Query,omitempty"`
+	Rows   json.RawMessage     `json:"Rows,omitempty"`
+	// Cursor is the row to continue from after this chunk
+	Cursor int64 `json:"Cursor"`
+	Final  bool  `json:"Final,omitempty"`
+	// final chunk: the query record, the SHA-256 over the Rows of every
+	// chunk in order, and why the stream stopped early if it did
+	QueryID string `json:"QueryID,omitempty"`
+	Digest  string `json:"Digest,omitempty"`
+	Error   string `json:"Error,omitempty"`
+}
+
+// parseStreamArgs reads the optional Cursor (first row to send, counting
+// from 0) and PageSize (rows per chunk) of a streamed data request.
+func parseStreamArgs(httpData map[string]interface{}) (int64, int, error) {
+	number := func(key string) (int64, error) {
+		switch v := httpData[key].(type) {
+		case nil:
+			return 0, nil
+		case float64:
+			if v < 0 || v != float64(int64(v)) {
+				return 0, fmt.Errorf("%s must be a non-negative integer", key)
+			}
+			return int64(v), nil
+		case string:
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("%s must be a non-negative integer", key)
+			}
+			return n, nil
+		default:
+			return 0, fmt.Errorf("%s must be a non-negative integer", key)
+		}
+	}
+	cursor, err := number("Cursor")
+	if err != nil {
+		return 0, 0, err
+	}
+	pageSize, err := number("PageSize")
+	if err != nil {
+		return 0, 0, err
+	}
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		return 0, 0, fmt.Errorf("PageSize must be at most %d", maxPageSize)
+	}
+	return cursor, int(pageSize), nil
+}
+
+// streamContext identifies a stream by what both ends know before it starts:
+// the service, the initiator and the cursor the stream starts at.
+func streamContext(serviceID string, recipient string, cursor int64) string {
+	return serviceID + "|" + strings.ReplaceAll(recipient, " ", "") + "|" + strconv.FormatInt(cursor, 10)
+}
+
+// chunkMAC binds a chunk to its stream and position
+func chunkMAC(stream string, seq int) []byte {
+	return []byte(stream + "|" + strconv.Itoa(seq))
+}
+
+func sealChunk(publicKey crypto.PublicKey, stream string, seq int, chunk streamChunk) (streamFrame, error) {
+	plaintext, err := json.Marshal(chunk)
+	if err != nil {
+		return streamFrame{}, err
+	}
+	ciphertext, err := keys.Encrypt(publicKey, plaintext, chunkMAC(stream, seq))
+	if err != nil {
+		return streamFrame{}, err
+	}
+	return streamFrame{Seq: seq, Data: hex.EncodeToString(ciphertext)}, nil
+}
+
+func openChunk(privateKey crypto.PrivateKey, stream string, frame streamFrame) (streamChunk, error) {
+	var chunk streamChunk
+	ciphertext, err := hex.DecodeString(frame.Data)
+	if err != nil {
+		return chunk, fmt.Errorf("chunk %d: %w", frame.Seq, err)
+	}
+	plaintext, err := keys.Decrypt(privateKey, ciphertext, chunkMAC(stream, frame.Seq))
+	if err != nil {
+		return chunk, fmt.Errorf("chunk %d failed to decrypt: %w", frame.Seq, err)
+	}
+	if err := json.Unmarshal(plaintext, &chunk); err != nil {
+		return chunk, fmt.Errorf("chunk %d: %w", frame.Seq, err)
+	}
+	return chunk, nil
+}
+
+// streamService sends the result of q to recipient as a stream of chunks of
+// pageSize rows, starting at row cursor. When rowsLeft is not negative at
+// most that many rows are sent, and a stream stopped by the quota ends with
+// an error and the cursor to continue from. The query is recorded with the
+// rows actually sent once the stream ends, also when the initiator went away
+// in the middle of it.
+func (r *Routers) streamService(c *gin.Context, serviceID string, service ServiceType, q datasource.Query, queryText string, recipient string, publicKey crypto.PublicKey, cursor int64, pageSize int, rowsLeft int, createQuery func(string, int, string) string) {
+	ctx := c.Request.Context()
+	typ, options := service.DataSource()
+	source, err := datasource.Open(typ, options)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open data source"})
+		return
+	}
+	defer source.Close()
+
+	columns, err := source.Columns(ctx)
+	if err != nil {
+		fmt.Printf("failed to read schema of %s: %v\n", service.Table(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read data source"})
+		return
+	}
+	if err := q.Validate(columns); err != nil {
+		err = fmt.Errorf("invalid query on %s: %s", service.Table(), err)
+		fmt.Printf("error: %v\n", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := source.Stream(ctx, q, cursor)
+	if err != nil {
+		fmt.Printf("failed to read %s: %v\n", service.Table(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read data source"})
+		return
+	}
+	defer rows.Close()
+
+	recipient = strings.ReplaceAll(recipient, " ", "")
+	header := datasource.Result{Schema: rows.Schema()}
+	service.Masking.Apply(&header, recipient)
+
+	stream := streamContext(serviceID, recipient, cursor)
+	c.Header("Content-Type", ndjsonType)
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	seq := 0
+	send := func(chunk streamChunk) error {
+		frame, err := sealChunk(publicKey, stream, seq, chunk)
+		if err != nil {
+			return err
+		}
+		seq++
+		if err := encoder.Encode(frame); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+
+	digest := sha256.New()
+	sent := 0
+	page := make([]datasource.Row, 0, pageSize)
+	flush := func() error {
+		if len(page) == 0 {
+			return nil
+		}
+		result := datasource.Result{Schema: rows.Schema(), Rows: page}
+		service.Masking.Apply(&result, recipient)
+		data, err := json.Marshal(result.Rows)
+		if err != nil {
+			return err
+		}
+		if err := send(streamChunk{Rows: data, Cursor: cursor + int64(sent+len(page))}); err != nil {
+			return err
+		}
+		digest.Write(data)
+		sent += len(page)
+		page = page[:0]
+		return nil
+	}
+
+	var stopped string
+	err = send(streamChunk{Schema: header.Schema, Query: queryText, Cursor: cursor})
+	for err == nil && rows.Next() {
+		if rowsLeft >= 0 && sent+len(page) >= rowsLeft {
+			stopped = fmt.Sprintf("quota exceeded: %d rows sent, continue from cursor %d", sent+len(page), cursor+int64(sent+len(page)))
+			break
+		}
+		page = append(page, rows.Row())
+		if len(page) == pageSize {
+			err = flush()
+		}
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err == nil && stopped == "" {
+		if readErr := rows.Err(); readErr != nil {
+			fmt.Printf("failed to read %s: %v\n", service.Table(), readErr)
+			stopped = "failed to read data source"
+		}
+	}
+
+	hashStr := fmt.Sprintf("%x", digest.Sum(nil))
+	queryID := createQuery(hashStr, sent, chaincodeservice.LegitimacyGranted)
+	if err != nil {
+		fmt.Printf("stream of %s to %s broken after %d rows: %v\n", service.Table(), recipient, sent, err)
+		return
+	}
+	final := streamChunk{Final: true, Cursor: cursor + int64(sent), QueryID: queryID, Digest: hashStr, Error: stopped}
+	if err := send(final); err != nil {
+		fmt.Printf("stream of %s to %s broken at its end: %v\n", service.Table(), recipient, err)
+	}
+}
+
+// reassembler writes the rows of a stream to a file, one JSON object per
+// line, as soon as the chunk holding them has been verified.
+type reassembler struct {
+	stream     string
+	privateKey crypto.PrivateKey
+	writer     *bufio.Writer
+	digest     hash.Hash
+	seq        int
+	cursor     int64
+	schema     []datasource.Column
+	query      string
+	final      *streamChunk
+}
+
+func (a *reassembler) add(frame streamFrame) error {
+	if frame.Seq != a.seq {
+		return fmt.Errorf("chunk %d out of order, expected %d", frame.Seq, a.seq)
+	}
+	chunk, err := openChunk(a.privateKey, a.stream, frame)
+	if err != nil {
+		return err
+	}
+	a.seq++
+
+	switch {
+	case frame.Seq == 0:
+		if chunk.Cursor != a.cursor {
+			return fmt.Errorf("stream starts at row %d, expected %d", chunk.Cursor, a.cursor)
+		}
+		a.schema = chunk.Schema
+		a.query = chunk.Query
+	case chunk.Final:
+		if chunk.Cursor != a.cursor || chunk.Digest != hex.EncodeToString(a.digest.Sum(nil)) {
+			return fmt.Errorf("stream does not match its final chunk")
+		}
+		a.final = &chunk
+	default:
+		var rows []json.RawMessage
+		if err := json.Unmarshal(chunk.Rows, &rows); err != nil {
+			return fmt.Errorf("chunk %d: %w", frame.Seq, err)
+		}
+		if chunk.Cursor != a.cursor+int64(len(rows)) {
+			return fmt.Errorf("chunk %d ends at row %d, expected %d", frame.Seq, chunk.Cursor, a.cursor+int64(len(rows)))
+		}
+		for _, row := range rows {
+			a.writer.Write(row)
+			a.writer.WriteByte('\n')
+		}
+		if err := a.writer.Flush(); err != nil {
+			return err
+		}
+		a.digest.Write(chunk.Rows)
+		a.cursor = chunk.Cursor
+	}
+	return nil
+}
+
+// receiveStream reassembles a streamed result into a file under
+// Config.DownloadDir. Rows are on disk once their chunk is verified, so a
+// broken stream leaves a partial file that a later fetch with the reported
+// cursor continues. A complete result is stored as the data set of the
+// query that completed it, and sent in format if one is given.
+func (r *Routers) receiveStream(c *gin.Context, res *http.Response, serviceID string, q datasource.Query, cursor int64, format string) {
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), ndjsonType) {
+		err := fmt.Errorf("publisher does not support streaming")
+		fmt.Printf("error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	base, err := r.partPath(serviceID, q)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	partPath := base + ".part"
+	file, err := openPart(partPath, cursor)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	assembler := &reassembler{
+		stream:     streamContext(serviceID, r.OrgSetup.Identity, cursor),
+		privateKey: r.QueryContract.OrgSetup.PrivateKey,
+		writer:     bufio.NewWriter(file),
+		digest:     sha256.New(),
+		cursor:     cursor,
+	}
+	decoder := json.NewDecoder(res.Body)
+	for assembler.final == nil {
+		var frame streamFrame
+		if err = decoder.Decode(&frame); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("stream ended without its final chunk")
+			}
+			break
+		}
+		if err = assembler.add(frame); err != nil {
+			break
+		}
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "file": partPath, "cursor": assembler.cursor})
+		return
+	}
+	final := assembler.final
+	if err := r.checkStreamRecord(serviceID, *final, final.Cursor-cursor); err != nil {
+		fmt.Printf("error: %v\n", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "file": partPath, "cursor": cursor})
+		return
+	}
+	if final.Error != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": final.Error, "file": partPath, "cursor": final.Cursor, "queryID": final.QueryID})
+		return
+	}
+
+	dataset, err := r.datasetPath(serviceID, final.QueryID)
+	if err == nil {
+		err = writeSchema(dataset, assembler.schema)
+	}
+	if err == nil {
+		err = os.Rename(partPath, dataset+".ndjson")
+	}
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "file": partPath, "cursor": final.Cursor})
+		return
+	}
+	if format != "" {
+		r.sendDataset(c, serviceID, final.QueryID, format)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"file": dataset + ".ndjson", "rows": final.Cursor, "schema": assembler.schema, "queryID": final.QueryID, "query": assembler.query, "digest": final.Digest})
+}
+
+// checkStreamRecord checks that the query record named by the final chunk
+// of a stream of serviceID was made for me, with the digest and the number
+// of rows of the stream.
+func (r *Routers) checkStreamRecord(serviceID string, final streamChunk, rows int64) error {
+	record, err := r.QueryContract.GetQuery(final.QueryID)
+	if err != nil {
+		return fmt.Errorf("stream names no query record: %w", err)
+	}
+	if record.ServiceID != serviceID || record.Legitimacy != chaincodeservice.LegitimacyGranted ||
+		strings.ReplaceAll(record.InitiatorID, " ", "") != strings.ReplaceAll(r.OrgSetup.Identity, " ", "") {
+		return fmt.Errorf("query %s is not a granted query of mine on %s", final.QueryID, serviceID)
+	}
+	if record.DataDigest != final.Digest || int64(record.DataRows) != rows {
+		return fmt.Errorf("stream does not match the record of query %s", final.QueryID)
+	}
+	return nil
+}
+
+// partPath names the partial file of a streamed result, without extension:
+// the service and the digest of the query, so that continuing a fetch finds
+// the file it started.
+func (r *Routers) partPath(serviceID string, q datasource.Query) (string, error) {
+	if !downloadName.MatchString(serviceID) {
+		return "", fmt.Errorf("invalid service ID %q", serviceID)
+	}
+	dir, err := r.downloadDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, serviceID+"-"+q.Digest(serviceID)[:12]), nil
+}
+
+// openPart opens the partial file of a stream for the rows from cursor on.
+// A stream continued from a cursor must find exactly that many rows.
+func openPart(path string, cursor int64) (*os.File, error) {
+	if cursor == 0 {
+		return os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot continue from cursor %d: %w", cursor, err)
+	}
+	lines, err := countLines(file)
+	if err == nil && lines != cursor {
+		err = fmt.Errorf("%s holds %d rows, cannot continue from cursor %d", path, lines, cursor)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func countLines(reader io.Reader) (int64, error) {
+	var lines int64
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := reader.Read(buf)
+		for _, b := range buf[:n] {
+			if b == '\n' {
+				lines++
+			}
+		}
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return lines, err
+		}
+	}
+}
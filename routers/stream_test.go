@@ -0,0 +1,110 @@
+package routers
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStreamedFetch(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 5), nil)
+	n.grant(t, serviceID, nil)
+
+	status, respData := n.fetch(t, serviceID, map[string]interface{}{"Stream": true, "PageSize": 2})
+	if status != http.StatusOK {
+		t.Fatalf("streamed fetch: %d %v", status, respData)
+	}
+	file, _ := respData["file"].(string)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 5 || respData["rows"] != float64(5) {
+		t.Fatalf("streamed %d rows to %s, response %v", len(lines), file, respData)
+	}
+	for i, line := range lines {
+		var row map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &row); err != nil || row["id"] != float64(i+1) {
+			t.Fatalf("row %d is %s: %v", i, line, err)
+		}
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("data set readable by others: %v, %v", info.Mode(), err)
+	}
+}
+
+func TestStreamContinuesFromCursor(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 5), nil)
+	n.grant(t, serviceID, map[string]interface{}{"MaxRows": 3})
+
+	status, respData := n.fetch(t, serviceID, map[string]interface{}{"Stream": true, "PageSize": 2})
+	if status == http.StatusOK || respData["cursor"] != float64(3) {
+		t.Fatalf("stream beyond the quota: %d %v", status, respData)
+	}
+	part, _ := respData["file"].(string)
+	if info, err := os.Stat(part); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("partial file readable by others: %v, %v", info.Mode(), err)
+	}
+
+	n.grant(t, serviceID, nil)
+	status, respData = n.fetch(t, serviceID, map[string]interface{}{"Stream": true, "PageSize": 2, "Cursor": 3})
+	if status != http.StatusOK || respData["rows"] != float64(5) {
+		t.Fatalf("continued stream: %d %v", status, respData)
+	}
+	data, err := os.ReadFile(respData["file"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 5 || !strings.Contains(lines[3], `"id":4`) {
+		t.Fatalf("continued stream holds %v", lines)
+	}
+}
+
+// A stream anyone could have encrypted for the consumer is refused unless
+// the ledger holds its record.
+func TestForgedStream(t *testing.T) {
+	n := newTestNetwork(t)
+	serviceID := n.publish(t, newTestTable(t, 1), nil)
+	consumer := n.consumer.r.OrgSetup
+
+	forger := gin.New()
+	forger.POST("/request_data", func(c *gin.Context) {
+		stream := streamContext(serviceID, consumer.Identity, 0)
+		c.Header("Content-Type", ndjsonType)
+		encoder := json.NewEncoder(c.Writer)
+		rows := json.RawMessage(`[{"id":1,"name":"forged"}]`)
+		for seq, chunk := range []streamChunk{
+			{Query: "SELECT * FROM t"},
+			{Rows: rows, Cursor: 1},
+			{Final: true, Cursor: 1, QueryID: "Org1MSP-forged", Digest: fmt.Sprintf("%x", sha256.Sum256(rows))},
+		} {
+			frame, err := sealChunk(consumer.PublicKey, stream, seq, chunk)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			encoder.Encode(frame)
+		}
+	})
+	server := httptest.NewServer(forger)
+	defer server.Close()
+
+	status, respData := post(t, n.consumer.url+"/fetch_data", map[string]interface{}{
+		"ServiceID":    serviceID,
+		"PublisherURL": server.URL,
+		"Stream":       true,
+	})
+	if status == http.StatusOK {
+		t.Fatalf("forged stream accepted: %v", respData)
+	}
+}
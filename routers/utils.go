@@ -6,7 +6,6 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -17,14 +16,11 @@ import (
 	"strings"
 	"time"
 
-	"database/sql"
+	"service-client/keys"
 
 	"github.com/ethereum/go-ethereum/crypto/ecies"
-	_ "github.com/go-sql-driver/mysql"
 )
 
-var nilData = "[{\"data\": \"nil\"}]"
-
 func GetPublicKey(X, Y string) *ecdsa.PublicKey {
 	InitiatorPublicKey := new(ecdsa.PublicKey)
 	InitiatorPublicKey.X, _ = new(big.Int).SetString(X, 10)
@@ -55,10 +51,10 @@ func getOuterIP() (ipv4 string, err error) {
 	return strings.TrimSpace(string(ip)), nil
 }
 
+// SignMessage signs message as signer would for its algorithm, see
+// keys.Sign.
 func SignMessage(message string, signer crypto.Signer) (string, error) {
-	hash := sha256.Sum256([]byte(message))
-
-	signature, err := signer.Sign(rand.Reader, hash[:], crypto.SHA256)
+	signature, err := keys.Sign(signer, []byte(message))
 	if err != nil {
 		return "", fmt.Errorf("failed to sign message: %w", err)
 	}
@@ -67,35 +63,34 @@ func SignMessage(message string, signer crypto.Signer) (string, error) {
 	return signatureStr, nil
 }
 
-func verifySignature(message, signature string, publicKey *ecdsa.PublicKey) error {
+func verifySignature(message, signature string, publicKey crypto.PublicKey) error {
 	decodedSignature, err := base64.StdEncoding.DecodeString(signature)
 	if err != nil {
 		return fmt.Errorf("failed to decode signature: %w", err)
 	}
 
-	hash := sha256.Sum256([]byte(message))
-
-	// 将 *ecdsa.PublicKey 转换为 crypto.PublicKey
-	if !ecdsa.VerifyASN1(publicKey, hash[:], decodedSignature) {
-		return fmt.Errorf("invalid signature")
+	if err := keys.Verify(publicKey, []byte(message), decodedSignature); err != nil {
+		return err
 	}
 	fmt.Println("VerifySignature successfully verify a signature")
 	return nil
 }
 
-// Login by verifying the signature
-func (r *Routers) execVerify(referer string, InitiatorPublicKey *ecdsa.PublicKey) (bool, error) {
-	// 1. 生成随机message
-	randomMessage := generateRandomMessage()
-	fmt.Println("execVerify generate random message: ", randomMessage)
+// Login by verifying the signature of identity over a Challenge, see
+// ReceiveMessage
+func (r *Routers) execVerify(referer string, identity string, InitiatorPublicKey crypto.PublicKey) (bool, error) {
+	// 1. 生成challenge
+	challenge, err := newChallenge(r.MyURL, identity, purposeAuthenticate)
+	if err != nil {
+		return false, err
+	}
 
-	// 2. 将随机message发送给申请方的receive_message接口
+	// 2. 将challenge发送给申请方的receive_message接口
 	// 2.1 获取对方的url
 	newUrl := referer + "/receive_message"
 	// 2.2 构造payload
 	payload := map[string]interface{}{
-		"message":      randomMessage,
-		"PublisherUrl": r.MyURL,
+		"Challenge": challenge,
 	}
 	fmt.Println("execVerify generate payload: ", payload)
 	body, err := json.Marshal(payload)
@@ -148,7 +143,10 @@ func (r *Routers) execVerify(referer string, InitiatorPublicKey *ecdsa.PublicKey
 		// c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return false, err
 	}
-	verifiedErr := verifySignature(randomMessage, signedMessage, InitiatorPublicKey)
+	if time.Now().Unix() >= challenge.Expires {
+		return false, fmt.Errorf("challenge expired before it was answered")
+	}
+	verifiedErr := verifySignature(challenge.Encode(), signedMessage, InitiatorPublicKey)
 	if verifiedErr == nil {
 		return true, nil
 	} else {
@@ -156,52 +154,6 @@ func (r *Routers) execVerify(referer string, InitiatorPublicKey *ecdsa.PublicKey
 	}
 }
 
-func (r *Routers) dataBase(usrname string, passwd string, ip string, port string, databaseName string, tableName string) string {
-	dsn := usrname + ":" + passwd + "@tcp(" + ip + ":" + port + ")/" + databaseName
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		fmt.Printf("dsn:%s invalid,err:%v\n", dsn, err)
-		return nilData
-	}
-	defer db.Close()
-	err = db.Ping() //尝试连接数据库
-	if err != nil {
-		fmt.Printf("open %s faild,err:%v\n", dsn, err)
-		return nilData
-	}
-	sqlStr := "select * from " + tableName + ";"
-	rows, err := db.Query(sqlStr)
-	if err != nil {
-		fmt.Println(err)
-	}
-	// defer close result set
-	defer rows.Close()
-
-	cols, _ := rows.Columns()
-	var ret []map[string]interface{}
-	if len(cols) > 0 {
-		for rows.Next() {
-			buff := make([]interface{}, len(cols))
-			data := make([][]byte, len(cols)) //数据库中的NULL值可以扫描到字节中
-			for i := range buff {
-				buff[i] = &data[i]
-			}
-			rows.Scan(buff...) //扫描到buff接口中，实际是字符串类型data中
-			//将每一行数据存放到数组中
-			dataKv := make(map[string]interface{}, len(cols))
-			for k, col := range data { //k是index，col是对应的值
-				//fmt.Printf("%30s:\t%s\n", cols[k], col)
-				dataKv[cols[k]] = string(col)
-			}
-			ret = append(ret, dataKv)
-		}
-		retjson, _ := json.Marshal(ret)
-		return string(retjson)
-	} else {
-		return nilData
-	}
-}
-
 // ECIES 公钥数据加密
 func (r *Routers) EnCryptByEcies(srcData string, public_key *ecdsa.PublicKey) (cryptData string, err error) {
 	//获取公钥数据
@@ -0,0 +1,165 @@
+// Package secrets keeps credentials out of the config file in clear. A
+// secret in the config is one of
+//
+//	sealed:v1:<base64>   encrypted with AES-256-GCM under the key of the Store
+//	env:NAME             the environment variable NAME
+//	file:/path/to/file   the contents of a file, without trailing newline
+//	vault:path#field     a field of a secret in a Vault KV engine
+//	plain:text           the plaintext secret text, which may itself start
+//	                     with one of these prefixes
+//
+// Anything else is a plaintext secret as well. Plaintext secrets are read
+// as is but must be sealed before they are written back.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	sealedPrefix = "sealed:v1:"
+	envPrefix    = "env:"
+	filePrefix   = "file:"
+	vaultPrefix  = "vault:"
+	plainPrefix  = "plain:"
+)
+
+// KeySize is the size of the key sealing secrets
+const KeySize = 32
+
+// DeriveKey derives the sealing key from secret material, such as a master
+// key file or a private key, so that the key is never the material itself.
+func DeriveKey(material []byte) ([]byte, error) {
+	if len(material) < KeySize {
+		return nil, fmt.Errorf("key material must be at least %d bytes", KeySize)
+	}
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, material, nil, []byte("service-client config secrets v1")), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Store seals secrets and resolves them back, following references.
+type Store struct {
+	aead  cipher.AEAD
+	vault *Vault
+}
+
+// NewStore returns a store sealing with key. vault may be nil if no secret
+// refers to Vault.
+func NewStore(key []byte, vault *Vault) (*Store, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("sealing key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{aead: aead, vault: vault}, nil
+}
+
+// IsPlain reports whether value is a secret in clear. The empty value is
+// no secret at all.
+func IsPlain(value string) bool {
+	if value == "" {
+		return false
+	}
+	if strings.HasPrefix(value, plainPrefix) {
+		return true
+	}
+	for _, prefix := range []string{sealedPrefix, envPrefix, filePrefix, vaultPrefix} {
+		if strings.HasPrefix(value, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// Seal encrypts a plaintext secret, plain: or not. name identifies the place of the secret
+// in the config and is authenticated with it, so that a sealed value cannot
+// be moved to another place.
+func (s *Store) Seal(name string, plaintext string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	plaintext = strings.TrimPrefix(plaintext, plainPrefix)
+	sealed := s.aead.Seal(nonce, nonce, []byte(plaintext), []byte(name))
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Resolve returns the plaintext of the secret value at name.
+func (s *Store) Resolve(name string, value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, sealedPrefix):
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+		if err != nil || len(data) < s.aead.NonceSize() {
+			return "", fmt.Errorf("%s: malformed sealed secret", name)
+		}
+		nonce, ciphertext := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+		plaintext, err := s.aead.Open(nil, nonce, ciphertext, []byte(name))
+		if err != nil {
+			return "", fmt.Errorf("%s: sealed with another key or for another place", name)
+		}
+		return string(plaintext), nil
+	case strings.HasPrefix(value, vaultPrefix):
+		if s.vault == nil {
+			return "", fmt.Errorf("%s: refers to Vault, but no Vault is configured", name)
+		}
+		path, field, ok := strings.Cut(strings.TrimPrefix(value, vaultPrefix), "#")
+		if !ok || path == "" || field == "" {
+			return "", fmt.Errorf("%s: Vault reference must read vault:path#field", name)
+		}
+		secret, err := s.vault.Read(path, field)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		return secret, nil
+	default:
+		secret, err := ResolveLocal(value)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		return secret, nil
+	}
+}
+
+// ResolveLocal resolves the references that need no key, env: and file:,
+// unwraps plain: and returns any other value as is. It reads the secrets that unlock the
+// others, such as the Vault token.
+func ResolveLocal(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, envPrefix):
+		name := strings.TrimPrefix(value, envPrefix)
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, filePrefix):
+		data, err := os.ReadFile(strings.TrimPrefix(value, filePrefix))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(value, plainPrefix):
+		return strings.TrimPrefix(value, plainPrefix), nil
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,158 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newStore(t *testing.T, vault *Vault) *Store {
+	t.Helper()
+	key, err := DeriveKey(bytes.Repeat([]byte("k"), KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewStore(key, vault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestSealResolve(t *testing.T) {
+	store := newStore(t, nil)
+	for _, plaintext := range []string{"secret", "plain:env:HOME", "env:HOME"} {
+		sealed, err := store.Seal("Service-1/Password", plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if IsPlain(sealed) || strings.Contains(sealed, strings.TrimPrefix(plaintext, plainPrefix)) {
+			t.Fatalf("%q sealed as %q", plaintext, sealed)
+		}
+		got, err := store.Resolve("Service-1/Password", sealed)
+		if err != nil || got != strings.TrimPrefix(plaintext, plainPrefix) {
+			t.Errorf("%q resolved to %q, %v", plaintext, got, err)
+		}
+	}
+
+	sealed, err := store.Seal("Service-1/Password", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Resolve("Service-2/Password", sealed); err == nil {
+		t.Error("secret resolved at another place")
+	}
+	other, err := DeriveKey(bytes.Repeat([]byte("o"), KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherStore, err := NewStore(other, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := otherStore.Resolve("Service-1/Password", sealed); err == nil {
+		t.Error("secret resolved with another key")
+	}
+	if _, err := store.Resolve("Service-1/Password", sealedPrefix+"not base64"); err == nil {
+		t.Error("malformed sealed secret resolved")
+	}
+	if _, err := DeriveKey([]byte("short")); err == nil {
+		t.Error("key derived from short material")
+	}
+}
+
+func TestResolveLocal(t *testing.T) {
+	t.Setenv("SECRETS_TEST_PASSWORD", "from env")
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("from file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store := newStore(t, nil)
+	for value, want := range map[string]string{
+		"env:SECRETS_TEST_PASSWORD": "from env",
+		"file:" + path:              "from file",
+		"secret":                    "secret",
+		"plain:env:HOME":            "env:HOME",
+		"plain:file:/etc/passwd":    "file:/etc/passwd",
+		"plain:plain:x":             "plain:x",
+	} {
+		got, err := store.Resolve("Service-1/Password", value)
+		if err != nil || got != want {
+			t.Errorf("%q resolved to %q, %v, want %q", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"env:SECRETS_TEST_UNSET", "file:" + filepath.Join(t.TempDir(), "missing")} {
+		if _, err := store.Resolve("Service-1/Password", value); err == nil {
+			t.Errorf("%q resolved", value)
+		}
+	}
+}
+
+func TestIsPlain(t *testing.T) {
+	for value, want := range map[string]bool{
+		"":                   false,
+		"secret":             true,
+		"plain:env:HOME":     true,
+		"env:HOME":           false,
+		"file:/etc/password": false,
+		"vault:kv/db#pass":   false,
+		sealedPrefix + "AA":  false,
+	} {
+		if got := IsPlain(value); got != want {
+			t.Errorf("IsPlain(%q) = %v", value, got)
+		}
+	}
+}
+
+func TestVault(t *testing.T) {
+	secrets := map[string]interface{}{
+		// KV v1
+		"/v1/kv/db": map[string]interface{}{"data": map[string]interface{}{"password": "v1 secret", "port": 5432}},
+		// KV v2
+		"/v1/secret/data/db": map[string]interface{}{"data": map[string]interface{}{
+			"data":     map[string]interface{}{"password": "v2 secret"},
+			"metadata": map[string]interface{}{"version": 3},
+		}},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		secret, ok := secrets[req.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(secret)
+	}))
+	t.Cleanup(server.Close)
+
+	store := newStore(t, NewVault(server.URL+"/", "token"))
+	for value, want := range map[string]string{
+		"vault:kv/db#password":          "v1 secret",
+		"vault:secret/data/db#password": "v2 secret",
+	} {
+		got, err := store.Resolve("Service-1/Password", value)
+		if err != nil || got != want {
+			t.Errorf("%q resolved to %q, %v, want %q", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"vault:kv/db#user", "vault:kv/db#port", "vault:kv/missing#password", "vault:kv/db", "vault:#password"} {
+		if _, err := store.Resolve("Service-1/Password", value); err == nil {
+			t.Errorf("%q resolved", value)
+		}
+	}
+	if _, err := newStore(t, NewVault(server.URL, "other")).Resolve("Service-1/Password", "vault:kv/db#password"); err == nil {
+		t.Error("Vault read with another token")
+	}
+	if _, err := newStore(t, nil).Resolve("Service-1/Password", "vault:kv/db#password"); err == nil {
+		t.Error("Vault reference resolved without a Vault")
+	}
+}
@@ -0,0 +1,69 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Vault reads secrets from the HTTP API of a HashiCorp Vault KV engine, or
+// anything serving the same API. Both versions of the engine are
+// understood: a path under a v2 engine reads as "<mount>/data/<name>".
+type Vault struct {
+	Address string
+	Token   string
+	Client  *http.Client
+}
+
+// NewVault returns a client of the Vault at address authenticating with
+// token.
+func NewVault(address string, token string) *Vault {
+	return &Vault{Address: strings.TrimRight(address, "/"), Token: token, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Read returns field of the secret at path.
+func (v *Vault) Read(path string, field string) (string, error) {
+	u, err := url.Parse(v.Address + "/v1/" + strings.TrimLeft(path, "/"))
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	res, err := v.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from Vault: %w", path, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to read %s from Vault: %s", path, res.Status)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to read %s from Vault: %w", path, err)
+	}
+	data := body.Data
+	// KV v2 nests the secret with its metadata
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, versioned := data["metadata"]; versioned {
+			data = nested
+		}
+	}
+	value, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no field %s", path, field)
+	}
+	secret, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("field %s of secret %s is not a string", field, path)
+	}
+	return secret, nil
+}